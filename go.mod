@@ -8,10 +8,12 @@ require (
 	github.com/illumos/go-kstat v0.0.0-20210513183136-173c9b0a9973
 	github.com/influxdata/telegraf v1.16.3
 	github.com/stretchr/testify v1.9.0
+	golang.org/x/sys v0.0.0-20200826173525-f9321e4c35a6
 )
 
 require (
 	github.com/davecgh/go-spew v1.1.2-0.20180830191138-d8f796af33cc // indirect
+	github.com/gobwas/glob v0.2.3 // indirect
 	github.com/google/go-cmp v0.6.0 // indirect
 	github.com/kr/pretty v0.3.1 // indirect
 	github.com/pmezard/go-difflib v1.0.1-0.20181226105442-5d4384ee4fb2 // indirect
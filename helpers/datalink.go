@@ -0,0 +1,255 @@
+package helpers
+
+import (
+	"log"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// Datalink describes a data link as dladm(8) sees it. Over is the link or links it sits on, as
+// show-link gives them. Lower is the link at the bottom of that stack, and LowerClass its class,
+// so a VNIC on an etherstub can be told from one on an aggregation. Devices are the physical
+// devices at the bottom of the stack, of which an aggregation has one for each of its ports, and
+// an etherstub or a simnet has none. Speed is in Mbit/s. VLAN is zero if the link isn't on one.
+type Datalink struct {
+	Name       string
+	Class      string
+	Over       []string
+	Lower      string
+	LowerClass string
+	Devices    []string
+	MTU        int
+	VLAN       int
+	MAC        string
+	State      string
+	Speed      int
+	Zone       ZoneName
+}
+
+// DatalinkMap maps the name of a link to a Datalink which describes it.
+type DatalinkMap map[string]Datalink
+
+// DladmOutput holds the parseable output of the dladm commands a DatalinkMap is built from.
+type DladmOutput struct {
+	Link    string // show-link -po link,class,mtu,state,over
+	Phys    string // show-phys -po link,device,speed
+	PhysMAC string // show-phys -m -po link,slot,address
+	Aggr    string // show-aggr -x -po link,port,speed,address
+	Vnic    string // show-vnic -po link,over,speed,macaddress,vid,zone
+	Vlan    string // show-vlan -po link,vid,over
+}
+
+// dladmCommands are run in the global zone, or in a zone with an exclusive IP stack, which only
+// sees its own links. Etherstubs are in show-link, with their class, and there is nothing more to
+// know about them, so there's no need for show-etherstub.
+var dladmCommands = map[string]string{
+	"Link":    "/usr/sbin/dladm show-link -po link,class,mtu,state,over",
+	"Phys":    "/usr/sbin/dladm show-phys -po link,device,speed",
+	"PhysMAC": "/usr/sbin/dladm show-phys -m -po link,slot,address",
+	"Aggr":    "/usr/sbin/dladm show-aggr -x -po link,port,speed,address",
+	"Vnic":    "/usr/sbin/dladm show-vnic -po link,over,speed,macaddress,vid,zone",
+	"Vlan":    "/usr/sbin/dladm show-vlan -po link,vid,over",
+}
+
+// NewDatalinkMap describes every data link on the system. A dladm command which fails is logged,
+// and the links are described as well as they can be from the others.
+func NewDatalinkMap() DatalinkMap {
+	output := make(map[string]string, len(dladmCommands))
+
+	for name, cmd := range dladmCommands {
+		stdout, _, err := RunCmd(cmd)
+		if err != nil {
+			log.Printf("cannot get datalinks: %v", err)
+		}
+
+		output[name] = stdout
+	}
+
+	return ParseDatalinks(DladmOutput{
+		Link:    output["Link"],
+		Phys:    output["Phys"],
+		PhysMAC: output["PhysMAC"],
+		Aggr:    output["Aggr"],
+		Vnic:    output["Vnic"],
+		Vlan:    output["Vlan"],
+	})
+}
+
+// ParseDatalinks turns the output of the dladm commands into a DatalinkMap. It is public so
+// Telegraf tests can use it.
+func ParseDatalinks(raw DladmOutput) DatalinkMap {
+	ret := DatalinkMap{}
+
+	for _, chunks := range dladmLines(raw.Link, 5) {
+		mtu, _ := strconv.Atoi(chunks[2])
+		link := Datalink{Name: chunks[0], Class: chunks[1], MTU: mtu, State: chunks[3]}
+
+		if chunks[4] != "" {
+			link.Over = strings.Fields(chunks[4])
+		}
+
+		ret[link.Name] = link
+	}
+
+	devices := make(map[string]string)
+
+	for _, chunks := range dladmLines(raw.Phys, 3) {
+		devices[chunks[0]] = chunks[1]
+
+		ret.update(chunks[0], func(link *Datalink) {
+			link.Speed = parseSpeed(chunks[0], chunks[2])
+		})
+	}
+
+	for _, chunks := range dladmLines(raw.PhysMAC, 3) {
+		if chunks[1] == "primary" {
+			ret.update(chunks[0], func(link *Datalink) { link.MAC = chunks[2] })
+		}
+	}
+
+	// The first line for each aggregation has no port, and describes the aggregation itself.
+	for _, chunks := range dladmLines(raw.Aggr, 4) {
+		if chunks[1] != "" {
+			continue
+		}
+
+		ret.update(chunks[0], func(link *Datalink) {
+			link.Speed = parseSpeed(chunks[0], chunks[2])
+			link.MAC = chunks[3]
+		})
+	}
+
+	for _, chunks := range dladmLines(raw.Vnic, 6) {
+		ret.update(chunks[0], func(link *Datalink) {
+			link.Speed = parseSpeed(chunks[0], chunks[2])
+			link.MAC = chunks[3]
+			link.VLAN, _ = strconv.Atoi(chunks[4])
+			link.Zone = ZoneName(chunks[5])
+
+			if len(link.Over) == 0 {
+				link.Over = []string{chunks[1]}
+			}
+		})
+	}
+
+	// VLAN links have a class of vlan, and their VLAN ID is only in show-vlan.
+	for _, chunks := range dladmLines(raw.Vlan, 3) {
+		ret.update(chunks[0], func(link *Datalink) {
+			link.VLAN, _ = strconv.Atoi(chunks[1])
+
+			if len(link.Over) == 0 {
+				link.Over = []string{chunks[2]}
+			}
+		})
+	}
+
+	for name, link := range ret {
+		link.Lower, link.LowerClass, link.Devices = ret.lower(name, devices, map[string]bool{})
+		ret[name] = link
+	}
+
+	return ret
+}
+
+// parseSpeed turns a speed from dladm into Mbit/s. show-phys and show-vnic give a bare number,
+// but show-aggr -x puts a unit on the end, as in 1000Mb. A speed we can't read is logged, and
+// the link is left with no speed.
+func parseSpeed(link, raw string) int {
+	speed, err := strconv.Atoi(strings.TrimSuffix(raw, "Mb"))
+	if err != nil {
+		log.Printf("cannot parse speed of %s: %v", link, err)
+
+		return 0
+	}
+
+	return speed
+}
+
+// update changes the link with the given name, creating it if show-link didn't mention it.
+func (d DatalinkMap) update(name string, change func(*Datalink)) {
+	link, ok := d[name]
+	if !ok {
+		link = Datalink{Name: name}
+	}
+
+	change(&link)
+	d[name] = link
+}
+
+// lower follows a link down through whatever it is over, and returns the link at the bottom, its
+// class, and the physical devices under it. seen stops us going round in circles if dladm says
+// something odd.
+func (d DatalinkMap) lower(name string, devices map[string]string, seen map[string]bool) (string, string, []string) {
+	link := d[name]
+	seen[name] = true
+
+	if device, ok := devices[name]; ok {
+		return name, link.Class, []string{device}
+	}
+
+	switch link.Class {
+	case "aggr":
+		var ret []string
+
+		for _, port := range link.Over {
+			if device, ok := devices[port]; ok {
+				ret = append(ret, device)
+			}
+		}
+
+		sort.Strings(ret)
+
+		return name, link.Class, ret
+	case "etherstub", "simnet", "overlay":
+		return name, link.Class, nil
+	}
+
+	if len(link.Over) != 1 || seen[link.Over[0]] {
+		return name, link.Class, nil
+	}
+
+	return d.lower(link.Over[0], devices, seen)
+}
+
+// dladmLines splits parseable dladm output into lines of fields, dropping any line which doesn't
+// have the number of fields we asked for.
+func dladmLines(raw string, fields int) [][]string {
+	var ret [][]string
+
+	for _, line := range strings.Split(raw, "\n") {
+		if chunks := splitDladm(line); len(chunks) == fields {
+			ret = append(ret, chunks)
+		}
+	}
+
+	return ret
+}
+
+// splitDladm splits a line of parseable dladm output on its colons. Colons in a value, as in a MAC
+// address, are escaped with a backslash.
+func splitDladm(line string) []string {
+	if line == "" {
+		return nil
+	}
+
+	var (
+		ret   []string
+		field strings.Builder
+	)
+
+	for i := 0; i < len(line); i++ {
+		switch {
+		case line[i] == '\\' && i+1 < len(line):
+			i++
+			field.WriteByte(line[i])
+		case line[i] == ':':
+			ret = append(ret, field.String())
+			field.Reset()
+		default:
+			field.WriteByte(line[i])
+		}
+	}
+
+	return append(ret, field.String())
+}
@@ -0,0 +1,169 @@
+package helpers
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseDatalinks(t *testing.T) {
+	t.Parallel()
+
+	links := ParseDatalinks(sampleDladmOutput)
+
+	require.Len(t, links, 14)
+
+	require.Equal(
+		t,
+		Datalink{
+			Name:       "net0",
+			Class:      "phys",
+			Lower:      "net0",
+			LowerClass: "phys",
+			Devices:    []string{"e1000g0"},
+			MTU:        1500,
+			MAC:        "0:c:29:aa:bb:cc",
+			State:      "up",
+			Speed:      1000,
+		},
+		links["net0"],
+	)
+
+	require.Equal(
+		t,
+		Datalink{
+			Name:       "aggr0",
+			Class:      "aggr",
+			Over:       []string{"net2", "net1"},
+			Lower:      "aggr0",
+			LowerClass: "aggr",
+			Devices:    []string{"ixgbe0", "ixgbe1"},
+			MTU:        9000,
+			MAC:        "0:1b:21:0:0:1",
+			State:      "up",
+			Speed:      20000,
+		},
+		links["aggr0"],
+	)
+
+	require.Equal(
+		t,
+		Datalink{
+			Name:       "web_net0",
+			Class:      "vnic",
+			Over:       []string{"aggr0"},
+			Lower:      "aggr0",
+			LowerClass: "aggr",
+			Devices:    []string{"ixgbe0", "ixgbe1"},
+			MTU:        1500,
+			VLAN:       12,
+			MAC:        "2:8:20:1:2:3",
+			State:      "up",
+			Speed:      20000,
+			Zone:       "cube-web",
+		},
+		links["web_net0"],
+	)
+
+	require.Equal(
+		t,
+		Datalink{
+			Name:       "db_int0",
+			Class:      "vnic",
+			Over:       []string{"stub0"},
+			Lower:      "stub0",
+			LowerClass: "etherstub",
+			MTU:        9000,
+			MAC:        "2:8:20:4:5:6",
+			State:      "up",
+			Speed:      0,
+			Zone:       "cube-db",
+		},
+		links["db_int0"],
+	)
+
+	require.Equal(t, "vnic", links["dns_net0"].Class)
+	require.Equal(t, "net0", links["dns_net0"].Lower)
+	require.Equal(t, []string{"e1000g0"}, links["dns_net0"].Devices)
+	require.Equal(t, "simnet", links["sim0"].LowerClass)
+	require.Empty(t, links["sim0"].Devices)
+	require.Equal(t, "overlay", links["ovl_net0"].LowerClass)
+
+	require.Equal(
+		t,
+		Datalink{
+			Name:       "backup0",
+			Class:      "vlan",
+			Over:       []string{"aggr0"},
+			Lower:      "aggr0",
+			LowerClass: "aggr",
+			Devices:    []string{"ixgbe0", "ixgbe1"},
+			MTU:        9000,
+			VLAN:       40,
+			State:      "up",
+		},
+		links["backup0"],
+	)
+
+	require.Equal(t, 41, links["late_vlan0"].VLAN)
+	require.Equal(t, []string{"e1000g0"}, links["late_vlan0"].Devices)
+
+	// A VNIC show-link didn't know about still gets what show-vnic says.
+	require.Equal(t, ZoneName("cube-late"), links["late_net0"].Zone)
+	require.Equal(t, "e1000g0", links["late_net0"].Devices[0])
+
+	require.Equal(t, DatalinkMap{}, ParseDatalinks(DladmOutput{}))
+}
+
+func TestParseSpeed(t *testing.T) {
+	t.Parallel()
+
+	require.Equal(t, 1000, parseSpeed("net0", "1000"))
+	require.Equal(t, 20000, parseSpeed("aggr0", "20000Mb"))
+	require.Equal(t, 0, parseSpeed("aggr0", "fast"))
+}
+
+func TestSplitDladm(t *testing.T) {
+	t.Parallel()
+
+	require.Equal(
+		t,
+		[]string{"dns_net0", "net0", "1000", "2:8:20:d:e:f", "0", "cube-dns"},
+		splitDladm(`dns_net0:net0:1000:2\:8\:20\:d\:e\:f:0:cube-dns`),
+	)
+	require.Equal(t, []string{"net0", "phys", "1500", "up", ""}, splitDladm("net0:phys:1500:up:"))
+	require.Nil(t, splitDladm(""))
+}
+
+// sampleDladmOutput is in the form dladm prints it, down to show-aggr -x putting a unit on its
+// speeds, which the other commands don't.
+var sampleDladmOutput = DladmOutput{
+	Link: `net0:phys:1500:up:
+net1:phys:9000:up:
+net2:phys:9000:up:
+aggr0:aggr:9000:up:net2 net1
+stub0:etherstub:9000:unknown:
+sim0:simnet:1500:up:
+ovl0:overlay:1500:up:
+dns_net0:vnic:1500:up:net0
+web_net0:vnic:1500:up:aggr0
+db_int0:vnic:9000:up:stub0
+ovl_net0:vnic:1500:up:ovl0
+backup0:vlan:9000:up:aggr0`,
+	Phys: `net0:e1000g0:1000
+net1:ixgbe0:10000
+net2:ixgbe1:10000`,
+	PhysMAC: `net0:primary:0\:c\:29\:aa\:bb\:cc
+net0:1:0\:c\:29\:aa\:bb\:cd
+net1:primary:0\:1b\:21\:0\:0\:1
+net2:primary:0\:1b\:21\:0\:0\:2`,
+	Aggr: `aggr0::20000Mb:0\:1b\:21\:0\:0\:1
+aggr0:net1:10000Mb:0\:1b\:21\:0\:0\:1
+aggr0:net2:10000Mb:0\:1b\:21\:0\:0\:2`,
+	Vnic: `dns_net0:net0:1000:2\:8\:20\:d\:e\:f:0:cube-dns
+web_net0:aggr0:20000:2\:8\:20\:1\:2\:3:12:cube-web
+db_int0:stub0:0:2\:8\:20\:4\:5\:6:0:cube-db
+late_net0:net0:1000:2\:8\:20\:7\:8\:9:0:cube-late`,
+	Vlan: `backup0:40:aggr0
+late_vlan0:41:net0`,
+}
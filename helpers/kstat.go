@@ -1,20 +1,191 @@
 package helpers
 
 import (
+	"errors"
 	"fmt"
 	"log"
+	"sort"
+)
+
+// KStatSource is anything which can supply kstats. On illumos it is backed by libkstat, via
+// github.com/illumos/go-kstat. Everywhere else, and in tests, it is backed by serialized kstat
+// data on disk. Plugins should only ever talk to kstats through one of these, which means none of
+// them need cgo to build, or an illumos box to test.
+type KStatSource interface {
+	// All returns every kstat the source knows about.
+	All() []*KStat
+	// Lookup finds the kstat with the given module, instance and name.
+	Lookup(module string, instance int, name string) (*KStat, error)
+	// GetNamed is a shortcut for Lookup() followed by KStat.GetNamed().
+	GetNamed(module string, instance int, name, stat string) (*Named, error)
+	// AllNamed returns all the named statistics in a named kstat.
+	AllNamed(ks *KStat) ([]*Named, error)
+	// GetIO returns the data from an IO kstat.
+	GetIO(ks *KStat) (*IO, error)
+	// Vminfo returns the data from the raw unix:0:vminfo kstat.
+	Vminfo() (*Vminfo, error)
+	// Close releases the source. KStats obtained through it should not be used after this.
+	Close() error
+}
+
+// KSType is the type of data in a KStat. The values match KSTAT_TYPE_* in sys/kstat.h.
+type KSType int
+
+const (
+	RawStat   KSType = 0
+	NamedStat KSType = 1
+	IntrStat  KSType = 2
+	IoStat    KSType = 3
+	TimerStat KSType = 4
+)
 
-	kstat "github.com/illumos/go-kstat"
+func (t KSType) String() string {
+	switch t {
+	case RawStat:
+		return "raw"
+	case NamedStat:
+		return "named"
+	case IntrStat:
+		return "interrupt"
+	case IoStat:
+		return "io"
+	case TimerStat:
+		return "timer"
+	default:
+		return fmt.Sprintf("kstat_type:%d", t)
+	}
+}
+
+// NamedType is the type of a named statistic. The values match KSTAT_DATA_* in sys/kstat.h.
+type NamedType int
+
+const (
+	CharData NamedType = 0
+	Int32    NamedType = 1
+	Uint32   NamedType = 2
+	Int64    NamedType = 3
+	Uint64   NamedType = 4
+	String   NamedType = 9
 )
 
-// pulled out into a function to facilitate testing.
-var allKStats = func(token *kstat.Token) []*kstat.KStat {
-	return token.All()
+func (t NamedType) String() string {
+	switch t {
+	case CharData:
+		return "char"
+	case Int32:
+		return "int32"
+	case Uint32:
+		return "uint32"
+	case Int64:
+		return "int64"
+	case Uint64:
+		return "uint64"
+	case String:
+		return "string"
+	default:
+		return fmt.Sprintf("named_type-%d", t)
+	}
+}
+
+// KStat describes a module:instance:name kstat. Its exported fields mirror those of go-kstat's
+// KStat, so kstats serialized by that library decode straight into it.
+type KStat struct {
+	Module   string
+	Instance int
+	Name     string
+	Class    string
+	Type     KSType
+	Crtime   int64
+	Snaptime int64
+
+	source KStatSource
+}
+
+// Named is a single module:instance:name:statistic value. Only one of StringVal, IntVal and
+// UintVal is meaningful, depending on Type.
+type Named struct {
+	Name      string
+	Type      NamedType
+	StringVal string
+	IntVal    int64
+	UintVal   uint64
+	Snaptime  int64
+	KStat     *KStat
+}
+
+// IO is the data from an IO kstat, which is a kstat_io_t.
+type IO struct {
+	Nread       uint64
+	Nwritten    uint64
+	Reads       uint32
+	Writes      uint32
+	Wtime       int64
+	Wlentime    int64
+	Wlastupdate int64
+	Rtime       int64
+	Rlentime    int64
+	Rlastupdate int64
+	Wcnt        uint32
+	Rcnt        uint32
+}
+
+// Vminfo is the data from unix:0:vminfo, which is a vminfo_t.
+type Vminfo struct {
+	Freemem uint64
+	Resv    uint64
+	Alloc   uint64
+	Avail   uint64
+	Free    uint64
+	Updates uint64
+}
+
+var errNoSource = errors.New("kstat has no source")
+
+func (k *KStat) String() string {
+	return fmt.Sprintf("%s:%d:%s (%s)", k.Module, k.Instance, k.Name, k.Class)
+}
+
+// AllNamed returns all the named statistics in the kstat.
+func (k *KStat) AllNamed() ([]*Named, error) {
+	if k.source == nil {
+		return nil, errNoSource
+	}
+
+	return k.source.AllNamed(k)
+}
+
+// GetNamed returns the given named statistic from the kstat.
+func (k *KStat) GetNamed(name string) (*Named, error) {
+	stats, err := k.AllNamed()
+	if err != nil {
+		return nil, err
+	}
+
+	for _, stat := range stats {
+		if stat.Name == name {
+			return stat, nil
+		}
+	}
+
+	return nil, fmt.Errorf("no statistic '%s' in %s", name, k)
+}
+
+// GetIO returns the data from an IO kstat.
+func (k *KStat) GetIO() (*IO, error) {
+	if k.source == nil {
+		return nil, errNoSource
+	}
+
+	return k.source.GetIO(k)
+}
+
+func (n *Named) String() string {
+	return fmt.Sprintf("%s:%d:%s:%s", n.KStat.Module, n.KStat.Instance, n.KStat.Name, n.Name)
 }
 
 // NamedValue returns the useable value of the given named kstat. If said value is numeric, it is
 // sent as a float64, which is what Telegraf expects as a value.
-func NamedValue(stat *kstat.Named) interface{} {
+func NamedValue(stat *Named) interface{} {
 	switch stat.Type.String() {
 	case "string", "char":
 		return stat.StringVal
@@ -30,8 +201,8 @@ func NamedValue(stat *kstat.Named) interface{} {
 }
 
 // KStatIoClass returns a map of module:name => kstat for IO kstats.
-func KStatIoClass(token *kstat.Token, class string) map[string]*kstat.IO {
-	ret := make(map[string]*kstat.IO)
+func KStatIoClass(token KStatSource, class string) map[string]*IO {
+	ret := make(map[string]*IO)
 
 	for _, n := range token.All() {
 		if n.Class != class {
@@ -50,10 +221,10 @@ func KStatIoClass(token *kstat.Token, class string) map[string]*kstat.IO {
 }
 
 // KStatsInClass returns a list of kstats in the given class.
-func KStatsInClass(token *kstat.Token, class string) []*kstat.KStat {
-	var ret []*kstat.KStat
+func KStatsInClass(token KStatSource, class string) []*KStat {
+	var ret []*KStat
 
-	for _, stat := range allKStats(token) {
+	for _, stat := range token.All() {
 		if stat.Class == class {
 			ret = append(ret, stat)
 		}
@@ -69,10 +240,10 @@ func KStatsInClass(token *kstat.Token, class string) []*kstat.KStat {
 // cpu:0:vm
 // cpu:1:intrstat
 // ...
-func KStatsInModule(token *kstat.Token, module string) []*kstat.KStat {
-	var ret []*kstat.KStat
+func KStatsInModule(token KStatSource, module string) []*KStat {
+	var ret []*KStat
 
-	for _, stat := range allKStats(token) {
+	for _, stat := range token.All() {
 		if stat.Module == module {
 			ret = append(ret, stat)
 		}
@@ -80,3 +251,19 @@ func KStatsInModule(token *kstat.Token, module string) []*kstat.KStat {
 
 	return ret
 }
+
+// sortKStats puts a list of kstats into module:instance:name order, so sources which build their
+// lists from maps always present them in the same order.
+func sortKStats(stats []*KStat) {
+	sort.Slice(stats, func(i, j int) bool {
+		if stats[i].Module != stats[j].Module {
+			return stats[i].Module < stats[j].Module
+		}
+
+		if stats[i].Instance != stats[j].Instance {
+			return stats[i].Instance < stats[j].Instance
+		}
+
+		return stats[i].Name < stats[j].Name
+	})
+}
@@ -0,0 +1,126 @@
+package helpers
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// KStatFixture is the on-disk form of a kstat which is not a named kstat, as written by
+// capture_kstat. Named kstats are simply a serialized []*Named.
+type KStatFixture struct {
+	KStat  *KStat
+	IO     *IO
+	Vminfo *Vminfo
+}
+
+// NewFixtureKStatSource builds a KStatSource from the files in dir. Each kstat is a file called
+// module--instance--name.kstat, as written by capture_kstat. If dir also contains an all.kstat
+// written by capture_all_kstats, everything in that is served too, though the single-kstat files
+// take precedence.
+func NewFixtureKStatSource(dir string) (KStatSource, error) {
+	snapshot, err := loadFixtureSnapshot(dir)
+	if err != nil {
+		return nil, err
+	}
+
+	return newSnapshotKStatSource(snapshot), nil
+}
+
+func loadFixtureSnapshot(dir string) (*Snapshot, error) {
+	snapshot, err := LoadSnapshot(filepath.Join(dir, "all.kstat"))
+	if os.IsNotExist(err) {
+		snapshot = NewSnapshot()
+	} else if err != nil {
+		return nil, err
+	}
+
+	files, err := filepath.Glob(filepath.Join(dir, "*--*--*.kstat"))
+	if err != nil {
+		return nil, err
+	}
+
+	for _, file := range files {
+		if err := loadFixture(snapshot, file); err != nil {
+			return nil, err
+		}
+	}
+
+	return snapshot, nil
+}
+
+// kstatFromFilename turns module--instance--name.kstat into a bare KStat.
+func kstatFromFilename(file string) (*KStat, error) {
+	chunks := strings.Split(strings.TrimSuffix(filepath.Base(file), ".kstat"), "--")
+
+	if len(chunks) != 3 {
+		return nil, fmt.Errorf("cannot get kstat name from %s", file)
+	}
+
+	instance, err := strconv.Atoi(chunks[1])
+	if err != nil {
+		return nil, fmt.Errorf("cannot get kstat instance from %s", file)
+	}
+
+	return &KStat{Module: chunks[0], Instance: instance, Name: chunks[2]}, nil
+}
+
+// loadFixture adds the kstat in the given capture_kstat file to a snapshot.
+func loadFixture(snapshot *Snapshot, file string) error {
+	named, err := loadNamed(file)
+	if err == nil {
+		return addNamedFixture(snapshot, file, named)
+	}
+
+	var fixture KStatFixture
+
+	if err := decodeFile(file, &fixture); err != nil || fixture.KStat == nil {
+		return fmt.Errorf("cannot decode kstat fixture %s", file)
+	}
+
+	snapshot.add(fixture.KStat)
+
+	if fixture.IO != nil {
+		snapshot.IO[fixture.KStat.key()] = fixture.IO
+	}
+
+	if fixture.Vminfo != nil && fixture.KStat.key() == vminfoKey {
+		snapshot.Vminfo = fixture.Vminfo
+	}
+
+	return nil
+}
+
+// addNamedFixture files away a list of named stats. The header for the kstat comes from the
+// stats themselves, but if there are none, we work it out from the filename.
+func addNamedFixture(snapshot *Snapshot, file string, named []*Named) error {
+	var ks *KStat
+
+	if len(named) > 0 && named[0].KStat != nil {
+		ks = named[0].KStat
+	} else {
+		var err error
+
+		ks, err = kstatFromFilename(file)
+		if err != nil {
+			return err
+		}
+
+		ks.Type = NamedStat
+	}
+
+	snapshot.add(ks)
+	snapshot.Named[ks.key()] = named
+
+	return nil
+}
+
+func loadNamed(file string) ([]*Named, error) {
+	var named []*Named
+
+	err := decodeFile(file, &named)
+
+	return named, err
+}
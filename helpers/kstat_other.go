@@ -0,0 +1,11 @@
+//go:build !solaris
+
+package helpers
+
+import "errors"
+
+// NewKStatSource would open a kstat token on the running system, but this isn't illumos, so there
+// are no kstats to be had. Tests should use NewFixtureKStatSource instead.
+func NewKStatSource() (KStatSource, error) {
+	return nil, errors.New("kstats are only available on illumos")
+}
@@ -0,0 +1,156 @@
+package helpers
+
+import (
+	"fmt"
+
+	kstat "github.com/illumos/go-kstat"
+)
+
+// liveKStatSource is a KStatSource backed by libkstat.
+type liveKStatSource struct {
+	token *kstat.Token
+	// go-kstat keeps its KStats unique for the life of a token, so we can do the same.
+	wrapped map[*kstat.KStat]*KStat
+	raw     map[*KStat]*kstat.KStat
+}
+
+// NewKStatSource opens a kstat token on the running system. Close() it when you are done.
+func NewKStatSource() (KStatSource, error) {
+	token, err := kstat.Open()
+	if err != nil {
+		return nil, err
+	}
+
+	return &liveKStatSource{
+		token:   token,
+		wrapped: make(map[*kstat.KStat]*KStat),
+		raw:     make(map[*KStat]*kstat.KStat),
+	}, nil
+}
+
+func (s *liveKStatSource) wrap(raw *kstat.KStat) *KStat {
+	if ks, ok := s.wrapped[raw]; ok {
+		ks.Snaptime = raw.Snaptime
+
+		return ks
+	}
+
+	ks := &KStat{
+		Module:   raw.Module,
+		Instance: raw.Instance,
+		Name:     raw.Name,
+		Class:    raw.Class,
+		Type:     KSType(raw.Type),
+		Crtime:   raw.Crtime,
+		Snaptime: raw.Snaptime,
+		source:   s,
+	}
+
+	s.wrapped[raw] = ks
+	s.raw[ks] = raw
+
+	return ks
+}
+
+func (s *liveKStatSource) unwrap(ks *KStat) (*kstat.KStat, error) {
+	raw, ok := s.raw[ks]
+	if !ok {
+		return nil, fmt.Errorf("%s did not come from this source", ks)
+	}
+
+	return raw, nil
+}
+
+func (s *liveKStatSource) wrapNamed(raw *kstat.Named) *Named {
+	return &Named{
+		Name:      raw.Name,
+		Type:      NamedType(raw.Type),
+		StringVal: raw.StringVal,
+		IntVal:    raw.IntVal,
+		UintVal:   raw.UintVal,
+		Snaptime:  raw.Snaptime,
+		KStat:     s.wrap(raw.KStat),
+	}
+}
+
+func (s *liveKStatSource) All() []*KStat {
+	all := s.token.All()
+	ret := make([]*KStat, len(all))
+
+	for i, raw := range all {
+		ret[i] = s.wrap(raw)
+	}
+
+	return ret
+}
+
+func (s *liveKStatSource) Lookup(module string, instance int, name string) (*KStat, error) {
+	raw, err := s.token.Lookup(module, instance, name)
+	if err != nil {
+		return nil, err
+	}
+
+	return s.wrap(raw), nil
+}
+
+func (s *liveKStatSource) GetNamed(module string, instance int, name, stat string) (*Named, error) {
+	raw, err := s.token.GetNamed(module, instance, name, stat)
+	if err != nil {
+		return nil, err
+	}
+
+	return s.wrapNamed(raw), nil
+}
+
+func (s *liveKStatSource) AllNamed(ks *KStat) ([]*Named, error) {
+	raw, err := s.unwrap(ks)
+	if err != nil {
+		return nil, err
+	}
+
+	stats, err := raw.AllNamed()
+	if err != nil {
+		return nil, err
+	}
+
+	ret := make([]*Named, len(stats))
+
+	for i, stat := range stats {
+		ret[i] = s.wrapNamed(stat)
+	}
+
+	return ret, nil
+}
+
+func (s *liveKStatSource) GetIO(ks *KStat) (*IO, error) {
+	raw, err := s.unwrap(ks)
+	if err != nil {
+		return nil, err
+	}
+
+	io, err := raw.GetIO()
+	if err != nil {
+		return nil, err
+	}
+
+	s.wrap(raw)
+
+	ret := IO(*io)
+
+	return &ret, nil
+}
+
+func (s *liveKStatSource) Vminfo() (*Vminfo, error) {
+	_, vi, err := s.token.Vminfo()
+	if err != nil {
+		return nil, err
+	}
+
+	ret := Vminfo(*vi)
+
+	return &ret, nil
+}
+
+func (s *liveKStatSource) Close() error {
+	return s.token.Close()
+}
@@ -1,26 +1,20 @@
 package helpers
 
 import (
-	"encoding/gob"
-	"fmt"
-	"log"
-	"os"
 	"testing"
 
-	kstat "github.com/illumos/go-kstat"
 	"github.com/stretchr/testify/require"
 )
 
 func TestKStatsInClass(t *testing.T) {
 	t.Parallel()
 
-	allKStats = func(token *kstat.Token) []*kstat.KStat {
-		return allKStatsFromFixtures()
-	}
+	token, err := NewFixtureKStatSource("testdata")
+	require.NoError(t, err)
 
 	statNames := []string{}
 
-	for _, stat := range KStatsInClass(&kstat.Token{}, "errorq") {
+	for _, stat := range KStatsInClass(token, "errorq") {
 		statNames = append(statNames, stat.Name)
 	}
 
@@ -28,46 +22,82 @@ func TestKStatsInClass(t *testing.T) {
 
 	require.Equal(
 		t,
-		[]*kstat.KStat(nil),
-		KStatsInClass(&kstat.Token{}, "no_such_thing"),
+		[]*KStat(nil),
+		KStatsInClass(token, "no_such_thing"),
 	)
 }
 
 func TestKStatsInModule(t *testing.T) {
 	t.Parallel()
 
-	allKStats = func(token *kstat.Token) []*kstat.KStat {
-		return allKStatsFromFixtures()
-	}
+	token, err := NewFixtureKStatSource("testdata")
+	require.NoError(t, err)
 
-	for _, stat := range KStatsInModule(&kstat.Token{}, "cpu") {
+	for _, stat := range KStatsInModule(token, "cpu") {
 		require.Equal(t, "cpu", stat.Module)
 	}
 
-	require.Equal(t, 12, len(KStatsInModule(&kstat.Token{}, "cpu")))
+	require.Equal(t, 12, len(KStatsInModule(token, "cpu")))
 
 	require.Equal(
 		t,
-		[]*kstat.KStat(nil),
-		KStatsInModule(&kstat.Token{}, "no_such_thing"),
+		[]*KStat(nil),
+		KStatsInModule(token, "no_such_thing"),
 	)
 }
 
-func allKStatsFromFixtures() []*kstat.KStat {
-	var kstatData []*kstat.KStat
+func TestFixtureKStatSource(t *testing.T) {
+	t.Parallel()
 
-	raw, err := os.Open("testdata/all.kstat")
-	if err != nil {
-		log.Fatalf("Could not load serialized data from disk: %v\n", err)
-	}
+	token, err := NewFixtureKStatSource("testdata")
+	require.NoError(t, err)
 
-	dec := gob.NewDecoder(raw)
-	err = dec.Decode(&kstatData)
+	// Named kstat with data
+	stat, err := token.GetNamed("cpu", 3, "sys", "cpu_nsec_user")
+	require.NoError(t, err)
+	require.Equal(t, Uint64, stat.Type)
+	require.Equal(t, "cpu:3:sys:cpu_nsec_user", stat.String())
+	require.Equal(t, float64(105926508484786), NamedValue(stat))
 
-	if err != nil {
-		fmt.Fprintf(os.Stderr, "Could not load decode kstat data: %v\n", err)
-		os.Exit(1)
-	}
+	ks, err := token.Lookup("cpu", 3, "sys")
+	require.NoError(t, err)
+	require.Equal(t, "misc", ks.Class)
+
+	named, err := ks.AllNamed()
+	require.NoError(t, err)
+	require.NotEmpty(t, named)
+
+	// IO kstat
+	ks, err = token.Lookup("sd", 0, "sd0")
+	require.NoError(t, err)
+
+	io, err := ks.GetIO()
+	require.NoError(t, err)
+	require.Equal(t, uint64(51232768), io.Nread)
+
+	// vminfo
+	vminfo, err := token.Vminfo()
+	require.NoError(t, err)
+	require.Equal(t, uint64(186745), vminfo.Updates)
+
+	// a kstat which only exists in all.kstat has no data
+	ks, err = token.Lookup("unix", 0, "system_misc")
+	require.NoError(t, err)
+
+	_, err = ks.AllNamed()
+	require.Error(t, err)
+
+	_, err = token.Lookup("no", 0, "such_thing")
+	require.Error(t, err)
+}
+
+func TestNewFixtureKStatSourceNoFixtures(t *testing.T) {
+	t.Parallel()
+
+	token, err := NewFixtureKStatSource("no_such_dir")
+	require.NoError(t, err)
+	require.Empty(t, token.All())
 
-	return kstatData
+	_, err = token.Vminfo()
+	require.Error(t, err)
 }
@@ -0,0 +1,70 @@
+package helpers
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+
+	"github.com/influxdata/telegraf/filter"
+)
+
+// Matcher matches strings against a list of patterns. A pattern between slashes, like
+// /^python3?$/, is a regular expression. Anything else is a glob, as in Telegraf's namepass.
+type Matcher struct {
+	globs   filter.Filter
+	regexes []*regexp.Regexp
+}
+
+// NewMatcher returns nil if there are no patterns, which is a matcher that never matches.
+func NewMatcher(patterns []string) (*Matcher, error) {
+	if len(patterns) == 0 {
+		return nil, nil
+	}
+
+	var globs []string
+
+	ret := &Matcher{}
+
+	for _, pattern := range patterns {
+		if len(pattern) > 1 && strings.HasPrefix(pattern, "/") && strings.HasSuffix(pattern, "/") {
+			regex, err := regexp.Compile(pattern[1 : len(pattern)-1])
+			if err != nil {
+				return nil, fmt.Errorf("cannot compile filter pattern %s: %w", pattern, err)
+			}
+
+			ret.regexes = append(ret.regexes, regex)
+		} else {
+			globs = append(globs, pattern)
+		}
+	}
+
+	globFilter, err := filter.Compile(globs)
+	if err != nil {
+		return nil, fmt.Errorf("cannot compile filter patterns %v: %w", globs, err)
+	}
+
+	ret.globs = globFilter
+
+	return ret, nil
+}
+
+// Match is true if any of the values matches any of the patterns.
+func (m *Matcher) Match(values ...string) bool {
+	if m == nil {
+		return false
+	}
+
+	for _, value := range values {
+		if m.globs != nil && m.globs.Match(value) {
+			return true
+		}
+
+		for _, regex := range m.regexes {
+			if regex.MatchString(value) {
+				return true
+			}
+		}
+	}
+
+	return false
+}
@@ -0,0 +1,34 @@
+package helpers
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestMatcher(t *testing.T) {
+	t.Parallel()
+
+	m, err := NewMatcher([]string{"zpool-*", "sched", "/^python3?$/"})
+	require.NoError(t, err)
+
+	require.True(t, m.Match("sched"))
+	require.True(t, m.Match("zpool-rpool"))
+	require.True(t, m.Match("python"))
+	require.True(t, m.Match("python3"))
+	require.True(t, m.Match("java", "python3"))
+	require.False(t, m.Match("python3.11"))
+	require.False(t, m.Match("fsflush"))
+	require.False(t, m.Match())
+
+	m, err = NewMatcher([]string{"svc:/network/*"})
+	require.NoError(t, err)
+	require.True(t, m.Match("svc:/network/ssh:default"))
+
+	m, err = NewMatcher(nil)
+	require.NoError(t, err)
+	require.False(t, m.Match("anything"))
+
+	_, err = NewMatcher([]string{"/(unclosed/"})
+	require.Error(t, err)
+}
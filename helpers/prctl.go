@@ -0,0 +1,86 @@
+package helpers
+
+import (
+	"strconv"
+	"strings"
+)
+
+// RctlValue is one line of the parseable output of prctl(1): a value of a resource control on a
+// process, task, project or zone. Privilege is basic, privileged or system, or usage for the
+// current consumption of the resource, in which case only Value is set.
+type RctlValue struct {
+	EntityType string
+	EntityID   string
+	EntityName string
+	Name       string
+	Privilege  string
+	Value      uint64
+	Flag       string
+	Action     string
+	Recipient  string
+}
+
+var rctlEntityTypes = map[string]bool{
+	"process": true,
+	"task":    true,
+	"project": true,
+	"zone":    true,
+}
+
+// ParsePrctl turns the output of prctl -P into a list of values. It looks like
+//
+//	process: 8055: /usr/sbin/cron
+//	process.max-file-descriptor basic 256 - deny 8055
+//	process.max-file-descriptor privileged 65536 - deny -
+//	zone: 5: cube-ws
+//	zone.max-swap usage 2083221504
+//	zone.max-swap privileged 4294967296 - deny -
+//
+// Lines which can't be parsed are skipped.
+func ParsePrctl(raw string) []RctlValue {
+	var ret []RctlValue
+
+	var entity RctlValue
+
+	for _, line := range strings.Split(raw, "\n") {
+		if header := strings.SplitN(line, ": ", 3); len(header) > 1 {
+			entityType := strings.TrimSuffix(header[0], ":")
+
+			if rctlEntityTypes[entityType] {
+				entity = RctlValue{EntityType: entityType, EntityID: strings.TrimSuffix(header[1], ":")}
+
+				if len(header) == 3 {
+					entity.EntityName = header[2]
+				}
+
+				continue
+			}
+		}
+
+		chunks := strings.Fields(line)
+
+		if entity.EntityType == "" || len(chunks) < 3 {
+			continue
+		}
+
+		value, err := strconv.ParseUint(chunks[2], 10, 64)
+		if err != nil {
+			continue
+		}
+
+		rctl := entity
+		rctl.Name = chunks[0]
+		rctl.Privilege = chunks[1]
+		rctl.Value = value
+
+		if len(chunks) >= 6 {
+			rctl.Flag = chunks[3]
+			rctl.Action = chunks[4]
+			rctl.Recipient = chunks[5]
+		}
+
+		ret = append(ret, rctl)
+	}
+
+	return ret
+}
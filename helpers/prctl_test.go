@@ -0,0 +1,77 @@
+package helpers
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+const samplePrctl = `process: 8055: /usr/sbin/cron -x ext
+process.max-file-descriptor basic 256 - deny 8055
+process.max-file-descriptor privileged 65536 - deny -
+process.max-file-descriptor system 2147483647 max deny -
+zone: 5: cube-ws
+zone.max-swap usage 2083221504
+zone.max-swap privileged 4294967296 - deny -
+zone.cpu-cap usage 12
+zone.cpu-cap privileged 200 - deny -
+zone.cpu-cap system 4294967295 inf deny -
+project.max-shm-memory privileged junk - deny -
+task: 769
+task.max-lwps usage 3
+task.max-lwps system 2147483647 max deny -
+`
+
+func TestParsePrctl(t *testing.T) {
+	t.Parallel()
+
+	result := ParsePrctl(samplePrctl)
+
+	require.Len(t, result, 10)
+
+	require.Equal(
+		t,
+		RctlValue{
+			EntityType: "process",
+			EntityID:   "8055",
+			EntityName: "/usr/sbin/cron -x ext",
+			Name:       "process.max-file-descriptor",
+			Privilege:  "basic",
+			Value:      256,
+			Flag:       "-",
+			Action:     "deny",
+			Recipient:  "8055",
+		},
+		result[0],
+	)
+
+	require.Equal(
+		t,
+		RctlValue{
+			EntityType: "zone",
+			EntityID:   "5",
+			EntityName: "cube-ws",
+			Name:       "zone.max-swap",
+			Privilege:  "usage",
+			Value:      2083221504,
+		},
+		result[3],
+	)
+
+	require.Equal(t, "inf", result[7].Flag)
+
+	require.Equal(
+		t,
+		RctlValue{
+			EntityType: "task",
+			EntityID:   "769",
+			Name:       "task.max-lwps",
+			Privilege:  "usage",
+			Value:      3,
+		},
+		result[8],
+	)
+
+	require.Empty(t, ParsePrctl(""))
+	require.Empty(t, ParsePrctl("zone.max-swap usage 123"))
+}
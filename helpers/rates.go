@@ -0,0 +1,133 @@
+package helpers
+
+import (
+	"fmt"
+	"sync"
+)
+
+// Rates turns kstat counters into per-second rates. A plugin which offers `rates = true` holds
+// one for its lifetime, and it remembers the previous value of every counter it has been given,
+// keyed by module:instance:name:field. Intervals come from the kstat snaptime, not the wall
+// clock, so rates are right however late Gather() runs.
+type Rates struct {
+	mtx      sync.Mutex
+	previous map[string]rateSample
+	swept    map[string]bool
+}
+
+type rateSample struct {
+	value    uint64
+	crtime   int64
+	snaptime int64
+}
+
+// NewRates returns a Rates with no history.
+func NewRates() *Rates {
+	return &Rates{
+		previous: make(map[string]rateSample),
+		swept:    make(map[string]bool),
+	}
+}
+
+// RateKey is how Rates identifies a counter.
+func RateKey(ks *KStat, field string) string {
+	return fmt.Sprintf("%s:%s", ks.key(), field)
+}
+
+// CounterValue is what a plugin should emit for a named kstat which is a counter. That is its
+// raw value if r is nil, which is how plugins with rates turned off hold their Rates, or its rate
+// if not. The second value is false if there is nothing to emit.
+func (r *Rates) CounterValue(stat *Named) (float64, bool) {
+	if r == nil {
+		value, ok := NamedValue(stat).(float64)
+
+		return value, ok
+	}
+
+	return r.NamedRate(stat)
+}
+
+// NamedRate returns the per-second rate of change of a numeric named kstat. The second value is
+// false when there is no rate to give, which is the case the first time a counter is seen, and
+// after it has been reset.
+func (r *Rates) NamedRate(stat *Named) (float64, bool) {
+	if stat.KStat == nil {
+		return 0, false
+	}
+
+	snaptime := stat.Snaptime
+	if snaptime == 0 {
+		snaptime = stat.KStat.Snaptime
+	}
+
+	switch stat.Type {
+	case Int32:
+		return r.Rate(stat.KStat, stat.Name, uint64(stat.IntVal), 32, snaptime)
+	case Uint32:
+		return r.Rate(stat.KStat, stat.Name, stat.UintVal, 32, snaptime)
+	case Int64:
+		return r.Rate(stat.KStat, stat.Name, uint64(stat.IntVal), 64, snaptime)
+	case Uint64:
+		return r.Rate(stat.KStat, stat.Name, stat.UintVal, 64, snaptime)
+	default:
+		return 0, false
+	}
+}
+
+// Rate returns the per-second rate of change of a counter of the given width in bits, which
+// belongs to the given kstat. A counter which goes backwards has either wrapped or been reset.
+// If the wrapped difference is less than half the counter's range we call it a wrap, otherwise
+// a reset, which also happens if the kstat has been recreated (a zone reboot) or its snaptime has
+// gone backwards (a system reboot). A reset gives no rate: the new value becomes the baseline.
+func (r *Rates) Rate(ks *KStat, field string, value uint64, width int, snaptime int64) (float64, bool) {
+	r.mtx.Lock()
+	defer r.mtx.Unlock()
+
+	key := RateKey(ks, field)
+	current := rateSample{value: value, crtime: ks.Crtime, snaptime: snaptime}
+	previous, ok := r.previous[key]
+
+	r.swept[key] = true
+
+	if ok && previous.snaptime == snaptime && previous.crtime == ks.Crtime {
+		return 0, false
+	}
+
+	r.previous[key] = current
+
+	if !ok || previous.crtime != ks.Crtime || snaptime < previous.snaptime {
+		return 0, false
+	}
+
+	mask := ^uint64(0)
+	if width < 64 {
+		mask = (uint64(1) << width) - 1
+	}
+
+	delta := (value - previous.value) & mask
+
+	if value < previous.value && delta >= uint64(1)<<(width-1) {
+		return 0, false
+	}
+
+	return float64(delta) / (float64(snaptime-previous.snaptime) / 1e9), true
+}
+
+// Sweep forgets every counter which has not been seen since the last Sweep, so kstats which go
+// away, like those of a halted zone, do not leak. Call it at the end of Gather().
+func (r *Rates) Sweep() {
+	if r == nil {
+		return
+	}
+
+	r.mtx.Lock()
+	defer r.mtx.Unlock()
+
+	for key := range r.previous {
+		if !r.swept[key] {
+			delete(r.previous, key)
+		}
+	}
+
+	r.swept = make(map[string]bool)
+}
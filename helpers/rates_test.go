@@ -0,0 +1,110 @@
+package helpers
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestRate(t *testing.T) {
+	t.Parallel()
+
+	rates := NewRates()
+	ks := &KStat{Module: "link", Instance: 0, Name: "net0", Crtime: 100}
+
+	_, ok := rates.Rate(ks, "obytes", 1000, 64, 1e9)
+	require.False(t, ok)
+
+	rate, ok := rates.Rate(ks, "obytes", 3000, 64, 3e9)
+	require.True(t, ok)
+	require.Equal(t, float64(1000), rate)
+
+	// same snaptime, so nothing new
+	_, ok = rates.Rate(ks, "obytes", 3000, 64, 3e9)
+	require.False(t, ok)
+
+	// a 32-bit counter wraps
+	_, ok = rates.Rate(ks, "opackets", 4294967000, 32, 1e9)
+	require.False(t, ok)
+
+	rate, ok = rates.Rate(ks, "opackets", 200, 32, 2e9)
+	require.True(t, ok)
+	require.Equal(t, float64(496), rate)
+
+	// a counter resets
+	_, ok = rates.Rate(ks, "obytes", 10, 64, 4e9)
+	require.False(t, ok)
+
+	rate, ok = rates.Rate(ks, "obytes", 20, 64, 5e9)
+	require.True(t, ok)
+	require.Equal(t, float64(10), rate)
+
+	// the system reboots
+	_, ok = rates.Rate(ks, "obytes", 30, 64, 1e9)
+	require.False(t, ok)
+
+	// the kstat is recreated
+	recreated := *ks
+	recreated.Crtime = 200
+
+	_, ok = rates.Rate(&recreated, "obytes", 40, 64, 2e9)
+	require.False(t, ok)
+
+	rate, ok = rates.Rate(&recreated, "obytes", 50, 64, 3e9)
+	require.True(t, ok)
+	require.Equal(t, float64(10), rate)
+}
+
+func TestNamedRate(t *testing.T) {
+	t.Parallel()
+
+	rates := NewRates()
+	ks := &KStat{Module: "nfs", Instance: 0, Name: "rfsreqcnt_v3"}
+
+	_, ok := rates.NamedRate(&Named{Name: "read", Type: Uint64, UintVal: 10, Snaptime: 1e9, KStat: ks})
+	require.False(t, ok)
+
+	rate, ok := rates.NamedRate(&Named{Name: "read", Type: Uint64, UintVal: 15, Snaptime: 2e9, KStat: ks})
+	require.True(t, ok)
+	require.Equal(t, float64(5), rate)
+
+	_, ok = rates.NamedRate(&Named{Name: "name", Type: String, StringVal: "x", Snaptime: 3e9, KStat: ks})
+	require.False(t, ok)
+}
+
+func TestRatesSweep(t *testing.T) {
+	t.Parallel()
+
+	rates := NewRates()
+	ks := &KStat{Module: "zones", Instance: 1, Name: "zone1"}
+
+	rates.Rate(ks, "nsec_user", 10, 64, 1e9)
+	rates.Sweep()
+	rates.Sweep()
+
+	_, ok := rates.Rate(ks, "nsec_user", 20, 64, 2e9)
+	require.False(t, ok)
+
+	rates.Sweep()
+
+	_, ok = rates.Rate(ks, "nsec_user", 30, 64, 3e9)
+	require.True(t, ok)
+}
+
+func TestCounterValue(t *testing.T) {
+	t.Parallel()
+
+	ks := &KStat{Module: "nfs", Instance: 0, Name: "rfsreqcnt_v3"}
+	stat := &Named{Name: "read", Type: Uint64, UintVal: 10, Snaptime: 1e9, KStat: ks}
+
+	var noRates *Rates
+
+	value, ok := noRates.CounterValue(stat)
+	require.True(t, ok)
+	require.Equal(t, float64(10), value)
+
+	noRates.Sweep()
+
+	_, ok = NewRates().CounterValue(stat)
+	require.False(t, ok)
+}
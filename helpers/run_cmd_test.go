@@ -3,6 +3,7 @@ package helpers
 import (
 	"os"
 	"os/exec"
+	"runtime"
 	"testing"
 
 	"github.com/stretchr/testify/require"
@@ -46,6 +47,10 @@ func TestRunCmdDisallowed(t *testing.T) {
 func TestRunCmdZlogin(t *testing.T) {
 	t.Parallel()
 
+	if runtime.GOOS != "illumos" && runtime.GOOS != "solaris" {
+		t.Skip("zones only exist on illumos")
+	}
+
 	// This can raise different errors depending on the zone it's run in, and possibly on
 	// privileges, so let's just assert an error.
 	stdout, stderr, err := RunCmdInZone("/bin/pfexec", "/bin/date", "no-such-zone")
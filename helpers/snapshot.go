@@ -0,0 +1,300 @@
+package helpers
+
+import (
+	"bytes"
+	"encoding/gob"
+	"errors"
+	"fmt"
+	"os"
+	"sync"
+)
+
+const vminfoKey = "unix:0:vminfo"
+
+// Snapshot is every kstat on a system, with its data, at one point in time. It is what
+// capture_all_kstats writes to disk, and it can be served back to any plugin as a KStatSource.
+// Named and IO data are keyed by module:instance:name.
+type Snapshot struct {
+	KStats []*KStat
+	Named  map[string][]*Named
+	IO     map[string]*IO
+	Vminfo *Vminfo
+}
+
+// NewSnapshot returns an empty snapshot.
+func NewSnapshot() *Snapshot {
+	return &Snapshot{
+		Named: make(map[string][]*Named),
+		IO:    make(map[string]*IO),
+	}
+}
+
+func (k *KStat) key() string {
+	return fmt.Sprintf("%s:%d:%s", k.Module, k.Instance, k.Name)
+}
+
+// add puts a kstat header into the snapshot, replacing any existing one with the same name.
+func (s *Snapshot) add(ks *KStat) {
+	for i, existing := range s.KStats {
+		if existing.key() == ks.key() {
+			s.KStats[i] = ks
+
+			return
+		}
+	}
+
+	s.KStats = append(s.KStats, ks)
+}
+
+// TakeSnapshot reads every kstat the source knows about. Kstats whose data can't be read, or
+// which are neither named nor IO kstats, are recorded without data.
+func TakeSnapshot(token KStatSource) *Snapshot {
+	snapshot := NewSnapshot()
+
+	for _, ks := range token.All() {
+		switch ks.Type {
+		case NamedStat:
+			named, err := ks.AllNamed()
+			if err != nil {
+				break
+			}
+
+			snapshot.Named[ks.key()] = stripNamed(named)
+		case IoStat:
+			io, err := ks.GetIO()
+			if err != nil {
+				break
+			}
+
+			snapshot.IO[ks.key()] = io
+		}
+
+		header := *ks
+		header.source = nil
+		snapshot.KStats = append(snapshot.KStats, &header)
+	}
+
+	if vminfo, err := token.Vminfo(); err == nil {
+		snapshot.Vminfo = vminfo
+	}
+
+	return snapshot
+}
+
+// stripNamed copies a list of named stats without the pointer back to the parent kstat. Every
+// stat would otherwise drag a copy of the kstat header into the serialized snapshot.
+func stripNamed(named []*Named) []*Named {
+	ret := make([]*Named, len(named))
+
+	for i, stat := range named {
+		stripped := *stat
+		stripped.KStat = nil
+		ret[i] = &stripped
+	}
+
+	return ret
+}
+
+// Write serializes the snapshot to the given file.
+func (s *Snapshot) Write(file string) error {
+	var buf bytes.Buffer
+
+	if err := gob.NewEncoder(&buf).Encode(s); err != nil {
+		return err
+	}
+
+	return os.WriteFile(file, buf.Bytes(), 0o644) //nolint:gosec
+}
+
+// LoadSnapshot reads a snapshot written by capture_all_kstats. Older versions of that tool wrote
+// only the kstat headers, and those files load as a snapshot with no data.
+func LoadSnapshot(file string) (*Snapshot, error) {
+	snapshot := NewSnapshot()
+
+	err := decodeFile(file, snapshot)
+	if err == nil {
+		return snapshot, nil
+	}
+
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, err
+	}
+
+	var headers []*KStat
+
+	if decodeFile(file, &headers) != nil {
+		return nil, fmt.Errorf("cannot decode kstat snapshot %s: %w", file, err)
+	}
+
+	snapshot.KStats = headers
+
+	return snapshot, nil
+}
+
+func decodeFile(file string, target interface{}) error {
+	raw, err := os.Open(file)
+	if err != nil {
+		return err
+	}
+
+	defer raw.Close()
+
+	return gob.NewDecoder(raw).Decode(target)
+}
+
+// snapshotKStatSource serves a Snapshot through the KStatSource interface.
+type snapshotKStatSource struct {
+	snapshot *Snapshot
+	kstats   []*KStat
+	byKey    map[string]*KStat
+}
+
+// NewSnapshotKStatSource returns a KStatSource which serves the given snapshot.
+func NewSnapshotKStatSource(snapshot *Snapshot) KStatSource {
+	return newSnapshotKStatSource(snapshot)
+}
+
+// Each source gets its own copy of the kstat headers, so they point back to the right source.
+func newSnapshotKStatSource(snapshot *Snapshot) *snapshotKStatSource {
+	s := &snapshotKStatSource{
+		snapshot: snapshot,
+		kstats:   make([]*KStat, len(snapshot.KStats)),
+		byKey:    make(map[string]*KStat, len(snapshot.KStats)),
+	}
+
+	for i, header := range snapshot.KStats {
+		ks := *header
+		ks.source = s
+		s.kstats[i] = &ks
+		s.byKey[ks.key()] = &ks
+	}
+
+	sortKStats(s.kstats)
+
+	return s
+}
+
+func (s *snapshotKStatSource) All() []*KStat {
+	return s.kstats
+}
+
+func (s *snapshotKStatSource) Lookup(module string, instance int, name string) (*KStat, error) {
+	key := fmt.Sprintf("%s:%d:%s", module, instance, name)
+
+	ks, ok := s.byKey[key]
+	if !ok {
+		return nil, fmt.Errorf("no kstat %s", key)
+	}
+
+	return ks, nil
+}
+
+func (s *snapshotKStatSource) GetNamed(module string, instance int, name, stat string) (*Named, error) {
+	ks, err := s.Lookup(module, instance, name)
+	if err != nil {
+		return nil, err
+	}
+
+	return ks.GetNamed(stat)
+}
+
+func (s *snapshotKStatSource) AllNamed(ks *KStat) ([]*Named, error) {
+	named, ok := s.snapshot.Named[ks.key()]
+	if !ok {
+		return nil, fmt.Errorf("no named data for %s", ks)
+	}
+
+	ret := make([]*Named, len(named))
+
+	for i, stat := range named {
+		linked := *stat
+		linked.KStat = ks
+		ret[i] = &linked
+	}
+
+	return ret, nil
+}
+
+func (s *snapshotKStatSource) GetIO(ks *KStat) (*IO, error) {
+	io, ok := s.snapshot.IO[ks.key()]
+	if !ok {
+		return nil, fmt.Errorf("no IO data for %s", ks)
+	}
+
+	ret := *io
+
+	return &ret, nil
+}
+
+func (s *snapshotKStatSource) Vminfo() (*Vminfo, error) {
+	if s.snapshot.Vminfo == nil {
+		return nil, fmt.Errorf("no data for %s", vminfoKey)
+	}
+
+	ret := *s.snapshot.Vminfo
+
+	return &ret, nil
+}
+
+func (s *snapshotKStatSource) Close() error {
+	return nil
+}
+
+// Replay serves a sequence of snapshots, one per tick. Point a plugin's kstat source at Next()
+// and each call to Gather() sees the next snapshot, so a capture_all_kstats run from a customer's
+// box can be played back through any plugin, offline.
+type Replay struct {
+	mtx       sync.Mutex
+	snapshots []*Snapshot
+	tick      int
+}
+
+// NewReplay loads the given capture_all_kstats files, in order.
+func NewReplay(files ...string) (*Replay, error) {
+	snapshots := make([]*Snapshot, len(files))
+
+	for i, file := range files {
+		snapshot, err := LoadSnapshot(file)
+		if err != nil {
+			return nil, err
+		}
+
+		snapshots[i] = snapshot
+	}
+
+	return NewReplayFromSnapshots(snapshots...), nil
+}
+
+// NewReplayFromSnapshots replays snapshots which are already in memory.
+func NewReplayFromSnapshots(snapshots ...*Snapshot) *Replay {
+	return &Replay{snapshots: snapshots}
+}
+
+// Ticks returns the number of snapshots in the replay.
+func (r *Replay) Ticks() int {
+	return len(r.snapshots)
+}
+
+// Next returns a source for the current snapshot, and moves on to the next one. It has the same
+// signature as NewKStatSource. Once every snapshot has been served, it returns an error.
+func (r *Replay) Next() (KStatSource, error) {
+	r.mtx.Lock()
+	defer r.mtx.Unlock()
+
+	if r.tick >= len(r.snapshots) {
+		return nil, fmt.Errorf("replay finished after %d ticks", len(r.snapshots))
+	}
+
+	source := newSnapshotKStatSource(r.snapshots[r.tick])
+	r.tick++
+
+	return source, nil
+}
+
+// Rewind sends the replay back to the first snapshot.
+func (r *Replay) Rewind() {
+	r.mtx.Lock()
+	defer r.mtx.Unlock()
+
+	r.tick = 0
+}
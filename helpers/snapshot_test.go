@@ -0,0 +1,94 @@
+package helpers
+
+import (
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestLoadSnapshotLegacy(t *testing.T) {
+	t.Parallel()
+
+	snapshot, err := LoadSnapshot(filepath.Join("testdata", "all.kstat"))
+	require.NoError(t, err)
+	require.Equal(t, 1566, len(snapshot.KStats))
+	require.Empty(t, snapshot.Named)
+	require.Nil(t, snapshot.Vminfo)
+
+	_, err = LoadSnapshot(filepath.Join("testdata", "no_such_file"))
+	require.Error(t, err)
+}
+
+func TestReplay(t *testing.T) {
+	t.Parallel()
+
+	token, err := NewFixtureKStatSource("testdata")
+	require.NoError(t, err)
+
+	snapshot := TakeSnapshot(token)
+	require.Equal(t, len(token.All()), len(snapshot.KStats))
+
+	dir := t.TempDir()
+	files := []string{filepath.Join(dir, "all.000.kstat"), filepath.Join(dir, "all.001.kstat")}
+
+	require.NoError(t, snapshot.Write(files[0]))
+
+	snapshot.Vminfo.Updates++
+	require.NoError(t, snapshot.Write(files[1]))
+
+	replay, err := NewReplay(files...)
+	require.NoError(t, err)
+	require.Equal(t, 2, replay.Ticks())
+
+	for _, expected := range []uint64{186745, 186746} {
+		tick, err := replay.Next()
+		require.NoError(t, err)
+
+		vminfo, err := tick.Vminfo()
+		require.NoError(t, err)
+		require.Equal(t, expected, vminfo.Updates)
+
+		stat, err := tick.GetNamed("cpu", 3, "sys", "cpu_nsec_user")
+		require.NoError(t, err)
+		require.Equal(t, float64(105926508484786), NamedValue(stat))
+		require.Equal(t, "misc", stat.KStat.Class)
+
+		ks, err := tick.Lookup("sd", 0, "sd0")
+		require.NoError(t, err)
+
+		io, err := ks.GetIO()
+		require.NoError(t, err)
+		require.Equal(t, uint64(51232768), io.Nread)
+	}
+
+	_, err = replay.Next()
+	require.Error(t, err)
+
+	replay.Rewind()
+
+	_, err = replay.Next()
+	require.NoError(t, err)
+}
+
+func TestUseFixtureReplay(t *testing.T) {
+	t.Parallel()
+
+	source := NewKStatSource
+
+	t.Run("replay", func(t *testing.T) {
+		UseFixtureReplay(t, &source, "cpu_nsec_user")
+
+		for range 2 {
+			tick, err := source()
+			require.NoError(t, err)
+
+			stat, err := tick.GetNamed("cpu", 3, "sys", "cpu_nsec_user")
+			require.NoError(t, err)
+			require.Equal(t, float64(105926508484786), NamedValue(stat))
+		}
+	})
+
+	require.Equal(t, reflect.ValueOf(NewKStatSource).Pointer(), reflect.ValueOf(source).Pointer())
+}
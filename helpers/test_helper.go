@@ -1,30 +1,24 @@
 package helpers
 
 import (
-	"encoding/gob"
 	"fmt"
 	"log"
 	"os"
 	"path/filepath"
-
-	kstat "github.com/illumos/go-kstat"
+	"slices"
+	"testing"
 )
 
 // FromFixture loads serialized kstat data off disk and returns the real data. The filename is
 // relative to testdata/.
-func FromFixture(filename string) []*kstat.Named {
-	var kstatData []*kstat.Named
-
+func FromFixture(filename string) []*Named {
 	filename = filepath.Join("testdata", filename)
-	raw, err := os.Open(filename)
 
-	if err != nil {
+	if _, err := os.Stat(filename); err != nil {
 		log.Fatalf("Could not load serialized data from disk: %v\n", err)
 	}
 
-	dec := gob.NewDecoder(raw)
-	err = dec.Decode(&kstatData)
-
+	kstatData, err := loadNamed(filename)
 	if err != nil {
 		fmt.Fprintf(os.Stderr, "Could not load decode kstat data: %v\n", err)
 		os.Exit(1)
@@ -32,3 +26,84 @@ func FromFixture(filename string) []*kstat.Named {
 
 	return kstatData
 }
+
+// FixtureKStatSource returns a function which plugins can use in place of NewKStatSource, so
+// their Gather() methods see the fixtures in testdata/ instead of a live system.
+func FixtureKStatSource() func() (KStatSource, error) {
+	return func() (KStatSource, error) {
+		return NewFixtureKStatSource("testdata")
+	}
+}
+
+// FixtureReplay returns a two-tick Replay of the fixtures in testdata/, for testing rates. The
+// second tick is ten seconds after the first, and every unsigned value in it has doubled, so the
+// rate of any counter is a tenth of its value in the fixture. Signed values, which are mostly IDs,
+// are left alone, as are any unsigned stats named in keep.
+func FixtureReplay(keep ...string) (*Replay, error) {
+	first, err := loadFixtureSnapshot("testdata")
+	if err != nil {
+		return nil, err
+	}
+
+	second, err := loadFixtureSnapshot("testdata")
+	if err != nil {
+		return nil, err
+	}
+
+	doubleSnapshot(second, 10e9, keep)
+
+	return NewReplayFromSnapshots(first, second), nil
+}
+
+// UseFixtureReplay points source, which is a plugin's newKStatSource, at a FixtureReplay for the
+// rest of the test, and puts back whatever was there before when the test is done. A test which
+// uses it can't run in parallel with others in its package.
+func UseFixtureReplay(tb testing.TB, source *func() (KStatSource, error), keep ...string) {
+	tb.Helper()
+
+	replay, err := FixtureReplay(keep...)
+	if err != nil {
+		tb.Fatalf("cannot replay fixtures: %v", err)
+	}
+
+	previous := *source
+	*source = replay.Next
+
+	tb.Cleanup(func() { *source = previous })
+}
+
+func doubleSnapshot(snapshot *Snapshot, interval int64, keep []string) {
+	for _, ks := range snapshot.KStats {
+		ks.Snaptime += interval
+	}
+
+	for _, named := range snapshot.Named {
+		for _, stat := range named {
+			stat.Snaptime += interval
+
+			if !slices.Contains(keep, stat.Name) {
+				stat.UintVal *= 2
+			}
+		}
+	}
+
+	if vi := snapshot.Vminfo; vi != nil {
+		vi.Freemem *= 2
+		vi.Resv *= 2
+		vi.Alloc *= 2
+		vi.Avail *= 2
+		vi.Free *= 2
+		vi.Updates *= 2
+	}
+
+	for _, io := range snapshot.IO {
+		io.Nread *= 2
+		io.Nwritten *= 2
+		io.Reads *= 2
+		io.Writes *= 2
+		io.Wtime *= 2
+		io.Wlentime *= 2
+		io.Rtime *= 2
+		io.Rlentime *= 2
+	}
+}
@@ -1,40 +1,51 @@
 package main
 
-// Serializes all kstats to disk. Useful for generating fixture data to mock out tests which
-// require kstats.
+// Serializes every kstat on the system, with its data, to disk. Useful for generating fixture
+// data to mock out tests which require kstats, and for replaying a whole system through a plugin
+// with helpers.NewReplay(). With -count greater than one, a sequence of snapshots is written,
+// -interval apart, to all.000.kstat, all.001.kstat and so on.
 
 import (
-	"bytes"
-	"encoding/gob"
+	"flag"
 	"fmt"
 	"os"
+	"strings"
+	"time"
 
-	"github.com/illumos/go-kstat"
+	"github.com/snltd/illumos-telegraf-plugins/helpers"
 )
 
 func main() {
-	token, err := kstat.Open()
-	if err != nil {
-		fmt.Fprintln(os.Stderr, "Cannot get kstat token.")
+	count := flag.Int("count", 1, "number of snapshots to take")
+	interval := flag.Duration("interval", 10*time.Second, "time between snapshots")
+	out := flag.String("out", "all.kstat", "file to write")
+	flag.Parse()
+
+	for tick := 0; tick < *count; tick++ {
+		if tick > 0 {
+			time.Sleep(*interval)
+		}
+
+		file := *out
+
+		if *count > 1 {
+			file = fmt.Sprintf("%s.%03d.kstat", strings.TrimSuffix(*out, ".kstat"), tick)
+		}
+
+		if err := capture(file); err != nil {
+			fmt.Fprintf(os.Stderr, "Could not write %s: %v\n", file, err)
+			os.Exit(1)
+		}
 	}
+}
 
-	stats := token.All()
-	fmt.Printf("%T\n", stats)
-
-	var buf bytes.Buffer
-
-	enc := gob.NewEncoder(&buf)
-	err = enc.Encode(stats)
-
+func capture(file string) error {
+	token, err := helpers.NewKStatSource()
 	if err != nil {
-		fmt.Fprintf(os.Stderr, "Could not encode data %v\n", err)
-		os.Exit(1)
+		return err
 	}
 
-	err = os.WriteFile("all.kstat", buf.Bytes(), 0o644)
+	defer token.Close()
 
-	if err != nil {
-		fmt.Fprintf(os.Stderr, "Could not write serialized data to disk: %v\n", err)
-		os.Exit(1)
-	}
+	return helpers.TakeSnapshot(token).Write(file)
 }
@@ -1,8 +1,9 @@
 package main
 
-// Serializes all Named kstats under the given path, to disk. Useful for generating fixture data
-// to mock out tests which require kstats.
-// The data is of type []*kstat.Named, the filename is the kstat name with `.kstat` appended.
+// Serializes the kstat at the given path, to disk. Useful for generating fixture data to mock out
+// tests which require kstats.
+// Named kstats are written as []*helpers.Named. IO kstats and unix:0:vminfo are written as a
+// helpers.KStatFixture. The filename is the kstat name with `.kstat` appended.
 
 import (
 	"bytes"
@@ -12,7 +13,7 @@ import (
 	"strconv"
 	"strings"
 
-	"github.com/illumos/go-kstat"
+	"github.com/snltd/illumos-telegraf-plugins/helpers"
 )
 
 func main() {
@@ -34,20 +35,47 @@ func main() {
 	instance, _ := strconv.Atoi(chunks[1])
 	name := chunks[2]
 
-	token, err := kstat.Open()
+	token, err := helpers.NewKStatSource()
 	if err != nil {
 		fmt.Fprintln(os.Stderr, "Cannot get kstat token.")
+		os.Exit(1)
 	}
 
+	defer token.Close()
+
 	rawKstat, err := token.Lookup(module, instance, name)
 	if err != nil {
 		fmt.Fprintln(os.Stderr, "Cannot get kstat.")
 		os.Exit(1)
 	}
 
-	stats, err := rawKstat.AllNamed()
-	if err != nil {
-		fmt.Fprintln(os.Stderr, "Failed to get named kstat data.")
+	var stats interface{}
+
+	switch {
+	case rawKstat.Type == helpers.NamedStat:
+		stats, err = rawKstat.AllNamed()
+		if err != nil {
+			fmt.Fprintln(os.Stderr, "Failed to get named kstat data.")
+			os.Exit(1)
+		}
+	case rawKstat.Type == helpers.IoStat:
+		io, err := rawKstat.GetIO()
+		if err != nil {
+			fmt.Fprintln(os.Stderr, "Failed to get IO kstat data.")
+			os.Exit(1)
+		}
+
+		stats = helpers.KStatFixture{KStat: rawKstat, IO: io}
+	case module == "unix" && name == "vminfo":
+		vminfo, err := token.Vminfo()
+		if err != nil {
+			fmt.Fprintln(os.Stderr, "Failed to get vminfo kstat data.")
+			os.Exit(1)
+		}
+
+		stats = helpers.KStatFixture{KStat: rawKstat, Vminfo: vminfo}
+	default:
+		fmt.Fprintf(os.Stderr, "Cannot capture %s kstats.\n", rawKstat.Type)
 		os.Exit(1)
 	}
 
@@ -1,7 +1,8 @@
 package main
 
-// Grabs the psinfo and usage for the given proc and serialises them to disk, for use as
-// fixtures in testing the illumos_process Telegraf collector
+// Grabs the psinfo and usage for the given procs, and the lwpsinfo and lwpusage of each of their
+// LWPs, and serialises them to disk, for use as fixtures in testing the illumos_process Telegraf
+// collector. The files are laid out as they are in /proc.
 
 import (
 	"bytes"
@@ -12,47 +13,59 @@ import (
 	"path"
 )
 
-func getPsinfo(pid string) psinfo_t {
-	var psinfo psinfo_t
-
-	file := fmt.Sprintf("/proc/%s/psinfo", pid)
-	fh, err := os.Open(file)
-
+// readProcStruct reads a /proc file into the given struct. A file shorter than the struct, as
+// lwpsinfo is on systems from before thread names, is padded out with zeroes.
+func readProcStruct(file string, target interface{}) error {
+	raw, err := os.ReadFile(file)
 	if err != nil {
-		fmt.Fprintf(os.Stderr, "Could not open psinfo for %s", pid)
-		os.Exit(1)
+		return err
 	}
 
-	err = binary.Read(fh, binary.LittleEndian, &psinfo)
+	buf := make([]byte, binary.Size(target))
+	copy(buf, raw)
 
-	if err != nil {
-		fmt.Fprintf(os.Stderr, "Could not capture psinfo")
+	return binary.Read(bytes.NewReader(buf), binary.LittleEndian, target)
+}
+
+// capture reads the given /proc file into target, and writes it, gob-encoded, to the same path
+// under the current directory.
+func capture(file string, target interface{}) {
+	if err := readProcStruct(path.Join("/proc", file), target); err != nil {
+		fmt.Fprintf(os.Stderr, "Could not capture %s: %v\n", file, err)
 		os.Exit(1)
 	}
 
-	return psinfo
-}
-
-func getUsage(pid string) prusage_t {
-	file := fmt.Sprintf("/proc/%s/usage", pid)
+	var buf bytes.Buffer
 
-	var prusage prusage_t
+	if err := gob.NewEncoder(&buf).Encode(target); err != nil {
+		fmt.Fprintf(os.Stderr, "Could not encode %s: %v\n", file, err)
+		os.Exit(1)
+	}
 
-	fh, err := os.Open(file)
+	if err := os.MkdirAll(path.Dir(file), os.ModePerm); err != nil {
+		fmt.Fprintf(os.Stderr, "Could not create directory: %s\n", err)
+		os.Exit(2)
+	}
 
-	if err != nil {
-		fmt.Fprintf(os.Stderr, "Could not open usage")
+	if err := os.WriteFile(file, buf.Bytes(), 0o644); err != nil { //nolint
+		fmt.Fprintf(os.Stderr, "Could not write serialized %s to disk: %v\n", file, err)
 		os.Exit(1)
 	}
+}
 
-	err = binary.Read(fh, binary.LittleEndian, &prusage)
-
+func captureLwps(pid string) {
+	entries, err := os.ReadDir(path.Join("/proc", pid, "lwp"))
 	if err != nil {
-		fmt.Fprintf(os.Stderr, "Could not capture usage")
+		fmt.Fprintf(os.Stderr, "Could not list LWPs of %s: %v\n", pid, err)
 		os.Exit(1)
 	}
 
-	return prusage
+	for _, entry := range entries {
+		lwpDir := path.Join(pid, "lwp", entry.Name())
+
+		capture(path.Join(lwpDir, "lwpsinfo"), &lwpsinfo_t{})
+		capture(path.Join(lwpDir, "lwpusage"), &prusage_t{})
+	}
 }
 
 func main() {
@@ -62,50 +75,13 @@ func main() {
 	}
 
 	for _, pid := range os.Args[1:] {
-		psinfoDir := pid
-
-		if err := os.Mkdir(psinfoDir, os.ModePerm); err != nil {
-			fmt.Fprintf(os.Stderr, "Could not create directory: %s", err)
+		if _, err := os.Stat(pid); err == nil {
+			fmt.Fprintf(os.Stderr, "%s already exists\n", pid)
 			os.Exit(2)
 		}
 
-		psinfoFile := path.Join(psinfoDir, "psinfo")
-
-		var psinfoBuf bytes.Buffer
-
-		psinfo := getPsinfo(pid)
-		enc := gob.NewEncoder(&psinfoBuf)
-		err := enc.Encode(psinfo)
-
-		if err != nil {
-			fmt.Fprintf(os.Stderr, "Could not encode psinfo data %v\n", err)
-			os.Exit(1)
-		}
-
-		err = os.WriteFile(psinfoFile, psinfoBuf.Bytes(), 0o644) //nolint
-
-		if err != nil {
-			fmt.Fprintf(os.Stderr, "Could not write serialized psinfo data to disk: %v\n", err)
-			os.Exit(1)
-		}
-
-		var usageBuf bytes.Buffer
-
-		usageFile := path.Join(psinfoDir, "usage")
-		usage := getUsage(pid)
-		enc = gob.NewEncoder(&usageBuf)
-		err = enc.Encode(usage)
-
-		if err != nil {
-			fmt.Fprintf(os.Stderr, "Could not encode usage data %v\n", err)
-			os.Exit(1)
-		}
-
-		err = os.WriteFile(usageFile, usageBuf.Bytes(), 0o644) //nolint
-
-		if err != nil {
-			fmt.Fprintf(os.Stderr, "Could not write serialized usage data to disk: %v\n", err)
-			os.Exit(1)
-		}
+		capture(path.Join(pid, "psinfo"), &psinfo_t{})
+		capture(path.Join(pid, "usage"), &prusage_t{})
+		captureLwps(pid)
 	}
 }
@@ -91,3 +91,33 @@ type psinfo_t struct {
 	Pr_filler   int32     /* reserved for future use */
 	Pr_lwp      [128]byte /* information for representative lwp */
 }
+
+type processorid_t int32
+type psetid_t int32
+
+type lwpsinfo_t struct {
+	Pr_flag        int32     /* lwp flags (DEPRECATED; do not use) */
+	Pr_lwpid       id_t      /* lwp id */
+	Pr_addr        uintptr_t /* internal address of lwp */
+	Pr_wchan       uintptr_t /* wait addr for sleeping lwp */
+	Pr_stype       byte      /* synchronization event type */
+	Pr_state       byte      /* numeric lwp state */
+	Pr_sname       byte      /* printable character for pr_state */
+	Pr_nice        byte      /* nice for cpu usage */
+	Pr_syscall     int16     /* system call number (if in syscall) */
+	Pr_oldpri      byte      /* pre-SVR4, low value is high priority */
+	Pr_cpu         byte      /* pre-SVR4, cpu usage for scheduling */
+	Pr_pri         int32     /* priority, high value is high priority */
+	Pr_pctcpu      ushort_t  /* % of recent cpu time used by this lwp */
+	Pr_pad         ushort_t
+	Pr_start       timestruc_t   /* lwp start time, from the epoch */
+	Pr_time        timestruc_t   /* usr+sys cpu time for this lwp */
+	Pr_clname      [8]byte       /* scheduling class name */
+	Pr_oldname     [16]byte      /* name of system lwp, before thread names */
+	Pr_onpro       processorid_t /* processor which last ran this lwp */
+	Pr_bindpro     processorid_t /* processor to which lwp is bound */
+	Pr_bindpset    psetid_t      /* processor set to which lwp is bound */
+	Pr_lgrp        int32         /* lwp home lgroup */
+	Pr_last_onproc int64         /* Timestamp of when thread last ran */
+	Pr_name        [32]byte      /* name of lwp */
+}
@@ -0,0 +1,136 @@
+package cpu
+
+import (
+	"log"
+	"strings"
+
+	"github.com/influxdata/telegraf"
+	"github.com/snltd/illumos-telegraf-plugins/helpers"
+)
+
+// capCounters are the caps:N:cpucaps_zone_N stats which only go up. Everything else is a gauge.
+// baseline, effective, burst_limit_sec, bursting_sec and above_base_sec only exist on SmartOS.
+var capCounters = map[string]bool{
+	"above_sec":      true,
+	"below_sec":      true,
+	"above_base_sec": true,
+	"bursting_sec":   true,
+}
+
+var capGauges = map[string]bool{
+	"value":           true,
+	"baseline":        true,
+	"effective":       true,
+	"burst_limit_sec": true,
+	"usage":           true,
+	"nwait":           true,
+	"maxusage":        true,
+}
+
+// runPrctl gets the FSS shares of the given zones, in prctl's parseable format.
+var runPrctl = func(zones []string) (string, error) {
+	stdout, _, err := helpers.RunCmd(
+		"/usr/bin/prctl -P -n zone.cpu-shares -t privileged -i zone " + strings.Join(zones, " "),
+	)
+
+	return stdout, err
+}
+
+// parseZoneCapKStats pulls the fields out of a cpucaps_zone kstat. The second value is the zone
+// name.
+func parseZoneCapKStats(s *IllumosCPU, stats []*helpers.Named) (map[string]interface{}, string) {
+	fields := make(map[string]interface{})
+	zone := ""
+
+	for _, stat := range stats {
+		switch {
+		case stat.Name == "zonename":
+			zone = stat.StringVal
+		case capGauges[stat.Name]:
+			fields[stat.Name] = helpers.NamedValue(stat)
+		case capCounters[stat.Name]:
+			if value, ok := s.rates.CounterValue(stat); ok {
+				fields[stat.Name] = value
+			}
+		}
+	}
+
+	return fields, zone
+}
+
+// parseZoneShares turns the output of runPrctl into a map of zone name to shares.
+func parseZoneShares(raw string) map[string]float64 {
+	ret := make(map[string]float64)
+
+	for _, rctl := range helpers.ParsePrctl(raw) {
+		if rctl.EntityType != "zone" || rctl.Name != "zone.cpu-shares" || rctl.Privilege != "privileged" {
+			continue
+		}
+
+		if rctl.EntityName != "" {
+			ret[rctl.EntityName] = float64(rctl.Value)
+		}
+	}
+
+	return ret
+}
+
+// zoneNames lists the zones the kernel knows about, from their zones:N:name kstats.
+func zoneNames(token helpers.KStatSource) []string {
+	var ret []string
+
+	for _, ks := range helpers.KStatsInModule(token, "zones") {
+		ret = append(ret, ks.Name)
+	}
+
+	return ret
+}
+
+// gatherZoneCapStats reports on CPU caps and FSS shares, for each zone which has either. Shares
+// come from prctl, and if we can't get them, we report the caps anyway.
+func gatherZoneCapStats(s *IllumosCPU, acc telegraf.Accumulator, token helpers.KStatSource) error {
+	zoneFields := make(map[string]map[string]interface{})
+
+	for _, ks := range helpers.KStatsInModule(token, "caps") {
+		if !strings.HasPrefix(ks.Name, "cpucaps_zone_") {
+			continue
+		}
+
+		stats, err := ks.AllNamed()
+		if err != nil {
+			log.Print("cannot get zone CPU cap named stats")
+
+			return err
+		}
+
+		fields, zone := parseZoneCapKStats(s, stats)
+		if zone != "" {
+			zoneFields[zone] = fields
+		}
+	}
+
+	zones := zoneNames(token)
+
+	if len(zones) > 0 {
+		raw, err := runPrctl(zones)
+		if err != nil {
+			log.Printf("cannot get zone CPU shares: %v", err)
+		}
+
+		for zone, shares := range parseZoneShares(raw) {
+			if zoneFields[zone] == nil {
+				zoneFields[zone] = make(map[string]interface{})
+			}
+
+			zoneFields[zone]["shares"] = shares
+		}
+	}
+
+	for zone, fields := range zoneFields {
+		if len(fields) > 0 {
+			acc.AddFields("cpu.zone.cap", fields, map[string]string{"name": zone})
+		}
+	}
+
+	return nil
+}
@@ -1,38 +1,54 @@
 package cpu
 
 /*
-Collects information about illumos CPU usage. The values it outputs are the raw kstat values,
-which means they are counters, and they only go up. I wrap them in a rate() function in
-Wavefront, which is plenty good enough for me.
-
-Features to add, possibly:
-	- option to aggregate CPU metrics across all cores and/or CPUs.
-	- deal with multiple cores AND multiple physical processors. I don't have a machine with the
-	  latter.
-	- emit rates rather than raw values. This would let us do proper percentages.
+Collects information about illumos CPU usage. By default the values it outputs are the raw kstat
+values, which means they are counters, and they only go up. I wrap them in a rate() function in
+Wavefront, which is plenty good enough for me. Set rates = true to have the plugin do that
+instead.
+
+With percentages = true it also works out, from the difference between successive calls to
+Gather(), what percentage of its time each CPU spent in each state, like mpstat does. Those
+percentages are aggregated by chip, and across the whole system.
+
+With mpstat_stats = true it reports the columns of mpstat(8), along with interrupt load broken
+down by priority level and by device. These are always rates.
 */
 
 import (
 	"fmt"
 	"log"
+	"sort"
+	"strconv"
 	"strings"
 
-	"github.com/illumos/go-kstat"
 	"github.com/influxdata/telegraf"
 	"github.com/influxdata/telegraf/plugins/inputs"
 	"github.com/snltd/illumos-telegraf-plugins/helpers"
 )
 
 var sampleConfig = `
-  ## Report stuff from the cpu_info kstat. As of now it's just the current clock speed and some
-  ## potentially useful tags
+  ## Report stuff from the cpu_info kstat: clock speed, P-state and C-state, with some
+  ## potentially useful tags, and the utilisation of hardware processor groups
   # cpu_info_stats = true
-  ## Produce metrics for sys and user CPU consumption in every zone
+  ## Produce metrics for sys and user CPU consumption, and run queue wait time, in every zone
   # zone_cpu_stats = true
+  ## Report the CPU cap and usage of every capped zone, and the FSS shares of every zone. Shares
+  ## come from prctl(1).
+  # zone_cap_stats = false
   ## Which cpu:sys kstat metrics you wish to emit. They probably won't all work, because they
   ## some will have a value type which is not an unsigned int
   # sys_fields = ["cpu_nsec_dtrace", "cpu_nsec_intr", "cpu_nsec_kernel", "cpu_nsec_user"]
   ## "cpu_ticks_idle", cpu_ticks_kernel", cpu_ticks_user", cpu_ticks_wait", }
+  ## Emit per-second rates rather than raw counters. Nothing is reported for a counter until it
+  ## has been seen twice.
+  # rates = false
+  ## Emit user, kernel, idle, intr and dtrace time as percentages, for each CPU, each chip, and
+  ## the whole system. Nothing is reported until the second collection.
+  # percentages = false
+  ## Emit the minf, mjf, xcal, intr, ithr, csw, icsw, migr, smtx, srw and syscl columns of
+  ## mpstat(8) for each CPU, along with interrupt time and counts by priority level and by device.
+  ## These are always per-second rates, so nothing is reported until the second collection.
+  # mpstat_stats = false
 `
 
 func (s *IllumosCPU) Description() string {
@@ -46,17 +62,43 @@ func (s *IllumosCPU) SampleConfig() string {
 type IllumosCPU struct {
 	CPUInfoStats bool
 	ZoneCPUStats bool
+	ZoneCapStats bool
 	SysFields    []string
+	Rates        bool
+	Percentages  bool
+	MpstatStats  bool
+	rates        *helpers.Rates
+	usageRates   *helpers.Rates
+	mpstatRates  *helpers.Rates
+}
+
+// cpu:sys stats which are not counters, so are never turned into rates.
+var sysGauges = map[string]bool{
+	"cpu_load_intr": true,
+	"iowait":        true,
 }
 
-func parseCPUinfoKStats(stats []*kstat.Named) (map[string]interface{}, map[string]string) {
+var newKStatSource = helpers.NewKStatSource
+
+func parseCPUinfoKStats(stats []*helpers.Named) (map[string]interface{}, map[string]string) {
 	fields := make(map[string]interface{})
 	tags := make(map[string]string)
 
+	var speed uint64
+
 	for _, stat := range stats {
 		switch stat.Name {
 		case "current_clock_Hz":
+			speed = stat.UintVal
 			fields["speed"] = float64(stat.UintVal)
+		case "supported_frequencies_Hz":
+			tags["supportedFrequencies"] = stat.StringVal
+		case "current_cstate":
+			fields["cstate"] = float64(stat.IntVal)
+		case "supported_max_cstates":
+			fields["max_cstate"] = float64(stat.IntVal)
+		case "state_begin":
+			fields["state_begin"] = float64(stat.IntVal)
 		case "clock_MHz":
 			tags["clockMHz"] = fmt.Sprintf("%d", stat.IntVal)
 		case "state":
@@ -68,10 +110,43 @@ func parseCPUinfoKStats(stats []*kstat.Named) (map[string]interface{}, map[strin
 		}
 	}
 
+	if state, ok := pstate(speed, tags["supportedFrequencies"]); ok {
+		fields["pstate"] = float64(state)
+	}
+
 	return fields, tags
 }
 
-func gatherCPUinfoStats(acc telegraf.Accumulator, token *kstat.Token) error {
+// pstate works out the P-state of a CPU from its current clock speed and the speeds it supports,
+// which cpu_info gives us as a colon-separated list. P0 is the fastest.
+func pstate(speed uint64, supported string) (int, bool) {
+	if speed == 0 || supported == "" {
+		return 0, false
+	}
+
+	var speeds []uint64
+
+	for _, chunk := range strings.Split(supported, ":") {
+		hz, err := strconv.ParseUint(chunk, 10, 64)
+		if err != nil {
+			return 0, false
+		}
+
+		speeds = append(speeds, hz)
+	}
+
+	sort.Slice(speeds, func(i, j int) bool { return speeds[i] > speeds[j] })
+
+	for i, hz := range speeds {
+		if hz == speed {
+			return i, true
+		}
+	}
+
+	return 0, false
+}
+
+func gatherCPUinfoStats(s *IllumosCPU, acc telegraf.Accumulator, token helpers.KStatSource) error {
 	stats := helpers.KStatsInModule(token, "cpu_info")
 
 	for _, stat := range stats {
@@ -86,19 +161,86 @@ func gatherCPUinfoStats(acc telegraf.Accumulator, token *kstat.Token) error {
 		acc.AddFields("cpu.info", fields, tags)
 	}
 
+	return gatherPGStats(s, acc, token)
+}
+
+// pg_hw_perf stats which only go up. Everything else we report is a gauge.
+var pgCounters = map[string]bool{
+	"hw_util":              true,
+	"hw_util_time_running": true,
+	"hw_util_time_stopped": true,
+}
+
+var pgGauges = map[string]bool{
+	"ncpus":            true,
+	"generation":       true,
+	"hw_util_rate":     true,
+	"hw_util_rate_max": true,
+}
+
+func parsePGKStats(s *IllumosCPU, stats []*helpers.Named) (map[string]interface{}, map[string]string) {
+	fields := make(map[string]interface{})
+	tags := make(map[string]string)
+
+	for _, stat := range stats {
+		switch {
+		case stat.Name == "relationship":
+			tags["relationship"] = stat.StringVal
+		case stat.Name == "cpus":
+			tags["cpus"] = stat.StringVal
+		case stat.Name == "pg_id":
+			tags["pgID"] = fmt.Sprintf("%d", stat.IntVal)
+		case pgGauges[stat.Name]:
+			fields[stat.Name] = helpers.NamedValue(stat)
+		case pgCounters[stat.Name]:
+			if value, ok := s.rates.CounterValue(stat); ok {
+				fields[stat.Name] = value
+			}
+		}
+	}
+
+	return fields, tags
+}
+
+// gatherPGStats reports the utilisation of the hardware processor groups (sockets, caches,
+// pipelines and so on) which power management looks at, from the pg_hw_perf kstats.
+func gatherPGStats(s *IllumosCPU, acc telegraf.Accumulator, token helpers.KStatSource) error {
+	for _, ks := range helpers.KStatsInModule(token, "pg_hw_perf") {
+		namedStats, err := ks.AllNamed()
+		if err != nil {
+			log.Print("cannot get processor group named stats")
+
+			return err
+		}
+
+		fields, tags := parsePGKStats(s, namedStats)
+
+		if len(fields) > 0 {
+			acc.AddFields("cpu.pg", fields, tags)
+		}
+	}
+
 	return nil
 }
 
-func parseZoneCPUKStats(stats []*kstat.Named) (map[string]interface{}, map[string]string) {
+func parseZoneCPUKStats(s *IllumosCPU, stats []*helpers.Named) (map[string]interface{}, map[string]string) {
 	fields := make(map[string]interface{})
 	tags := make(map[string]string)
 
 	for _, stat := range stats {
 		switch stat.Name {
 		case "nsec_sys":
-			fields["sys"] = float64(stat.UintVal)
+			if value, ok := s.rates.CounterValue(stat); ok {
+				fields["sys"] = value
+			}
 		case "nsec_user":
-			fields["user"] = float64(stat.UintVal)
+			if value, ok := s.rates.CounterValue(stat); ok {
+				fields["user"] = value
+			}
+		case "nsec_waitrq":
+			if value, ok := s.rates.CounterValue(stat); ok {
+				fields["waitrq"] = value
+			}
 		case "zonename":
 			tags["name"] = stat.StringVal
 		}
@@ -107,9 +249,9 @@ func parseZoneCPUKStats(stats []*kstat.Named) (map[string]interface{}, map[strin
 	return fields, tags
 }
 
-// metrics reporting on CPU consumption for each zone. sys and user, each as a gauge, tagged with
-// the zone name.
-func gatherZoneCPUStats(acc telegraf.Accumulator, token *kstat.Token) error {
+// metrics reporting on CPU consumption for each zone. sys, user and time spent waiting on the run
+// queue, each as a gauge, tagged with the zone name.
+func gatherZoneCPUStats(s *IllumosCPU, acc telegraf.Accumulator, token helpers.KStatSource) error {
 	zoneStats := helpers.KStatsInModule(token, "zones")
 
 	for _, zone := range zoneStats {
@@ -120,28 +262,42 @@ func gatherZoneCPUStats(acc telegraf.Accumulator, token *kstat.Token) error {
 			return err
 		}
 
-		fields, tags := parseZoneCPUKStats(namedStats)
+		fields, tags := parseZoneCPUKStats(s, namedStats)
 
-		acc.AddFields("cpu.zone", fields, tags)
+		if len(fields) > 0 {
+			acc.AddFields("cpu.zone", fields, tags)
+		}
 	}
 
 	return nil
 }
 
-func parseSysCPUKStats(s *IllumosCPU, stats []*kstat.Named) map[string]interface{} {
+func parseSysCPUKStats(s *IllumosCPU, stats []*helpers.Named) map[string]interface{} {
 	fields := make(map[string]interface{})
 
 	for _, stat := range stats {
-		if helpers.WeWant(stat.Name, s.SysFields) {
+		if !helpers.WeWant(stat.Name, s.SysFields) {
+			continue
+		}
+
+		if sysGauges[stat.Name] {
 			fields[fieldToMetricPath(stat.Name)] = float64(stat.UintVal)
+		} else if value, ok := s.rates.CounterValue(stat); ok {
+			fields[fieldToMetricPath(stat.Name)] = value
 		}
 	}
 
 	return fields
 }
 
-func gatherSysCPUStats(s *IllumosCPU, acc telegraf.Accumulator, token *kstat.Token) error {
+func gatherSysCPUStats(
+	s *IllumosCPU,
+	acc telegraf.Accumulator,
+	token helpers.KStatSource,
+	chips map[int]string,
+) error {
 	cpuStats := helpers.KStatsInModule(token, "cpu")
+	totals := newUsageTotals()
 
 	for _, cpu := range cpuStats {
 		if cpu.Name == "sys" {
@@ -152,14 +308,26 @@ func gatherSysCPUStats(s *IllumosCPU, acc telegraf.Accumulator, token *kstat.Tok
 				return err
 			}
 
-			acc.AddFields(
-				"cpu",
-				parseSysCPUKStats(s, namedStats),
-				map[string]string{"coreID": fmt.Sprintf("%d", cpu.Instance)},
-			)
+			coreID := fmt.Sprintf("%d", cpu.Instance)
+			fields := parseSysCPUKStats(s, namedStats)
+
+			if len(fields) > 0 {
+				acc.AddFields("cpu", fields, map[string]string{"coreID": coreID})
+			}
+
+			if !s.Percentages {
+				continue
+			}
+
+			if usage, ok := s.usage(namedStats); ok {
+				acc.AddFields("cpu.percent", usage.fields(), cpuTags(cpu.Instance, chips))
+				totals.add(chipID(chips, cpu.Instance), usage)
+			}
 		}
 	}
 
+	gatherUsageTotals(acc, totals)
+
 	return nil
 }
 
@@ -171,7 +339,7 @@ func fieldToMetricPath(field string) string {
 }
 
 func (s *IllumosCPU) Gather(acc telegraf.Accumulator) error {
-	token, err := kstat.Open()
+	token, err := newKStatSource()
 
 	if err != nil {
 		log.Print("cannot get kstat token")
@@ -181,26 +349,64 @@ func (s *IllumosCPU) Gather(acc telegraf.Accumulator) error {
 
 	defer token.Close()
 
+	if s.Rates && s.rates == nil {
+		s.rates = helpers.NewRates()
+	}
+
+	defer s.rates.Sweep()
+
+	if s.Percentages && s.usageRates == nil {
+		s.usageRates = helpers.NewRates()
+	}
+
+	defer s.usageRates.Sweep()
+
+	if s.MpstatStats && s.mpstatRates == nil {
+		s.mpstatRates = helpers.NewRates()
+	}
+
+	defer s.mpstatRates.Sweep()
+
+	var chips map[int]string
+
+	if s.Percentages || s.MpstatStats {
+		chips = chipIDs(token)
+	}
+
 	if s.CPUInfoStats {
-		err := gatherCPUinfoStats(acc, token)
+		err := gatherCPUinfoStats(s, acc, token)
 		if err != nil {
 			return err
 		}
 	}
 
 	if s.ZoneCPUStats {
-		err := gatherZoneCPUStats(acc, token)
+		err := gatherZoneCPUStats(s, acc, token)
 		if err != nil {
 			return err
 		}
 	}
 
-	err = gatherSysCPUStats(s, acc, token)
+	if s.ZoneCapStats {
+		err := gatherZoneCapStats(s, acc, token)
+		if err != nil {
+			return err
+		}
+	}
+
+	err = gatherSysCPUStats(s, acc, token, chips)
 
 	if err != nil {
 		return err
 	}
 
+	if s.MpstatStats {
+		err := gatherMpstatStats(s, acc, token, chips)
+		if err != nil {
+			return err
+		}
+	}
+
 	return nil
 }
 
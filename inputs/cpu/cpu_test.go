@@ -2,6 +2,7 @@ package cpu
 
 import (
 	"testing"
+	"time"
 
 	"github.com/influxdata/telegraf"
 	"github.com/influxdata/telegraf/testutil"
@@ -18,7 +19,11 @@ func TestParseCPUinfoKStats(t *testing.T) {
 	require.Equal(
 		t,
 		map[string]interface{}{
-			"speed": float64(2701000000),
+			"speed":       float64(2701000000),
+			"pstate":      float64(0),
+			"cstate":      float64(0),
+			"max_cstate":  float64(3),
+			"state_begin": float64(1620126432),
 		},
 		fields,
 	)
@@ -30,6 +35,63 @@ func TestParseCPUinfoKStats(t *testing.T) {
 			"chipID":   "0",
 			"state":    "on-line",
 			"clockMHz": "2712",
+			"supportedFrequencies": "800000000:900000000:1100000000:1200000000:1300000000:" +
+				"1500000000:1600000000:1700000000:1900000000:2000000000:2200000000:2300000000:" +
+				"2400000000:2600000000:2700000000:2701000000",
+		},
+		tags,
+	)
+}
+
+func TestPstate(t *testing.T) {
+	t.Parallel()
+
+	state, ok := pstate(1200000000, "800000000:1200000000:2000000000")
+	require.True(t, ok)
+	require.Equal(t, 1, state)
+
+	state, ok = pstate(2000000000, "2000000000")
+	require.True(t, ok)
+	require.Equal(t, 0, state)
+
+	_, ok = pstate(1000000000, "800000000:1200000000:2000000000")
+	require.False(t, ok)
+
+	_, ok = pstate(1000000000, "")
+	require.False(t, ok)
+
+	_, ok = pstate(1000000000, "rubbish")
+	require.False(t, ok)
+}
+
+func TestParsePGKStats(t *testing.T) {
+	t.Parallel()
+
+	fields, tags := parsePGKStats(
+		&IllumosCPU{},
+		helpers.FromFixture("pg_hw_perf--8--Integer_Pipeline.kstat"),
+	)
+
+	require.Equal(
+		t,
+		map[string]interface{}{
+			"ncpus":                float64(2),
+			"generation":           float64(1),
+			"hw_util":              float64(1983117330221),
+			"hw_util_rate":         float64(27),
+			"hw_util_rate_max":     float64(2701000000),
+			"hw_util_time_running": float64(691200000000000),
+			"hw_util_time_stopped": float64(54550191086),
+		},
+		fields,
+	)
+
+	require.Equal(
+		t,
+		map[string]string{
+			"pgID":         "8",
+			"relationship": "Integer_Pipeline",
+			"cpus":         "3",
 		},
 		tags,
 	)
@@ -39,13 +101,14 @@ func TestParseZoneCPUKStats(t *testing.T) {
 	t.Parallel()
 
 	testData := helpers.FromFixture("zones--5--cube-ws.kstat")
-	fields, tags := parseZoneCPUKStats(testData)
+	fields, tags := parseZoneCPUKStats(&IllumosCPU{}, testData)
 
 	require.Equal(
 		t,
 		map[string]interface{}{
-			"sys":  float64(1971772437360),
-			"user": float64(227321585182107),
+			"sys":    float64(1971772437360),
+			"user":   float64(227321585182107),
+			"waitrq": float64(125888182634147),
 		},
 		fields,
 	)
@@ -90,6 +153,8 @@ func TestPlugin(t *testing.T) {
 		SysFields:    []string{"cpu_nsec_kernel", "cpu_nsec_user"},
 	}
 
+	newKStatSource = helpers.FixtureKStatSource()
+
 	acc := testutil.Accumulator{}
 	require.NoError(t, s.Gather(&acc))
 
@@ -97,6 +162,8 @@ func TestPlugin(t *testing.T) {
 		switch metric.Name() {
 		case "cpu.info":
 			testCPUinfoMetric(t, metric)
+		case "cpu.pg":
+			testPGMetric(t, metric)
 		case "cpu.zone":
 			testZoneCPUMetric(t, metric)
 		case "cpu":
@@ -112,12 +179,24 @@ func testCPUinfoMetric(t *testing.T, metric telegraf.Metric) {
 	require.True(t, metric.HasTag("chipID"))
 	require.True(t, metric.HasTag("state"))
 	require.True(t, metric.HasTag("clockMHz"))
+	require.True(t, metric.HasTag("supportedFrequencies"))
+	require.True(t, metric.HasField("pstate"))
+	require.True(t, metric.HasField("cstate"))
+	require.True(t, metric.HasField("state_begin"))
+}
+
+func testPGMetric(t *testing.T, metric telegraf.Metric) {
+	t.Helper()
+	require.True(t, metric.HasField("hw_util"))
+	require.True(t, metric.HasField("hw_util_rate"))
+	require.True(t, metric.HasTag("relationship"))
 }
 
 func testZoneCPUMetric(t *testing.T, metric telegraf.Metric) {
 	t.Helper()
 	require.True(t, metric.HasField("sys"))
 	require.True(t, metric.HasField("user"))
+	require.True(t, metric.HasField("waitrq"))
 	require.True(t, metric.HasTag("name"))
 }
 
@@ -127,3 +206,323 @@ func testSysMetric(t *testing.T, metric telegraf.Metric) {
 	require.True(t, metric.HasField("nsec.user"))
 	require.True(t, metric.HasTag("coreID"))
 }
+
+// The sys and zone counters are replayed through newKStatSource to get rates from them.
+func TestPluginRates(t *testing.T) { //nolint:paralleltest
+	s := &IllumosCPU{
+		ZoneCPUStats: true,
+		SysFields:    []string{"cpu_nsec_user", "cpu_load_intr"},
+		Rates:        true,
+	}
+
+	helpers.UseFixtureReplay(t, &newKStatSource)
+
+	acc := testutil.Accumulator{}
+	require.NoError(t, s.Gather(&acc))
+
+	// Only gauges on the first tick
+	testutil.RequireMetricsEqual(
+		t,
+		[]telegraf.Metric{
+			testutil.MustMetric(
+				"cpu",
+				map[string]string{"coreID": "3"},
+				map[string]interface{}{"load.intr": float64(1)},
+				time.Unix(0, 0),
+			),
+			testutil.MustMetric(
+				"cpu",
+				map[string]string{"coreID": "4"},
+				map[string]interface{}{"load.intr": float64(1)},
+				time.Unix(0, 0),
+			),
+		},
+		acc.GetTelegrafMetrics(),
+		testutil.IgnoreTime(),
+	)
+
+	acc.ClearMetrics()
+	require.NoError(t, s.Gather(&acc))
+
+	testutil.RequireMetricsEqual(
+		t,
+		[]telegraf.Metric{
+			testutil.MustMetric(
+				"cpu.zone",
+				map[string]string{"name": "cube-ws"},
+				map[string]interface{}{
+					"sys":    float64(197177243736),
+					"user":   float64(22732158518210.7),
+					"waitrq": float64(12588818263414.7),
+				},
+				time.Unix(0, 0),
+			),
+			testutil.MustMetric(
+				"cpu",
+				map[string]string{"coreID": "3"},
+				map[string]interface{}{
+					"load.intr": float64(2),
+					"nsec.user": float64(10592650848478.6),
+				},
+				time.Unix(0, 0),
+			),
+			testutil.MustMetric(
+				"cpu",
+				map[string]string{"coreID": "4"},
+				map[string]interface{}{
+					"load.intr": float64(2),
+					"nsec.user": float64(2000000000000),
+				},
+				time.Unix(0, 0),
+			),
+		},
+		acc.GetTelegrafMetrics(),
+		testutil.IgnoreTime(),
+	)
+}
+
+// Percentages come from the change between two ticks, replayed through newKStatSource.
+func TestPluginPercentages(t *testing.T) { //nolint:paralleltest
+	s := &IllumosCPU{
+		SysFields:   []string{"nothing"},
+		Percentages: true,
+	}
+
+	helpers.UseFixtureReplay(t, &newKStatSource)
+
+	acc := testutil.Accumulator{}
+	require.NoError(t, s.Gather(&acc))
+	require.Empty(t, acc.GetTelegrafMetrics())
+
+	require.NoError(t, s.Gather(&acc))
+
+	expected := []struct {
+		measurement string
+		tags        map[string]string
+		fields      map[string]float64
+	}{
+		{
+			"cpu.percent",
+			map[string]string{"coreID": "3", "chipID": "0"},
+			map[string]float64{"user": 14.4034, "kernel": 4.2429, "idle": 80.918, "intr": 0.4357},
+		},
+		{
+			"cpu.percent",
+			map[string]string{"coreID": "4", "chipID": "1"},
+			map[string]float64{"user": 2.7211, "kernel": 1.3605, "idle": 95.2381, "intr": 0.6803},
+		},
+		{
+			"cpu.percent.chip",
+			map[string]string{"chipID": "1"},
+			map[string]float64{"user": 2.7211, "kernel": 1.3605, "idle": 95.2381, "intr": 0.6803},
+		},
+		{
+			"cpu.percent.total",
+			map[string]string{},
+			map[string]float64{"user": 8.5639, "kernel": 2.8022, "idle": 88.076, "intr": 0.5579},
+		},
+	}
+
+	for _, e := range expected {
+		found := false
+
+		for _, metric := range acc.GetTelegrafMetrics() {
+			if metric.Name() != e.measurement || !tagsMatch(metric, e.tags) {
+				continue
+			}
+
+			found = true
+
+			for field, value := range e.fields {
+				actual, ok := metric.GetField(field)
+				require.True(t, ok)
+				require.InDelta(t, value, actual, 0.0001)
+			}
+
+			require.True(t, metric.HasField("dtrace"))
+		}
+
+		require.True(t, found, "no %s metric tagged %v", e.measurement, e.tags)
+	}
+}
+
+func tagsMatch(metric telegraf.Metric, tags map[string]string) bool {
+	if len(metric.Tags()) != len(tags) {
+		return false
+	}
+
+	for k, v := range tags {
+		if actual, ok := metric.GetTag(k); !ok || actual != v {
+			return false
+		}
+	}
+
+	return true
+}
+
+func TestParseMpstat(t *testing.T) {
+	t.Parallel()
+
+	// srw needs rw_wrfails too, so is left out
+	require.Equal(
+		t,
+		map[string]interface{}{
+			"minf": float64(15),
+			"mjf":  float64(4),
+			"csw":  float64(100),
+		},
+		parseMpstat(map[string]float64{
+			"hat_fault":  5,
+			"as_fault":   10,
+			"maj_fault":  4,
+			"rw_rdfails": 1,
+			"pswitch":    100,
+			"rw_other":   9,
+		}),
+	)
+}
+
+func TestParseIntrstat(t *testing.T) {
+	t.Parallel()
+
+	require.Equal(
+		t,
+		map[string]map[string]interface{}{
+			"6": {"count": float64(10), "time": float64(5e8), "percent": float64(50)},
+		},
+		parseIntrstat(map[string]float64{
+			"level-1-count": 0,
+			"level-1-time":  0,
+			"level-6-count": 10,
+			"level-6-time":  5e8,
+			"level-9-count": 10,
+		}),
+	)
+}
+
+// mpstat columns are rates, so this needs a replay in newKStatSource. IDs are kept as they are.
+func TestPluginMpstat(t *testing.T) { //nolint:paralleltest
+	s := &IllumosCPU{
+		SysFields:   []string{"nothing"},
+		MpstatStats: true,
+	}
+
+	helpers.UseFixtureReplay(t, &newKStatSource, "cpu", "pil", "ino", "cookie")
+
+	acc := testutil.Accumulator{}
+	require.NoError(t, s.Gather(&acc))
+	require.Empty(t, acc.GetTelegrafMetrics())
+
+	require.NoError(t, s.Gather(&acc))
+
+	tags := map[string]string{"coreID": "3", "chipID": "0"}
+
+	require.True(t, acc.HasPoint("cpu.mpstat", tags, "minf", float64(62607500.6)))
+	require.True(t, acc.HasPoint("cpu.mpstat", tags, "mjf", float64(424.5)))
+	require.True(t, acc.HasPoint("cpu.mpstat", tags, "xcal", float64(7454129.4)))
+	require.True(t, acc.HasPoint("cpu.mpstat", tags, "srw", float64(765826.3)))
+	require.True(t, acc.HasPoint("cpu.mpstat", tags, "syscl", float64(449562990.1)))
+
+	for _, metric := range acc.GetTelegrafMetrics() {
+		if metric.Name() == "cpu.mpstat" {
+			require.Len(t, metric.FieldList(), len(mpstatColumns))
+		}
+	}
+
+	tags = map[string]string{"coreID": "3", "chipID": "0", "level": "6"}
+
+	require.True(t, acc.HasPoint("cpu.intrstat", tags, "count", float64(141823.3)))
+	require.True(t, acc.HasPoint("cpu.intrstat", tags, "time", float64(318571602.3)))
+
+	tags = map[string]string{"coreID": "3", "chipID": "0", "device": "e1000g#0", "type": "msi"}
+
+	require.True(t, acc.HasPoint("cpu.intrstat.device", tags, "time", float64(318571602.3)))
+	require.True(t, acc.HasPoint("cpu.intrstat.device", tags, "percent", float64(31.85716023)))
+}
+
+func TestParseZoneShares(t *testing.T) {
+	t.Parallel()
+
+	require.Equal(
+		t,
+		map[string]float64{"global": 1, "cube-ws": 20},
+		parseZoneShares(samplePrctlOutput),
+	)
+}
+
+func TestParseZoneCapKStats(t *testing.T) {
+	t.Parallel()
+
+	fields, zone := parseZoneCapKStats(
+		&IllumosCPU{},
+		helpers.FromFixture("caps--5--cpucaps_zone_5.kstat"),
+	)
+
+	require.Equal(t, "cube-ws", zone)
+	require.Equal(
+		t,
+		map[string]interface{}{
+			"value":     float64(200),
+			"usage":     float64(187),
+			"nwait":     float64(3),
+			"below_sec": float64(605212),
+			"above_sec": float64(1180),
+			"maxusage":  float64(200),
+		},
+		fields,
+	)
+}
+
+// Not parallel, because it replaces runPrctl.
+func TestPluginZoneCaps(t *testing.T) { //nolint:paralleltest
+	s := &IllumosCPU{
+		SysFields:    []string{"nothing"},
+		ZoneCapStats: true,
+	}
+
+	newKStatSource = helpers.FixtureKStatSource()
+	runPrctl = func(zones []string) (string, error) {
+		require.Equal(t, []string{"cube-ws"}, zones)
+
+		return samplePrctlOutput, nil
+	}
+
+	acc := testutil.Accumulator{}
+	require.NoError(t, s.Gather(&acc))
+
+	testutil.RequireMetricsEqual(
+		t,
+		[]telegraf.Metric{
+			testutil.MustMetric(
+				"cpu.zone.cap",
+				map[string]string{"name": "cube-ws"},
+				map[string]interface{}{
+					"value":     float64(200),
+					"usage":     float64(187),
+					"nwait":     float64(3),
+					"below_sec": float64(605212),
+					"above_sec": float64(1180),
+					"maxusage":  float64(200),
+					"shares":    float64(20),
+				},
+				time.Unix(0, 0),
+			),
+			testutil.MustMetric(
+				"cpu.zone.cap",
+				map[string]string{"name": "global"},
+				map[string]interface{}{"shares": float64(1)},
+				time.Unix(0, 0),
+			),
+		},
+		acc.GetTelegrafMetrics(),
+		testutil.IgnoreTime(),
+		testutil.SortMetrics(),
+	)
+}
+
+var samplePrctlOutput = `zone: 0: global
+zone.cpu-shares privileged 1 - none -
+zone.cpu-shares system 65535 max none -
+zone: 5: cube-ws
+zone.cpu-shares privileged 20 - none -
+zone.cpu-shares system 65535 max none -`
@@ -0,0 +1,233 @@
+package cpu
+
+import (
+	"fmt"
+	"log"
+
+	"github.com/influxdata/telegraf"
+	"github.com/snltd/illumos-telegraf-plugins/helpers"
+)
+
+// mpstatColumns maps the columns of mpstat(8) to the cpu:N:sys and cpu:N:vm stats they are made
+// from. Like mpstat, we emit them all as per-second rates.
+var mpstatColumns = map[string][]string{
+	"minf":  {"hat_fault", "as_fault"},
+	"mjf":   {"maj_fault"},
+	"xcal":  {"xcalls"},
+	"intr":  {"intr"},
+	"ithr":  {"intrthread"},
+	"csw":   {"pswitch"},
+	"icsw":  {"inv_swtch"},
+	"migr":  {"cpumigrate"},
+	"smtx":  {"mutex_adenters"},
+	"srw":   {"rw_rdfails", "rw_wrfails"},
+	"syscl": {"syscall"},
+}
+
+// pilMax is the highest interrupt priority level, which is how many levels cpu:N:intrstat has.
+const pilMax = 15
+
+// namedRates returns the rate of every numeric stat it is given, keyed by name. Stats for which
+// there is not yet a rate are left out.
+func namedRates(rates *helpers.Rates, stats []*helpers.Named) map[string]float64 {
+	ret := make(map[string]float64)
+
+	for _, stat := range stats {
+		if rate, ok := rates.NamedRate(stat); ok {
+			ret[stat.Name] = rate
+		}
+	}
+
+	return ret
+}
+
+// parseMpstat turns rates of cpu:N:sys and cpu:N:vm stats into mpstat columns. A column is only
+// present if we have a rate for every stat which goes into it.
+func parseMpstat(rates map[string]float64) map[string]interface{} {
+	fields := make(map[string]interface{})
+
+COLUMN:
+	for column, stats := range mpstatColumns {
+		var value float64
+
+		for _, stat := range stats {
+			rate, ok := rates[stat]
+			if !ok {
+				continue COLUMN
+			}
+
+			value += rate
+		}
+
+		fields[column] = value
+	}
+
+	return fields
+}
+
+// parseIntrstat breaks cpu:N:intrstat rates down by interrupt priority level. Levels which have
+// seen no interrupts are skipped.
+func parseIntrstat(rates map[string]float64) map[string]map[string]interface{} {
+	ret := make(map[string]map[string]interface{})
+
+	for level := 1; level <= pilMax; level++ {
+		count, haveCount := rates[fmt.Sprintf("level-%d-count", level)]
+		time, haveTime := rates[fmt.Sprintf("level-%d-time", level)]
+
+		if !haveCount || !haveTime || (count == 0 && time == 0) {
+			continue
+		}
+
+		ret[fmt.Sprintf("%d", level)] = map[string]interface{}{
+			"count":   count,
+			"time":    time,
+			"percent": time / 1e7,
+		}
+	}
+
+	return ret
+}
+
+func gatherMpstatStats(
+	s *IllumosCPU,
+	acc telegraf.Accumulator,
+	token helpers.KStatSource,
+	chips map[int]string,
+) error {
+	for _, ks := range helpers.KStatsInModule(token, "cpu") {
+		switch ks.Name {
+		case "sys":
+			if err := gatherMpstatCPU(s, acc, token, ks, chips); err != nil {
+				return err
+			}
+		case "intrstat":
+			if err := gatherIntrstat(s, acc, ks, chips); err != nil {
+				return err
+			}
+		}
+	}
+
+	gatherDeviceInterrupts(s, acc, token, chips)
+
+	return nil
+}
+
+func gatherMpstatCPU(
+	s *IllumosCPU,
+	acc telegraf.Accumulator,
+	token helpers.KStatSource,
+	ks *helpers.KStat,
+	chips map[int]string,
+) error {
+	stats, err := ks.AllNamed()
+	if err != nil {
+		log.Print("cannot get CPU named stats")
+
+		return err
+	}
+
+	vm, err := token.Lookup("cpu", ks.Instance, "vm")
+	if err == nil {
+		vmStats, err := vm.AllNamed()
+		if err != nil {
+			log.Print("cannot get CPU vm named stats")
+
+			return err
+		}
+
+		stats = append(stats, vmStats...)
+	}
+
+	fields := parseMpstat(namedRates(s.mpstatRates, stats))
+
+	if len(fields) > 0 {
+		acc.AddFields("cpu.mpstat", fields, cpuTags(ks.Instance, chips))
+	}
+
+	return nil
+}
+
+func gatherIntrstat(
+	s *IllumosCPU,
+	acc telegraf.Accumulator,
+	ks *helpers.KStat,
+	chips map[int]string,
+) error {
+	stats, err := ks.AllNamed()
+	if err != nil {
+		log.Print("cannot get CPU intrstat named stats")
+
+		return err
+	}
+
+	for level, fields := range parseIntrstat(namedRates(s.mpstatRates, stats)) {
+		tags := cpuTags(ks.Instance, chips)
+		tags["level"] = level
+		acc.AddFields("cpu.intrstat", fields, tags)
+	}
+
+	return nil
+}
+
+// gatherDeviceInterrupts reports the time each CPU spends handling each device's interrupts, from
+// the pci_intrs kstats. This is what intrstat(8) shows, without needing DTrace.
+func gatherDeviceInterrupts(
+	s *IllumosCPU,
+	acc telegraf.Accumulator,
+	token helpers.KStatSource,
+	chips map[int]string,
+) {
+	for _, ks := range helpers.KStatsInModule(token, "pci_intrs") {
+		stats, err := ks.AllNamed()
+		if err != nil {
+			log.Printf("cannot get named stats for %s\n", ks)
+
+			continue
+		}
+
+		var (
+			device, intrType string
+			cpu              int
+			time             *helpers.Named
+		)
+
+		for _, stat := range stats {
+			switch stat.Name {
+			case "name":
+				device = stat.StringVal
+			case "type":
+				intrType = stat.StringVal
+			case "cpu":
+				cpu = int(stat.UintVal)
+			case "time":
+				time = stat
+			}
+		}
+
+		if time == nil {
+			continue
+		}
+
+		rate, ok := s.mpstatRates.NamedRate(time)
+		if !ok {
+			continue
+		}
+
+		tags := cpuTags(cpu, chips)
+		tags["device"] = device
+		tags["type"] = intrType
+
+		acc.AddFields(
+			"cpu.intrstat.device",
+			map[string]interface{}{"time": rate, "percent": rate / 1e7},
+			tags,
+		)
+	}
+}
+
+func cpuTags(cpu int, chips map[int]string) map[string]string {
+	return map[string]string{
+		"coreID": fmt.Sprintf("%d", cpu),
+		"chipID": chipID(chips, cpu),
+	}
+}
@@ -0,0 +1,142 @@
+package cpu
+
+import (
+	"fmt"
+
+	"github.com/influxdata/telegraf"
+	"github.com/snltd/illumos-telegraf-plugins/helpers"
+)
+
+// cpuUsage is the time, in nanoseconds per second, which a CPU, or a group of CPUs, spent in each
+// state. The kernel accounts interrupt time separately from user, kernel and idle time, so those
+// four add up to the whole. DTrace time is a part of kernel time.
+type cpuUsage struct {
+	user   float64
+	kernel float64
+	idle   float64
+	intr   float64
+	dtrace float64
+}
+
+func (u cpuUsage) add(other cpuUsage) cpuUsage {
+	return cpuUsage{
+		user:   u.user + other.user,
+		kernel: u.kernel + other.kernel,
+		idle:   u.idle + other.idle,
+		intr:   u.intr + other.intr,
+		dtrace: u.dtrace + other.dtrace,
+	}
+}
+
+// fields turns usage into percentages, like the usr, sys and idl columns of mpstat. Except that
+// mpstat counts interrupt time as sys, and we keep it separate.
+func (u cpuUsage) fields() map[string]interface{} {
+	total := u.user + u.kernel + u.idle + u.intr
+
+	if total == 0 {
+		return map[string]interface{}{}
+	}
+
+	return map[string]interface{}{
+		"user":   u.user / total * 100,
+		"kernel": u.kernel / total * 100,
+		"idle":   u.idle / total * 100,
+		"intr":   u.intr / total * 100,
+		"dtrace": u.dtrace / total * 100,
+	}
+}
+
+// usageTotals sums usage across chips and across the whole system.
+type usageTotals struct {
+	chips map[string]cpuUsage
+	all   cpuUsage
+	cpus  int
+}
+
+func newUsageTotals() *usageTotals {
+	return &usageTotals{chips: make(map[string]cpuUsage)}
+}
+
+func (t *usageTotals) add(chipID string, usage cpuUsage) {
+	t.chips[chipID] = t.chips[chipID].add(usage)
+	t.all = t.all.add(usage)
+	t.cpus++
+}
+
+// usage works out a CPU's usage from its cpu:sys stats. Usage comes from the difference between
+// two samples, so the second value is false the first time we see a CPU.
+func (s *IllumosCPU) usage(stats []*helpers.Named) (cpuUsage, bool) {
+	var usage cpuUsage
+
+	found := 0
+	ok := true
+
+	for _, stat := range stats {
+		var field *float64
+
+		switch stat.Name {
+		case "cpu_nsec_user":
+			field = &usage.user
+		case "cpu_nsec_kernel":
+			field = &usage.kernel
+		case "cpu_nsec_idle":
+			field = &usage.idle
+		case "cpu_nsec_intr":
+			field = &usage.intr
+		case "cpu_nsec_dtrace":
+			field = &usage.dtrace
+		default:
+			continue
+		}
+
+		found++
+
+		// Keep going on a miss, so every field has a baseline for next time.
+		rate, haveRate := s.usageRates.NamedRate(stat)
+		if !haveRate {
+			ok = false
+
+			continue
+		}
+
+		*field = rate
+	}
+
+	return usage, ok && found == 5
+}
+
+// chipIDs maps CPU IDs to the ID of the chip they are on.
+func chipIDs(token helpers.KStatSource) map[int]string {
+	ret := make(map[int]string)
+
+	for _, ks := range helpers.KStatsInModule(token, "cpu_info") {
+		chipID, err := ks.GetNamed("chip_id")
+		if err != nil {
+			continue
+		}
+
+		ret[ks.Instance] = fmt.Sprintf("%d", chipID.IntVal)
+	}
+
+	return ret
+}
+
+func chipID(chips map[int]string, cpu int) string {
+	if id, ok := chips[cpu]; ok {
+		return id
+	}
+
+	return "unknown"
+}
+
+func gatherUsageTotals(acc telegraf.Accumulator, totals *usageTotals) {
+	if totals.cpus == 0 {
+		return
+	}
+
+	for chipID, usage := range totals.chips {
+		acc.AddFields("cpu.percent.chip", usage.fields(), map[string]string{"chipID": chipID})
+	}
+
+	acc.AddFields("cpu.percent.total", totals.all.fields(), map[string]string{})
+}
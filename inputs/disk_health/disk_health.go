@@ -5,7 +5,6 @@ import (
 	"log"
 	"strings"
 
-	"github.com/illumos/go-kstat"
 	"github.com/influxdata/telegraf"
 	"github.com/influxdata/telegraf/plugins/inputs"
 	"github.com/snltd/illumos-telegraf-plugins/helpers"
@@ -36,9 +35,11 @@ type IllumosDiskHealth struct {
 	Tags    []string
 }
 
+var newKStatSource = helpers.NewKStatSource
+
 // The info for the tags and the values is in the same kstat. There's no point going through it
 // twice, so we'll return a tuple.
-func parseNamedStats(s *IllumosDiskHealth, stats []*kstat.Named) (map[string]interface{}, map[string]string) {
+func parseNamedStats(s *IllumosDiskHealth, stats []*helpers.Named) (map[string]interface{}, map[string]string) {
 	fields := make(map[string]interface{})
 	tags := make(map[string]string)
 
@@ -71,7 +72,7 @@ func parseNamedStats(s *IllumosDiskHealth, stats []*kstat.Named) (map[string]int
 }
 
 func (s *IllumosDiskHealth) Gather(acc telegraf.Accumulator) error {
-	token, err := kstat.Open()
+	token, err := newKStatSource()
 	if err != nil {
 		log.Print("cannot get kstat token")
 
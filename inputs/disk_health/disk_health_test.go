@@ -99,11 +99,13 @@ func TestPlugin(t *testing.T) {
 	t.Parallel()
 
 	s := &IllumosDiskHealth{
-		Devices: []string{"sd0"},
+		Devices: []string{"sd6"},
 		Fields:  []string{"Hard Errors", "Transport Errors", "Illegal Request"},
 		Tags:    []string{"Vendor", "Serial No", "Product"},
 	}
 
+	newKStatSource = helpers.FixtureKStatSource()
+
 	acc := testutil.Accumulator{}
 	require.NoError(t, s.Gather(&acc))
 
@@ -5,9 +5,7 @@ import (
 	"log"
 	"regexp"
 	"strconv"
-	"strings"
 
-	"github.com/illumos/go-kstat"
 	"github.com/influxdata/telegraf"
 	"github.com/influxdata/telegraf/plugins/inputs"
 	"github.com/snltd/illumos-telegraf-plugins/helpers"
@@ -22,6 +20,9 @@ var sampleConfig = `
 	# modules = ["sd", "zfs"]
 	## Report on the following devices, inside the above modules. Specifying none reports on all.
 	# devices = ["sd0"]
+	## Emit per-second rates rather than raw counters. Nothing is reported for a counter until it
+	## has been seen twice.
+	# rates = false
 `
 
 func (s *IllumosIO) Description() string {
@@ -36,63 +37,60 @@ type IllumosIO struct {
 	Devices []string
 	Fields  []string
 	Modules []string
+	Rates   bool
+	rates   *helpers.Rates
 }
 
-func extractFields(s *IllumosIO, stat *kstat.IO) map[string]interface{} { //nolint:cyclop
-	fields := make(map[string]interface{})
-
-	if helpers.WeWant("nread", s.Fields) {
-		fields["nread"] = float64(stat.Nread)
-	}
-
-	if helpers.WeWant("nwritten", s.Fields) {
-		fields["nwritten"] = float64(stat.Nwritten)
-	}
-
-	if helpers.WeWant("reads", s.Fields) {
-		fields["reads"] = float64(stat.Writes)
-	}
-
-	if helpers.WeWant("writes", s.Fields) {
-		fields["writes"] = float64(stat.Writes)
-	}
+var newKStatSource = helpers.NewKStatSource
 
-	if helpers.WeWant("wtime", s.Fields) {
-		fields["wtime"] = float64(stat.Wtime)
-	}
-
-	if helpers.WeWant("wlentime", s.Fields) {
-		fields["wlentime"] = float64(stat.Wlentime)
-	}
+// ioField is a single value from an IO kstat. Counters can be turned into rates: gauges and
+// timestamps cannot.
+type ioField struct {
+	value   uint64
+	width   int
+	counter bool
+}
 
-	if helpers.WeWant("wlastupdate", s.Fields) {
-		fields["wlastupdate"] = float64(stat.Wlastupdate)
+func ioFields(stat *helpers.IO) map[string]ioField {
+	return map[string]ioField{
+		"nread":       {stat.Nread, 64, true},
+		"nwritten":    {stat.Nwritten, 64, true},
+		"reads":       {uint64(stat.Reads), 32, true},
+		"writes":      {uint64(stat.Writes), 32, true},
+		"wtime":       {uint64(stat.Wtime), 64, true},
+		"wlentime":    {uint64(stat.Wlentime), 64, true},
+		"wlastupdate": {uint64(stat.Wlastupdate), 64, false},
+		"rtime":       {uint64(stat.Rtime), 64, true},
+		"rlentime":    {uint64(stat.Rlentime), 64, true},
+		"rlastupdate": {uint64(stat.Rlastupdate), 64, false},
+		"wcnt":        {uint64(stat.Wcnt), 32, false},
+		"rcnt":        {uint64(stat.Rcnt), 32, false},
 	}
+}
 
-	if helpers.WeWant("rtime", s.Fields) {
-		fields["rtime"] = float64(stat.Rtime)
-	}
+func extractFields(s *IllumosIO, ks *helpers.KStat, stat *helpers.IO) map[string]interface{} {
+	fields := make(map[string]interface{})
 
-	if helpers.WeWant("rlentime", s.Fields) {
-		fields["rlentime"] = float64(stat.Rlentime)
-	}
+	for name, field := range ioFields(stat) {
+		if !helpers.WeWant(name, s.Fields) {
+			continue
+		}
 
-	if helpers.WeWant("rlastupdate", s.Fields) {
-		fields["rlastupdate"] = float64(stat.Wlastupdate)
-	}
+		if !field.counter || s.rates == nil {
+			fields[name] = float64(field.value)
 
-	if helpers.WeWant("wcnt", s.Fields) {
-		fields["wcnt"] = float64(stat.Wcnt)
-	}
+			continue
+		}
 
-	if helpers.WeWant("rcnt", s.Fields) {
-		fields["rcnt"] = float64(stat.Rcnt)
+		if rate, ok := s.rates.Rate(ks, name, field.value, field.width, ks.Snaptime); ok {
+			fields[name] = rate
+		}
 	}
 
 	return fields
 }
 
-func createTags(token *kstat.Token, mod, device string) map[string]string {
+func createTags(token helpers.KStatSource, mod, device string) map[string]string {
 	tags := map[string]string{
 		"module": mod,
 		"device": device,
@@ -122,7 +120,7 @@ func createTags(token *kstat.Token, mod, device string) map[string]string {
 }
 
 func (s *IllumosIO) Gather(acc telegraf.Accumulator) error {
-	token, err := kstat.Open()
+	token, err := newKStatSource()
 	if err != nil {
 		log.Print("cannot get kstat token")
 
@@ -131,22 +129,35 @@ func (s *IllumosIO) Gather(acc telegraf.Accumulator) error {
 
 	defer token.Close()
 
-	ioStats := helpers.KStatIoClass(token, "disk")
+	if s.Rates && s.rates == nil {
+		s.rates = helpers.NewRates()
+	}
+
+	defer s.rates.Sweep()
+
+	for _, ks := range helpers.KStatsInClass(token, "disk") {
+		if ks.Type != helpers.IoStat {
+			continue
+		}
+
+		if !helpers.WeWant(ks.Module, s.Modules) || !helpers.WeWant(ks.Name, s.Devices) {
+			continue
+		}
+
+		stat, err := ks.GetIO()
+		if err != nil {
+			log.Printf("cannot get IO kstats for %s\n", ks)
+
+			continue
+		}
 
-	for modName, stat := range ioStats {
-		chunks := strings.Split(modName, ":")
-		mod := chunks[0]
-		name := chunks[1]
+		fields := extractFields(s, ks, stat)
 
-		if !helpers.WeWant(mod, s.Modules) || !helpers.WeWant(name, s.Devices) {
+		if len(fields) == 0 {
 			continue
 		}
 
-		acc.AddFields(
-			"io",
-			extractFields(s, stat),
-			createTags(token, mod, name),
-		)
+		acc.AddFields("io", fields, createTags(token, ks.Module, ks.Name))
 	}
 
 	return nil
@@ -4,6 +4,7 @@ import (
 	"testing"
 
 	"github.com/influxdata/telegraf/testutil"
+	"github.com/snltd/illumos-telegraf-plugins/helpers"
 	"github.com/stretchr/testify/require"
 )
 
@@ -17,6 +18,8 @@ func TestPlugin(t *testing.T) {
 		Fields:  []string{"reads", "nread", "writes", "nwritten"},
 	}
 
+	newKStatSource = helpers.FixtureKStatSource()
+
 	acc := testutil.Accumulator{}
 	require.NoError(t, s.Gather(&acc))
 
@@ -30,3 +33,43 @@ func TestPlugin(t *testing.T) {
 	require.True(t, metric.HasTag("serialNo"))
 	require.True(t, metric.HasTag("product"))
 }
+
+// Not parallel: the IO kstats are replayed through newKStatSource to turn them into rates.
+func TestPluginRates(t *testing.T) { //nolint:paralleltest
+	s := &IllumosIO{
+		Devices: []string{"sd0"},
+		Fields:  []string{"nread", "reads", "rcnt"},
+		Rates:   true,
+	}
+
+	token, err := helpers.NewFixtureKStatSource("testdata")
+	require.NoError(t, err)
+
+	ks, err := token.Lookup("sd", 0, "sd0")
+	require.NoError(t, err)
+
+	raw, err := ks.GetIO()
+	require.NoError(t, err)
+
+	helpers.UseFixtureReplay(t, &newKStatSource)
+
+	acc := testutil.Accumulator{}
+	require.NoError(t, s.Gather(&acc))
+	require.Equal(
+		t,
+		map[string]interface{}{"rcnt": float64(raw.Rcnt)},
+		acc.GetTelegrafMetrics()[0].Fields(),
+	)
+
+	acc.ClearMetrics()
+	require.NoError(t, s.Gather(&acc))
+	require.Equal(
+		t,
+		map[string]interface{}{
+			"nread": float64(raw.Nread) / 10,
+			"reads": float64(raw.Reads) / 10,
+			"rcnt":  float64(raw.Rcnt),
+		},
+		acc.GetTelegrafMetrics()[0].Fields(),
+	)
+}
@@ -0,0 +1,179 @@
+package kmem
+
+import (
+	"log"
+	"sort"
+
+	"github.com/influxdata/telegraf"
+	"github.com/influxdata/telegraf/plugins/inputs"
+	"github.com/snltd/illumos-telegraf-plugins/helpers"
+)
+
+var sampleConfig = `
+	## How many caches to report on, picking those which use the most memory. Zero reports every
+	## cache, of which there are several hundred.
+	# top_k = 20
+	## Caches to look at, or to ignore. Patterns are globs, or regular expressions between
+	## slashes, like "/^zio_(data_)?buf_/". An empty include list includes everything.
+	# include_names = []
+	# exclude_names = []
+	## Emit alloc and alloc_fail as per-second rates rather than raw counters. Nothing is
+	## reported for a counter until it has been seen twice.
+	# rates = false
+`
+
+var newKStatSource = helpers.NewKStatSource
+
+func (s *IllumosKmem) Description() string {
+	return "Reports on illumos kernel memory allocator caches."
+}
+
+func (s *IllumosKmem) SampleConfig() string {
+	return sampleConfig
+}
+
+type IllumosKmem struct {
+	TopK         int
+	IncludeNames []string
+	ExcludeNames []string
+	Rates        bool
+	include      *helpers.Matcher
+	exclude      *helpers.Matcher
+	rates        *helpers.Rates
+}
+
+// kmemCounters are the kmem_cache stats which only ever go up.
+var kmemCounters = []string{"alloc", "alloc_fail"}
+
+// kmemGauges are the kmem_cache stats we send as they are.
+var kmemGauges = []string{"buf_size", "buf_inuse", "buf_total"}
+
+// kmemCache is what we want from one kmem_cache kstat. memory is what ::kmastat calls "memory
+// in use": every slab the cache holds, whether or not its buffers are allocated.
+type kmemCache struct {
+	name   string
+	memory float64
+	fields map[string]interface{}
+}
+
+func (s *IllumosKmem) Init() error {
+	var err error
+
+	if s.include, err = helpers.NewMatcher(s.IncludeNames); err != nil {
+		return err
+	}
+
+	s.exclude, err = helpers.NewMatcher(s.ExcludeNames)
+
+	return err
+}
+
+// wantCache is true if the named cache gets through the include and exclude lists.
+func (s *IllumosKmem) wantCache(name string) bool {
+	if s.include != nil && !s.include.Match(name) {
+		return false
+	}
+
+	return !s.exclude.Match(name)
+}
+
+func parseCache(s *IllumosKmem, stats []*helpers.Named) (kmemCache, bool) {
+	values := make(map[string]*helpers.Named, len(stats))
+
+	for _, stat := range stats {
+		values[stat.Name] = stat
+	}
+
+	slabSize, sok := values["slab_size"]
+	slabCreate, cok := values["slab_create"]
+	slabDestroy, dok := values["slab_destroy"]
+
+	if !sok || !cok || !dok {
+		return kmemCache{}, false
+	}
+
+	slabs := helpers.NamedValue(slabCreate).(float64) - helpers.NamedValue(slabDestroy).(float64)
+	memory := slabs * helpers.NamedValue(slabSize).(float64)
+	fields := map[string]interface{}{"memory": memory}
+
+	for _, name := range kmemGauges {
+		if stat, ok := values[name]; ok {
+			fields[name] = helpers.NamedValue(stat).(float64)
+		}
+	}
+
+	for _, name := range kmemCounters {
+		if stat, ok := values[name]; ok {
+			if value, ok := s.rates.CounterValue(stat); ok {
+				fields[name] = value
+			}
+		}
+	}
+
+	return kmemCache{memory: memory, fields: fields}, true
+}
+
+// topKCaches sorts caches by the memory they use, biggest first, and returns the first topK.
+// Caches using the same amount come out in name order, so the list doesn't shuffle about.
+func topKCaches(caches []kmemCache, topK int) []kmemCache {
+	sort.Slice(caches, func(i, j int) bool {
+		if caches[i].memory != caches[j].memory {
+			return caches[i].memory > caches[j].memory
+		}
+
+		return caches[i].name < caches[j].name
+	})
+
+	if topK > 0 && len(caches) > topK {
+		return caches[:topK]
+	}
+
+	return caches
+}
+
+func (s *IllumosKmem) Gather(acc telegraf.Accumulator) error {
+	token, err := newKStatSource()
+	if err != nil {
+		log.Print("cannot get kstat token")
+
+		return err
+	}
+
+	defer token.Close()
+
+	if s.Rates && s.rates == nil {
+		s.rates = helpers.NewRates()
+	}
+
+	defer s.rates.Sweep()
+
+	var caches []kmemCache
+
+	for _, statGroup := range helpers.KStatsInModule(token, "unix") {
+		if statGroup.Class != "kmem_cache" || !s.wantCache(statGroup.Name) {
+			continue
+		}
+
+		namedStats, err := statGroup.AllNamed()
+		if err != nil {
+			log.Printf("cannot get named kmem_cache stats for %s\n", statGroup.Name)
+
+			continue
+		}
+
+		if cache, ok := parseCache(s, namedStats); ok {
+			cache.name = statGroup.Name
+			caches = append(caches, cache)
+		}
+	}
+
+	for _, cache := range topKCaches(caches, s.TopK) {
+		acc.AddFields("kmem.cache", cache.fields, map[string]string{"cache": cache.name})
+	}
+
+	return nil
+}
+
+func init() {
+	inputs.Add("illumos_kmem", func() telegraf.Input { return &IllumosKmem{TopK: 20} })
+}
@@ -0,0 +1,168 @@
+package kmem
+
+import (
+	"testing"
+	"time"
+
+	"github.com/influxdata/telegraf"
+	"github.com/influxdata/telegraf/testutil"
+	"github.com/snltd/illumos-telegraf-plugins/helpers"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseCache(t *testing.T) {
+	t.Parallel()
+
+	cache, ok := parseCache(&IllumosKmem{}, helpers.FromFixture("unix--0--dnode_t.kstat"))
+	require.True(t, ok)
+
+	require.Equal(t, float64(102400000), cache.memory)
+	require.Equal(
+		t,
+		map[string]interface{}{
+			"memory":     float64(102400000),
+			"buf_size":   float64(752),
+			"buf_inuse":  float64(119876),
+			"buf_total":  float64(125000),
+			"alloc":      float64(7654321),
+			"alloc_fail": float64(3),
+		},
+		cache.fields,
+	)
+
+	_, ok = parseCache(&IllumosKmem{}, helpers.FromFixture("unix--0--kmem_default.kstat"))
+	require.False(t, ok)
+}
+
+func TestTopKCaches(t *testing.T) {
+	t.Parallel()
+
+	caches := []kmemCache{
+		{name: "c", memory: 10},
+		{name: "b", memory: 30},
+		{name: "a", memory: 10},
+		{name: "d", memory: 20},
+	}
+
+	names := func(caches []kmemCache) []string {
+		ret := make([]string, len(caches))
+
+		for i, cache := range caches {
+			ret[i] = cache.name
+		}
+
+		return ret
+	}
+
+	require.Equal(t, []string{"b", "d", "a"}, names(topKCaches(caches, 3)))
+	require.Equal(t, []string{"b", "d", "a", "c"}, names(topKCaches(caches, 0)))
+	require.Equal(t, []string{"b", "d", "a", "c"}, names(topKCaches(caches, 10)))
+}
+
+func TestInit(t *testing.T) {
+	t.Parallel()
+
+	require.NoError(t, (&IllumosKmem{}).Init())
+	require.Error(t, (&IllumosKmem{IncludeNames: []string{"/[/"}}).Init())
+	require.Error(t, (&IllumosKmem{ExcludeNames: []string{"/(unclosed/"}}).Init())
+}
+
+func TestPlugin(t *testing.T) {
+	t.Parallel()
+
+	s := &IllumosKmem{TopK: 2}
+	require.NoError(t, s.Init())
+
+	newKStatSource = helpers.FixtureKStatSource()
+
+	acc := testutil.Accumulator{}
+	require.NoError(t, s.Gather(&acc))
+
+	testutil.RequireMetricsEqual(
+		t,
+		[]telegraf.Metric{
+			testutil.MustMetric(
+				"kmem.cache",
+				map[string]string{"cache": "zio_buf_131072"},
+				map[string]interface{}{
+					"memory":     float64(131072000),
+					"buf_size":   float64(131072),
+					"buf_inuse":  float64(620),
+					"buf_total":  float64(1000),
+					"alloc":      float64(55512345),
+					"alloc_fail": float64(0),
+				},
+				time.Now(),
+			),
+			testutil.MustMetric(
+				"kmem.cache",
+				map[string]string{"cache": "dnode_t"},
+				map[string]interface{}{
+					"memory":     float64(102400000),
+					"buf_size":   float64(752),
+					"buf_inuse":  float64(119876),
+					"buf_total":  float64(125000),
+					"alloc":      float64(7654321),
+					"alloc_fail": float64(3),
+				},
+				time.Now(),
+			),
+		},
+		acc.GetTelegrafMetrics(),
+		testutil.IgnoreTime(),
+		testutil.SortMetrics(),
+	)
+}
+
+func TestPluginFilters(t *testing.T) {
+	t.Parallel()
+
+	s := &IllumosKmem{
+		IncludeNames: []string{"kmem_alloc_*", "/^(zio|streams)_/"},
+		ExcludeNames: []string{"zio_buf_131072"},
+	}
+	require.NoError(t, s.Init())
+
+	newKStatSource = helpers.FixtureKStatSource()
+
+	acc := testutil.Accumulator{}
+	require.NoError(t, s.Gather(&acc))
+
+	var caches []string
+
+	for _, metric := range acc.GetTelegrafMetrics() {
+		cache, _ := metric.GetTag("cache")
+		caches = append(caches, cache)
+	}
+
+	require.Equal(t, []string{"kmem_alloc_32", "streams_mblk"}, caches)
+}
+
+// Counters need two collections to make a rate, so this swaps newKStatSource for a replay.
+//
+//nolint:paralleltest
+func TestPluginRates(t *testing.T) {
+	s := &IllumosKmem{IncludeNames: []string{"kmem_alloc_32"}, Rates: true}
+	require.NoError(t, s.Init())
+
+	// The second tick doubles every stat but the slab size, so doubles the memory in use.
+	helpers.UseFixtureReplay(t, &newKStatSource, "slab_size")
+
+	acc := testutil.Accumulator{}
+	require.NoError(t, s.Gather(&acc))
+
+	metric, ok := acc.Get("kmem.cache")
+	require.True(t, ok)
+	require.Equal(t, float64(41779200), metric.Fields["memory"])
+	require.NotContains(t, metric.Fields, "alloc")
+	require.NotContains(t, metric.Fields, "alloc_fail")
+
+	acc.ClearMetrics()
+	require.NoError(t, s.Gather(&acc))
+
+	metric, ok = acc.Get("kmem.cache")
+	require.True(t, ok)
+	require.Equal(t, float64(41779200*2), metric.Fields["memory"])
+	require.InDelta(t, float64(98765432.1), metric.Fields["alloc"], 0.001)
+	require.Equal(t, float64(0), metric.Fields["alloc_fail"])
+}
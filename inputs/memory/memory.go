@@ -4,22 +4,28 @@ import (
 	"fmt"
 	"log"
 	"os"
-	"regexp"
 
-	"github.com/illumos/go-kstat"
 	"github.com/influxdata/telegraf"
 	"github.com/influxdata/telegraf/plugins/inputs"
 	"github.com/snltd/illumos-telegraf-plugins/helpers"
 )
 
 var sampleConfig = `
-	## Whether to produce metrics from the output of 'swap -s'
+	## Whether to produce the figures 'swap -s' gives. They are worked out from the vminfo
+	## kstats, or, if that can't be done, as on the first collection, by running 'swap -s'.
 	# swap_on = true
 	## And which fields to use. Specifying none implies all.
 	# swap_fields = ["allocated", "reserved", "used", "available"]
+	## Whether to report the size and free space of each swap device, as 'swap -l' does
+	# swap_devices_on = false
 	## Whether to report "extra" fields, and which ones (kernel, arcsize, freelist)
 	# extra_on = true
 	# extra_fields = ["kernel", "arcsize", "freelist"]
+	## Whether to break down physical memory as 'mdb ::memstat' does, from kstats
+	# memstat_on = false
+	## Whether to report signs of memory pressure: the page scanner's thresholds, how hard it
+	## is working, and whether the ARC is being squeezed. Rates appear from the second collection.
+	# pressure_on = false
   ## Whether to collect vminfo kstats, and which ones.
 	# vminfo_on = true
 	# vminfo_fields = ["freemem", "swap_alloc", "swap_avail", "swap_free", "swap_resv"]
@@ -32,9 +38,16 @@ var sampleConfig = `
 	# zone_memcap_on = true
 	# zone_memcap_zones = []
 	# zone_memcap_fields = ["physcap", "rss", "swap"]
+	## Whether to report the RSS, swap reservation and locked memory of every running zone,
+	## capped or not, and which zones. Capped zones also get their paging counters.
+	# zone_memory_on = false
+	# zone_memory_zones = []
 `
 
-var pageSize float64
+var (
+	pageSize       float64
+	newKStatSource = helpers.NewKStatSource
+)
 
 func (s *IllumosMemory) Description() string {
 	return "Reports on illumos virtual and physical memory usage."
@@ -47,8 +60,11 @@ func (s *IllumosMemory) SampleConfig() string {
 type IllumosMemory struct {
 	SwapOn           bool
 	SwapFields       []string
+	SwapDevicesOn    bool
 	ExtraOn          bool
 	ExtraFields      []string
+	MemstatOn        bool
+	PressureOn       bool
 	VminfoOn         bool
 	VminfoFields     []string
 	CpuvmOn          bool
@@ -57,16 +73,17 @@ type IllumosMemory struct {
 	ZoneMemcapOn     bool
 	ZoneMemcapZones  []string
 	ZoneMemcapFields []string
+	ZoneMemoryOn     bool
+	ZoneMemoryZones  []string
+	prevVminfo       *helpers.Vminfo
+	prevArcTarget    *float64
+	rates            *helpers.Rates
 }
 
 func (s *IllumosMemory) Gather(acc telegraf.Accumulator) error {
 	tags := make(map[string]string)
 
-	if s.SwapOn {
-		acc.AddFields("memory.swap", parseSwap(s), tags)
-	}
-
-	token, err := kstat.Open()
+	token, err := newKStatSource()
 
 	if err != nil {
 		return err
@@ -74,6 +91,20 @@ func (s *IllumosMemory) Gather(acc telegraf.Accumulator) error {
 
 	defer token.Close()
 
+	var avg *vminfoAverages
+
+	if s.SwapOn || s.MemstatOn {
+		avg = s.sampleVminfo(token)
+	}
+
+	if s.SwapOn {
+		acc.AddFields("memory.swap", swapStats(s, avg), tags)
+	}
+
+	if s.SwapDevicesOn {
+		gatherSwapDevices(acc)
+	}
+
 	if s.ExtraOn {
 		acc.AddFields("memory", extraKStats(s, token), tags)
 	}
@@ -82,6 +113,18 @@ func (s *IllumosMemory) Gather(acc telegraf.Accumulator) error {
 		acc.AddFields("memory.vminfo", vminfoKStats(s, token), tags)
 	}
 
+	if s.MemstatOn {
+		acc.AddFields("memory.memstat", memstatKStats(token, avg), tags)
+	}
+
+	if s.PressureOn {
+		if s.rates == nil {
+			s.rates = helpers.NewRates()
+		}
+
+		acc.AddFields("memory.pressure", pressureKStats(s, token), tags)
+	}
+
 	if s.CpuvmOn {
 		acc.AddFields("memory.cpuVm", cpuvmKStats(s, token), tags)
 	}
@@ -93,10 +136,14 @@ func (s *IllumosMemory) Gather(acc telegraf.Accumulator) error {
 		}
 	}
 
+	if s.ZoneMemoryOn {
+		gatherZoneMemoryStats(s, acc, token)
+	}
+
 	return nil
 }
 
-func parseZoneMemcapStats(s *IllumosMemory, stats []*kstat.Named) (map[string]interface{}, map[string]string) {
+func parseZoneMemcapStats(s *IllumosMemory, stats []*helpers.Named) (map[string]interface{}, map[string]string) {
 	fields := make(map[string]interface{})
 	tags := make(map[string]string)
 
@@ -112,7 +159,7 @@ func parseZoneMemcapStats(s *IllumosMemory, stats []*kstat.Named) (map[string]in
 	return fields, tags
 }
 
-func gatherZoneMemcapStats(s *IllumosMemory, acc telegraf.Accumulator, token *kstat.Token) error {
+func gatherZoneMemcapStats(s *IllumosMemory, acc telegraf.Accumulator, token helpers.KStatSource) error {
 	memcapStats := helpers.KStatsInModule(token, "memory_cap")
 
 	for _, stat := range memcapStats {
@@ -130,7 +177,7 @@ func gatherZoneMemcapStats(s *IllumosMemory, acc telegraf.Accumulator, token *ks
 	return nil
 }
 
-func extraKStats(s *IllumosMemory, token *kstat.Token) map[string]interface{} {
+func extraKStats(s *IllumosMemory, token helpers.KStatSource) map[string]interface{} {
 	fields := make(map[string]interface{})
 
 	// My error handling here is permissive. I don't see why failing to get one kstat should stop us
@@ -171,10 +218,10 @@ func extraKStats(s *IllumosMemory, token *kstat.Token) map[string]interface{} {
 
 // The raw kstats in here are gauges, measured in pages. So we need to convert them to bytes here,
 // and you need to apply some kind of rate() function in your graphing software.
-func vminfoKStats(s *IllumosMemory, token *kstat.Token) map[string]interface{} {
+func vminfoKStats(s *IllumosMemory, token helpers.KStatSource) map[string]interface{} {
 	fields := make(map[string]interface{})
 
-	_, vi, err := token.Vminfo()
+	vi, err := token.Vminfo()
 
 	if err != nil {
 		log.Print("cannot get vminfo kstats")
@@ -205,8 +252,63 @@ func vminfoKStats(s *IllumosMemory, token *kstat.Token) map[string]interface{} {
 	return fields
 }
 
+// vminfoAverages are the vminfo figures, in bytes, averaged over the time since the last
+// collection.
+type vminfoAverages struct {
+	freemem float64
+	resv    float64
+	alloc   float64
+	avail   float64
+	free    float64
+}
+
+// averageVminfo works out vminfoAverages from two samples of the vminfo kstat. The kernel adds
+// the current page counts to vminfo every second, and counts how many times it has done so in
+// Updates, so the difference between two samples, divided by the number of updates between
+// them, is the average over that time.
+func averageVminfo(prev, curr *helpers.Vminfo) (vminfoAverages, bool) {
+	if prev == nil || curr == nil || curr.Updates <= prev.Updates {
+		return vminfoAverages{}, false
+	}
+
+	updates := float64(curr.Updates - prev.Updates)
+	avg := func(prev, curr uint64) float64 {
+		return float64(curr-prev) / updates * pageSize
+	}
+
+	return vminfoAverages{
+		freemem: avg(prev.Freemem, curr.Freemem),
+		resv:    avg(prev.Resv, curr.Resv),
+		alloc:   avg(prev.Alloc, curr.Alloc),
+		avail:   avg(prev.Avail, curr.Avail),
+		free:    avg(prev.Free, curr.Free),
+	}, true
+}
+
+// sampleVminfo reads vminfo and returns the averages since the previous call. There is nothing
+// to compare with on the first call, so then it returns nil.
+func (s *IllumosMemory) sampleVminfo(token helpers.KStatSource) *vminfoAverages {
+	vi, err := token.Vminfo()
+	if err != nil {
+		log.Print("cannot get vminfo kstats")
+
+		return nil
+	}
+
+	curr := *vi
+	prev := s.prevVminfo
+	s.prevVminfo = &curr
+
+	avg, ok := averageVminfo(prev, &curr)
+	if !ok {
+		return nil
+	}
+
+	return &avg
+}
+
 // The only named stats we need to parse in this collector are the ones from cpuvmKStats().
-func parseNamedStats(s *IllumosMemory, stats []*kstat.Named) map[string]interface{} {
+func parseNamedStats(s *IllumosMemory, stats []*helpers.Named) map[string]interface{} {
 	fields := make(map[string]interface{})
 
 	for _, stat := range stats {
@@ -220,7 +322,7 @@ func parseNamedStats(s *IllumosMemory, stats []*kstat.Named) map[string]interfac
 
 type cpuvmStatHolder map[int]map[string]interface{}
 
-func perCpuvmKStats(s *IllumosMemory, token *kstat.Token) cpuvmStatHolder {
+func perCpuvmKStats(s *IllumosMemory, token helpers.KStatSource) cpuvmStatHolder {
 	perCPUStats := make(cpuvmStatHolder)
 	modStats := helpers.KStatsInModule(token, "cpu")
 
@@ -273,7 +375,7 @@ func aggregateCpuvmKStats(stats cpuvmStatHolder) map[string]interface{} {
 	return fields
 }
 
-func cpuvmKStats(s *IllumosMemory, token *kstat.Token) map[string]interface{} {
+func cpuvmKStats(s *IllumosMemory, token helpers.KStatSource) map[string]interface{} {
 	allStats := perCpuvmKStats(s, token)
 
 	if s.CpuvmAggregate {
@@ -283,58 +385,6 @@ func cpuvmKStats(s *IllumosMemory, token *kstat.Token) map[string]interface{} {
 	return individualCpuvmKStats(allStats)
 }
 
-var runSwapCmd = func() string {
-	stdout, stderr, err := helpers.RunCmd("/usr/sbin/swap -s")
-
-	if err != nil {
-		log.Print(stderr)
-		log.Print(err)
-	}
-
-	return stdout
-}
-
-func parseSwap(s *IllumosMemory) map[string]interface{} {
-	fields := make(map[string]interface{})
-	swapline := runSwapCmd()
-	re := regexp.MustCompile(`total: (\d+k) [\w ]* \+ (\d+k).*= (\d+k) used, (\d+k).*$`)
-	m := re.FindAllStringSubmatch(swapline, -1)[0]
-
-	if helpers.WeWant("allocated", s.SwapFields) {
-		bytes, err := helpers.Bytify(m[1])
-
-		if err == nil {
-			fields["allocated"] = bytes
-		}
-	}
-
-	if helpers.WeWant("reserved", s.SwapFields) {
-		bytes, err := helpers.Bytify(m[2])
-
-		if err == nil {
-			fields["reserved"] = bytes
-		}
-	}
-
-	if helpers.WeWant("used", s.SwapFields) {
-		bytes, err := helpers.Bytify(m[3])
-
-		if err == nil {
-			fields["used"] = bytes
-		}
-	}
-
-	if helpers.WeWant("available", s.SwapFields) {
-		bytes, err := helpers.Bytify(m[4])
-
-		if err == nil {
-			fields["available"] = bytes
-		}
-	}
-
-	return fields
-}
-
 func init() {
 	pageSize = float64(os.Getpagesize())
 
@@ -9,27 +9,6 @@ import (
 	"github.com/stretchr/testify/require"
 )
 
-func TestParseSwap(t *testing.T) {
-	t.Parallel()
-
-	s := &IllumosMemory{}
-
-	runSwapCmd = func() string {
-		return "total: 2852796k bytes allocated + 1950828k reserved = 4803624k used, 2638448k available"
-	}
-
-	require.Equal(
-		t,
-		map[string]interface{}{
-			"allocated": float64(2921263104),
-			"reserved":  float64(1997647872),
-			"used":      float64(4918910976),
-			"available": float64(2701770752),
-		},
-		parseSwap(s),
-	)
-}
-
 func TestPlugin(t *testing.T) {
 	t.Parallel()
 
@@ -48,6 +27,12 @@ func TestPlugin(t *testing.T) {
 		ZoneMemcapFields: []string{"physcap", "rss", "swap"},
 	}
 
+	runSwapCmd = func() string {
+		return "total: 2852796k bytes allocated + 1950828k reserved = 4803624k used, 2638448k available"
+	}
+
+	newKStatSource = helpers.FixtureKStatSource()
+
 	acc := testutil.Accumulator{}
 	require.NoError(t, s.Gather(&acc))
 
@@ -93,7 +78,15 @@ func TestPlugin(t *testing.T) {
 	_, present := cpuvmMetric.GetField("vm.aggregate.pgin")
 	require.False(t, present)
 
-	// zone memcap metrics can't be tested because my build zone doesn't have them
+	// zone memcap metrics
+	memcapMetric := acc.GetTelegrafMetrics()[4]
+	require.Equal(t, "memory.zone", memcapMetric.Name())
+	require.True(t, memcapMetric.HasTag("zone"))
+
+	for _, field := range s.ZoneMemcapFields {
+		_, present := memcapMetric.GetField(field)
+		require.True(t, present)
+	}
 }
 
 func TestPluginAggregates(t *testing.T) {
@@ -105,6 +98,8 @@ func TestPluginAggregates(t *testing.T) {
 		CpuvmAggregate: true,
 	}
 
+	newKStatSource = helpers.FixtureKStatSource()
+
 	acc := testutil.Accumulator{}
 	require.NoError(t, s.Gather(&acc))
 
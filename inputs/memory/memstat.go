@@ -0,0 +1,73 @@
+package memory
+
+import (
+	"log"
+
+	"github.com/snltd/illumos-telegraf-plugins/helpers"
+)
+
+// memstatPages are the unix:0:system_pages stats the breakdown is built from, all in pages.
+var memstatPages = []string{"physmem", "pp_kernel", "freemem"}
+
+// breakdownMemory splits physical memory up along the lines of mdb's ::memstat, as near as the
+// kstats let us. ::memstat walks every page in the system, which we can't do, so not all of its
+// categories can be had, and those which can't are not given its names:
+//
+//   - ZFS file data is the ARC's data, and kernel is everything else pp_kernel counts.
+//   - free is the freelist and the cachelist together. Nothing outside the kernel tells them
+//     apart, but on ZFS systems the cachelist is usually tiny.
+//   - swap_allocated is the anonymous memory allocated since the last collection, from vminfo,
+//     so it is only there from the second collection. It includes anything which has been paged
+//     out, which ::memstat's "Anon" does not, so it is capped at what is left.
+//   - other is whatever is left. It is mostly ::memstat's "Exec and libs" and "Page cache", but
+//     any anonymous memory swap_allocated misses ends up here too.
+//
+// All the arguments, and the values returned, are in bytes.
+func breakdownMemory(
+	physmem, ppKernel, freemem, arcData float64,
+	avg *vminfoAverages,
+) map[string]interface{} {
+	zfsFileData := min(arcData, ppKernel)
+	kernel := ppKernel - zfsFileData
+
+	fields := map[string]interface{}{
+		"total":         physmem,
+		"kernel":        kernel,
+		"zfs_file_data": zfsFileData,
+		"free":          freemem,
+	}
+
+	if avg != nil {
+		user := max(physmem-kernel-zfsFileData-freemem, 0)
+		swapAllocated := min(avg.alloc, user)
+
+		fields["swap_allocated"] = swapAllocated
+		fields["other"] = user - swapAllocated
+	}
+
+	return fields
+}
+
+func memstatKStats(token helpers.KStatSource, avg *vminfoAverages) map[string]interface{} {
+	pages := make(map[string]float64, len(memstatPages))
+
+	for _, name := range memstatPages {
+		stat, err := token.GetNamed("unix", 0, "system_pages", name)
+		if err != nil {
+			log.Printf("could not get %s kstat", name)
+
+			return map[string]interface{}{}
+		}
+
+		pages[name] = helpers.NamedValue(stat).(float64) * pageSize
+	}
+
+	// A system without ZFS has no ARC.
+	arcData := float64(0)
+
+	if stat, err := token.GetNamed("zfs", 0, "arcstats", "data_size"); err == nil {
+		arcData = helpers.NamedValue(stat).(float64)
+	}
+
+	return breakdownMemory(pages["physmem"], pages["pp_kernel"], pages["freemem"], arcData, avg)
+}
@@ -0,0 +1,113 @@
+package memory
+
+import (
+	"testing"
+
+	"github.com/influxdata/telegraf/testutil"
+	"github.com/snltd/illumos-telegraf-plugins/helpers"
+	"github.com/stretchr/testify/require"
+)
+
+func TestBreakdownMemory(t *testing.T) {
+	t.Parallel()
+
+	require.Equal(
+		t,
+		map[string]interface{}{
+			"total":         float64(1000),
+			"kernel":        float64(150),
+			"zfs_file_data": float64(250),
+			"free":          float64(100),
+		},
+		breakdownMemory(1000, 400, 100, 250, nil),
+	)
+
+	require.Equal(
+		t,
+		map[string]interface{}{
+			"total":          float64(1000),
+			"kernel":         float64(150),
+			"zfs_file_data":  float64(250),
+			"free":           float64(100),
+			"swap_allocated": float64(300),
+			"other":          float64(200),
+		},
+		breakdownMemory(1000, 400, 100, 250, &vminfoAverages{alloc: 300}),
+	)
+
+	// Allocated swap can include pages which are out on swap, so can look bigger than what is left.
+	require.Equal(
+		t,
+		map[string]interface{}{
+			"total":          float64(1000),
+			"kernel":         float64(0),
+			"zfs_file_data":  float64(400),
+			"free":           float64(100),
+			"swap_allocated": float64(500),
+			"other":          float64(0),
+		},
+		breakdownMemory(1000, 400, 100, 600, &vminfoAverages{alloc: 900}),
+	)
+}
+
+func TestMemstatKStats(t *testing.T) {
+	t.Parallel()
+
+	token, err := helpers.FixtureKStatSource()()
+	require.NoError(t, err)
+
+	defer token.Close()
+
+	fields := memstatKStats(token, nil)
+
+	require.Equal(t, float64(8343393)*pageSize, fields["total"])
+	require.Equal(t, float64(5444338176), fields["zfs_file_data"])
+	require.Equal(t, float64(1402871)*pageSize-float64(5444338176), fields["kernel"])
+	require.Equal(t, float64(1218012)*pageSize, fields["free"])
+	require.NotContains(t, fields, "swap_allocated")
+}
+
+// The vminfo averages need two ticks, so this replays kstats through newKStatSource.
+//
+//nolint:paralleltest
+func TestPluginMemstat(t *testing.T) {
+	s := &IllumosMemory{SwapOn: true, MemstatOn: true}
+
+	runSwapCmd = func() string {
+		return sampleSwapOutput
+	}
+
+	helpers.UseFixtureReplay(t, &newKStatSource)
+
+	acc := testutil.Accumulator{}
+	require.NoError(t, s.Gather(&acc))
+
+	memstat, ok := acc.Get("memory.memstat")
+	require.True(t, ok)
+	require.NotContains(t, memstat.Fields, "swap_allocated")
+
+	swap, ok := acc.Get("memory.swap")
+	require.True(t, ok)
+	require.Equal(t, float64(4918910976), swap.Fields["used"])
+
+	// The second tick doubles vminfo, so the averages are the same as the averages since boot.
+	token, err := helpers.FixtureKStatSource()()
+	require.NoError(t, err)
+
+	defer token.Close()
+
+	vi, err := token.Vminfo()
+	require.NoError(t, err)
+
+	acc.ClearMetrics()
+	require.NoError(t, s.Gather(&acc))
+
+	swap, ok = acc.Get("memory.swap")
+	require.True(t, ok)
+	require.InDelta(t, float64(vi.Resv)/float64(vi.Updates)*pageSize, swap.Fields["used"], 1)
+
+	memstat, ok = acc.Get("memory.memstat")
+	require.True(t, ok)
+	require.Contains(t, memstat.Fields, "swap_allocated")
+	require.Contains(t, memstat.Fields, "other")
+}
@@ -0,0 +1,122 @@
+package memory
+
+import (
+	"log"
+
+	"github.com/snltd/illumos-telegraf-plugins/helpers"
+)
+
+// pressureThresholds are the unix:0:system_pages stats which say when the page scanner starts
+// and how hard it works, along with freemem, which is compared with them. All are in pages.
+var pressureThresholds = []string{"freemem", "lotsfree", "desfree", "minfree"}
+
+// pressureCounters are the cpu:N:vm counters which show the pageout daemon at work. They are
+// summed across CPUs and sent as per-second rates.
+var pressureCounters = []string{"scan", "pgrec", "pgfrec", "pgrrun", "rev", "dfree"}
+
+// pressureLevel sums up the state of memory. The scanner starts when freemem falls below
+// lotsfree, and once it is below desfree the kernel will start swapping out whole processes.
+func pressureLevel(freemem, lotsfree, desfree, scanRate float64) string {
+	switch {
+	case freemem < desfree:
+		return "critical"
+	case freemem < lotsfree || scanRate > 0:
+		return "low"
+	default:
+		return "none"
+	}
+}
+
+// pressureRates sums the rates of the pressureCounters across every CPU. A counter is only
+// reported once every CPU has a rate for it, so there is nothing on the first collection.
+func pressureRates(rates *helpers.Rates, token helpers.KStatSource) map[string]float64 {
+	ret := make(map[string]float64)
+	missing := make(map[string]bool)
+
+	for _, statGroup := range helpers.KStatsInModule(token, "cpu") {
+		if statGroup.Name != "vm" {
+			continue
+		}
+
+		for _, name := range pressureCounters {
+			stat, err := token.GetNamed("cpu", statGroup.Instance, "vm", name)
+			if err != nil {
+				missing[name] = true
+
+				continue
+			}
+
+			if rate, ok := rates.NamedRate(stat); ok {
+				ret[name] += rate
+			} else {
+				missing[name] = true
+			}
+		}
+	}
+
+	for name := range missing {
+		delete(ret, name)
+	}
+
+	return ret
+}
+
+// arcPressure reports how much ZFS is being squeezed. memory_throttle_count goes up every time
+// the ARC holds up a write because memory is short, and the target size, c, falls when the
+// kernel asks the ARC to give memory back.
+func (s *IllumosMemory) arcPressure(token helpers.KStatSource, fields map[string]interface{}) {
+	if stat, err := token.GetNamed("zfs", 0, "arcstats", "memory_throttle_count"); err == nil {
+		if rate, ok := s.rates.NamedRate(stat); ok {
+			fields["arc_throttle"] = rate
+		}
+	}
+
+	stat, err := token.GetNamed("zfs", 0, "arcstats", "c")
+	if err != nil {
+		return
+	}
+
+	target := helpers.NamedValue(stat).(float64)
+	fields["arc_target"] = target
+
+	if s.prevArcTarget != nil {
+		fields["arc_shrink"] = max(*s.prevArcTarget-target, 0)
+	}
+
+	s.prevArcTarget = &target
+}
+
+func pressureKStats(s *IllumosMemory, token helpers.KStatSource) map[string]interface{} {
+	fields := make(map[string]interface{})
+	pages := make(map[string]float64, len(pressureThresholds))
+
+	for _, name := range pressureThresholds {
+		stat, err := token.GetNamed("unix", 0, "system_pages", name)
+		if err != nil {
+			log.Printf("could not get %s kstat", name)
+
+			return fields
+		}
+
+		pages[name] = helpers.NamedValue(stat).(float64)
+		fields[name] = pages[name] * pageSize
+	}
+
+	// nscan is the number of pages the scanner looked at in its last run.
+	if stat, err := token.GetNamed("unix", 0, "system_pages", "nscan"); err == nil {
+		fields["nscan"] = helpers.NamedValue(stat).(float64)
+	}
+
+	rates := pressureRates(s.rates, token)
+
+	for name, rate := range rates {
+		fields[name] = rate
+	}
+
+	s.arcPressure(token, fields)
+	s.rates.Sweep()
+
+	fields["level"] = pressureLevel(pages["freemem"], pages["lotsfree"], pages["desfree"], rates["scan"])
+
+	return fields
+}
@@ -0,0 +1,88 @@
+package memory
+
+import (
+	"testing"
+
+	"github.com/influxdata/telegraf/testutil"
+	"github.com/snltd/illumos-telegraf-plugins/helpers"
+	"github.com/stretchr/testify/require"
+)
+
+func TestPressureLevel(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		name                                 string
+		freemem, lotsfree, desfree, scanRate float64
+		want                                 string
+	}{
+		{"plenty free", 1000, 100, 50, 0, "none"},
+		{"below lotsfree", 90, 100, 50, 0, "low"},
+		{"scanning", 1000, 100, 50, 12, "low"},
+		{"below desfree", 40, 100, 50, 0, "critical"},
+		{"below desfree and scanning", 40, 100, 50, 12, "critical"},
+	}
+
+	for _, tt := range tests {
+		require.Equal(t, tt.want, pressureLevel(tt.freemem, tt.lotsfree, tt.desfree, tt.scanRate), tt.name)
+	}
+}
+
+func TestArcShrink(t *testing.T) {
+	t.Parallel()
+
+	token, err := helpers.FixtureKStatSource()()
+	require.NoError(t, err)
+
+	defer token.Close()
+
+	prev := float64(7000000000)
+	s := &IllumosMemory{rates: helpers.NewRates(), prevArcTarget: &prev}
+	fields := make(map[string]interface{})
+
+	s.arcPressure(token, fields)
+
+	require.Equal(t, float64(6753403688), fields["arc_target"])
+	require.Equal(t, prev-float64(6753403688), fields["arc_shrink"])
+	require.NotContains(t, fields, "arc_throttle")
+	require.Equal(t, float64(6753403688), *s.prevArcTarget)
+}
+
+// The scanner rates and arc_shrink need a second tick, from a replay in newKStatSource.
+//
+//nolint:paralleltest
+func TestPluginPressure(t *testing.T) {
+	s := &IllumosMemory{PressureOn: true}
+
+	helpers.UseFixtureReplay(t, &newKStatSource)
+
+	acc := testutil.Accumulator{}
+	require.NoError(t, s.Gather(&acc))
+
+	metric, ok := acc.Get("memory.pressure")
+	require.True(t, ok)
+
+	require.Equal(t, float64(1218012)*pageSize, metric.Fields["freemem"])
+	require.Equal(t, float64(130365)*pageSize, metric.Fields["lotsfree"])
+	require.Equal(t, float64(65182)*pageSize, metric.Fields["desfree"])
+	require.Equal(t, float64(32591)*pageSize, metric.Fields["minfree"])
+	require.Equal(t, float64(0), metric.Fields["nscan"])
+	require.Equal(t, "none", metric.Fields["level"])
+
+	for _, field := range append(pressureCounters, "arc_throttle", "arc_shrink") {
+		require.NotContains(t, metric.Fields, field)
+	}
+
+	// The second tick is ten seconds later, with every counter doubled.
+	acc.ClearMetrics()
+	require.NoError(t, s.Gather(&acc))
+
+	metric, ok = acc.Get("memory.pressure")
+	require.True(t, ok)
+
+	require.InDelta(t, float64(1669918+27344251)/10, metric.Fields["scan"], 0.001)
+	require.InDelta(t, float64(58+44)/10, metric.Fields["pgrrun"], 0.001)
+	require.InDelta(t, 7.9, metric.Fields["arc_throttle"], 0.001)
+	require.Equal(t, float64(0), metric.Fields["arc_shrink"])
+	require.Equal(t, "low", metric.Fields["level"])
+}
@@ -0,0 +1,182 @@
+package memory
+
+import (
+	"fmt"
+	"log"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/influxdata/telegraf"
+	"github.com/snltd/illumos-telegraf-plugins/helpers"
+)
+
+// swapSizeBlock is the unit of the sizes in the output of swap -l.
+const swapSizeBlock = 512
+
+var swapRegex = regexp.MustCompile(`total: (\d+k) [\w ]* \+ (\d+k).*= (\d+k) used, (\d+k).*$`)
+
+type swapSizes struct {
+	allocated float64
+	reserved  float64
+	used      float64
+	available float64
+}
+
+func swapFields(s *IllumosMemory, sizes swapSizes) map[string]interface{} {
+	fields := make(map[string]interface{})
+
+	if helpers.WeWant("allocated", s.SwapFields) {
+		fields["allocated"] = sizes.allocated
+	}
+
+	if helpers.WeWant("reserved", s.SwapFields) {
+		fields["reserved"] = sizes.reserved
+	}
+
+	if helpers.WeWant("used", s.SwapFields) {
+		fields["used"] = sizes.used
+	}
+
+	if helpers.WeWant("available", s.SwapFields) {
+		fields["available"] = sizes.available
+	}
+
+	return fields
+}
+
+// swapFromVminfo works out what swap -s would say from the vminfo averages. swap -s counts
+// reserved swap as used, and splits it into what has been allocated and what has only been
+// reserved.
+func swapFromVminfo(avg vminfoAverages) swapSizes {
+	return swapSizes{
+		allocated: avg.alloc,
+		reserved:  max(avg.resv-avg.alloc, 0),
+		used:      avg.resv,
+		available: avg.avail,
+	}
+}
+
+// swapStats gets the swap -s figures from the vminfo averages if there are any, and from swap -s
+// if there aren't.
+func swapStats(s *IllumosMemory, avg *vminfoAverages) map[string]interface{} {
+	if avg != nil {
+		return swapFields(s, swapFromVminfo(*avg))
+	}
+
+	sizes, err := parseSwap(runSwapCmd())
+	if err != nil {
+		log.Print(err)
+
+		return map[string]interface{}{}
+	}
+
+	return swapFields(s, sizes)
+}
+
+// parseSwap understands the output of swap -s, which looks like
+//
+//	total: 2852796k bytes allocated + 1950828k reserved = 4803624k used, 2638448k available
+func parseSwap(raw string) (swapSizes, error) {
+	m := swapRegex.FindStringSubmatch(strings.TrimSpace(raw))
+
+	if m == nil {
+		return swapSizes{}, fmt.Errorf("cannot parse swap -s output: %q", raw)
+	}
+
+	var sizes [4]float64
+
+	for i := range sizes {
+		bytes, err := helpers.Bytify(m[i+1])
+		if err != nil {
+			return swapSizes{}, err
+		}
+
+		sizes[i] = bytes
+	}
+
+	return swapSizes{
+		allocated: sizes[0],
+		reserved:  sizes[1],
+		used:      sizes[2],
+		available: sizes[3],
+	}, nil
+}
+
+type swapDevice struct {
+	name string
+	size float64
+	free float64
+}
+
+// parseSwapList understands the output of swap -l, which gives sizes in 512-byte blocks.
+//
+//	swapfile                 dev    swaplo   blocks     free
+//	/dev/zvol/dsk/rpool/swap 85,2        8  8388600  8388600
+func parseSwapList(raw string) []swapDevice {
+	var ret []swapDevice
+
+	for _, line := range strings.Split(raw, "\n") {
+		chunks := strings.Fields(line)
+
+		if len(chunks) != 5 {
+			continue
+		}
+
+		blocks, err := strconv.ParseFloat(chunks[3], 64)
+		if err != nil {
+			continue
+		}
+
+		free, err := strconv.ParseFloat(chunks[4], 64)
+		if err != nil {
+			continue
+		}
+
+		ret = append(ret, swapDevice{
+			name: chunks[0],
+			size: blocks * swapSizeBlock,
+			free: free * swapSizeBlock,
+		})
+	}
+
+	return ret
+}
+
+func gatherSwapDevices(acc telegraf.Accumulator) {
+	for _, device := range parseSwapList(runSwapListCmd()) {
+		acc.AddFields(
+			"memory.swap.device",
+			map[string]interface{}{
+				"size": device.size,
+				"free": device.free,
+				"used": device.size - device.free,
+			},
+			map[string]string{"device": device.name},
+		)
+	}
+}
+
+// Functions below here are vars so they can be injected by the tests.
+
+var runSwapCmd = func() string {
+	stdout, stderr, err := helpers.RunCmd("/usr/sbin/swap -s")
+
+	if err != nil {
+		log.Print(stderr)
+		log.Print(err)
+	}
+
+	return stdout
+}
+
+var runSwapListCmd = func() string {
+	stdout, stderr, err := helpers.RunCmd("/usr/sbin/swap -l")
+
+	if err != nil {
+		log.Print(stderr)
+		log.Print(err)
+	}
+
+	return stdout
+}
@@ -0,0 +1,154 @@
+package memory
+
+import (
+	"testing"
+	"time"
+
+	"github.com/influxdata/telegraf"
+	"github.com/influxdata/telegraf/testutil"
+	"github.com/snltd/illumos-telegraf-plugins/helpers"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseSwap(t *testing.T) {
+	t.Parallel()
+
+	sizes, err := parseSwap(sampleSwapOutput)
+	require.NoError(t, err)
+	require.Equal(
+		t,
+		swapSizes{
+			allocated: float64(2921263104),
+			reserved:  float64(1997647872),
+			used:      float64(4918910976),
+			available: float64(2701770752),
+		},
+		sizes,
+	)
+
+	_, err = parseSwap("")
+	require.Error(t, err)
+
+	_, err = parseSwap("swap: permission denied")
+	require.Error(t, err)
+}
+
+func TestSwapFields(t *testing.T) {
+	t.Parallel()
+
+	sizes := swapSizes{allocated: 1, reserved: 2, used: 3, available: 4}
+
+	require.Equal(
+		t,
+		map[string]interface{}{
+			"allocated": float64(1),
+			"reserved":  float64(2),
+			"used":      float64(3),
+			"available": float64(4),
+		},
+		swapFields(&IllumosMemory{}, sizes),
+	)
+
+	require.Equal(
+		t,
+		map[string]interface{}{"used": float64(3)},
+		swapFields(&IllumosMemory{SwapFields: []string{"used"}}, sizes),
+	)
+}
+
+func TestSwapFromVminfo(t *testing.T) {
+	t.Parallel()
+
+	require.Equal(
+		t,
+		swapSizes{allocated: 40, reserved: 60, used: 100, available: 200},
+		swapFromVminfo(vminfoAverages{resv: 100, alloc: 40, avail: 200}),
+	)
+}
+
+func TestParseSwapList(t *testing.T) {
+	t.Parallel()
+
+	require.Equal(
+		t,
+		[]swapDevice{
+			{name: "/dev/zvol/dsk/rpool/swap", size: 4294963200, free: 4294963200},
+			{name: "/var/tmp/swapfile", size: 1073741824, free: 536870912},
+		},
+		parseSwapList(sampleSwapListOutput),
+	)
+
+	require.Empty(t, parseSwapList("No swap devices configured"))
+}
+
+// Not parallel, because it replaces runSwapCmd and runSwapListCmd.
+//
+//nolint:paralleltest
+func TestPluginSwap(t *testing.T) {
+	s := &IllumosMemory{
+		SwapOn:        true,
+		SwapFields:    []string{"used", "available"},
+		SwapDevicesOn: true,
+	}
+
+	runSwapCmd = func() string {
+		return sampleSwapOutput
+	}
+
+	runSwapListCmd = func() string {
+		return sampleSwapListOutput
+	}
+
+	newKStatSource = helpers.FixtureKStatSource()
+
+	acc := testutil.Accumulator{}
+	require.NoError(t, s.Gather(&acc))
+
+	// There is no previous vminfo on the first run, so we fall back to swap -s.
+	testutil.RequireMetricsEqual(
+		t,
+		[]telegraf.Metric{
+			testutil.MustMetric(
+				"memory.swap",
+				map[string]string{},
+				map[string]interface{}{
+					"used":      float64(4918910976),
+					"available": float64(2701770752),
+				},
+				time.Now(),
+			),
+			testutil.MustMetric(
+				"memory.swap.device",
+				map[string]string{"device": "/dev/zvol/dsk/rpool/swap"},
+				map[string]interface{}{
+					"size": float64(4294963200),
+					"free": float64(4294963200),
+					"used": float64(0),
+				},
+				time.Now(),
+			),
+			testutil.MustMetric(
+				"memory.swap.device",
+				map[string]string{"device": "/var/tmp/swapfile"},
+				map[string]interface{}{
+					"size": float64(1073741824),
+					"free": float64(536870912),
+					"used": float64(536870912),
+				},
+				time.Now(),
+			),
+		},
+		acc.GetTelegrafMetrics(),
+		testutil.SortMetrics(),
+		testutil.IgnoreTime())
+
+	require.NotNil(t, s.prevVminfo)
+}
+
+const (
+	sampleSwapOutput     = "total: 2852796k bytes allocated + 1950828k reserved = 4803624k used, 2638448k available\n"
+	sampleSwapListOutput = `swapfile                 dev    swaplo   blocks     free
+/dev/zvol/dsk/rpool/swap 85,2        8  8388600  8388600
+/var/tmp/swapfile          -         8  2097152  1048576
+`
+)
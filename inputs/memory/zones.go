@@ -0,0 +1,131 @@
+package memory
+
+import (
+	"fmt"
+	"log"
+	"math"
+	"strconv"
+	"strings"
+
+	"github.com/influxdata/telegraf"
+	"github.com/snltd/illumos-telegraf-plugins/helpers"
+)
+
+// zoneMemoryCaps maps the caps:N:<kstat>_zone_N kstats, which every running zone has, capped or
+// not, to the fields they become. usage is what the zone is using and value is its cap.
+var zoneMemoryCaps = []struct {
+	kstat string
+	field string
+}{
+	{"physicalmem", "rss"},
+	{"swapresv", "swap"},
+	{"lockedmem", "lockedmem"},
+}
+
+// zonePagingStats are the memory_cap:N:zonename counters, which only exist for zones the kernel
+// is capping.
+var zonePagingStats = []string{"nover", "pagedout", "pgpgin", "anonpgin", "execpgin", "fspgin"}
+
+// zoneMemoryFields gets the memory usage of a single zone. A cap of UINT64_MAX means there is no
+// cap, so it is not reported.
+func zoneMemoryFields(token helpers.KStatSource, zoneID int, zone string) map[string]interface{} {
+	fields := make(map[string]interface{})
+
+	for _, zoneCap := range zoneMemoryCaps {
+		name := fmt.Sprintf("%s_zone_%d", zoneCap.kstat, zoneID)
+
+		if usage, err := token.GetNamed("caps", zoneID, name, "usage"); err == nil {
+			fields[zoneCap.field] = helpers.NamedValue(usage)
+		}
+
+		if value, err := token.GetNamed("caps", zoneID, name, "value"); err == nil &&
+			value.UintVal != math.MaxUint64 {
+			fields[zoneCap.field+"_cap"] = helpers.NamedValue(value)
+		}
+	}
+
+	for _, stat := range zonePagingStats {
+		if value, err := token.GetNamed("memory_cap", zoneID, zone, stat); err == nil {
+			fields[stat] = helpers.NamedValue(value)
+		}
+	}
+
+	return fields
+}
+
+// parseZoneRSS adds up the RSS of every process in each zone, from the output of runPsRSS. It is
+// only used for zones without a physicalmem kstat. Shared pages are counted once per process, so
+// it overstates things, just as adding up RSS in prstat does.
+func parseZoneRSS(raw string) map[string]float64 {
+	ret := make(map[string]float64)
+
+	for _, line := range strings.Split(raw, "\n") {
+		chunks := strings.Fields(line)
+
+		if len(chunks) != 2 {
+			continue
+		}
+
+		kb, err := strconv.ParseFloat(chunks[1], 64)
+		if err != nil {
+			continue
+		}
+
+		ret[chunks[0]] += kb * 1024
+	}
+
+	return ret
+}
+
+// gatherZoneMemoryStats reports on every running zone, which is every zone with a zones:N kstat.
+func gatherZoneMemoryStats(s *IllumosMemory, acc telegraf.Accumulator, token helpers.KStatSource) {
+	zoneFields := make(map[string]map[string]interface{})
+
+	var needRSS []string
+
+	for _, ks := range helpers.KStatsInModule(token, "zones") {
+		if !helpers.WeWant(ks.Name, s.ZoneMemoryZones) {
+			continue
+		}
+
+		fields := zoneMemoryFields(token, ks.Instance, ks.Name)
+
+		if _, ok := fields["rss"]; !ok {
+			needRSS = append(needRSS, ks.Name)
+		}
+
+		zoneFields[ks.Name] = fields
+	}
+
+	if len(needRSS) > 0 {
+		rss := parseZoneRSS(runPsRSS())
+
+		for _, zone := range needRSS {
+			if value, ok := rss[zone]; ok {
+				zoneFields[zone]["rss"] = value
+			}
+		}
+	}
+
+	for zone, fields := range zoneFields {
+		if len(fields) == 0 {
+			log.Printf("no memory stats for zone %s", zone)
+
+			continue
+		}
+
+		acc.AddFields("memory.zone.usage", fields, map[string]string{"zone": zone})
+	}
+}
+
+// runPsRSS is a var so it can be injected by the tests.
+var runPsRSS = func() string {
+	stdout, stderr, err := helpers.RunCmd("/usr/bin/ps -eo zone=,rss=")
+
+	if err != nil {
+		log.Print(stderr)
+		log.Print(err)
+	}
+
+	return stdout
+}
@@ -0,0 +1,116 @@
+package memory
+
+import (
+	"testing"
+	"time"
+
+	"github.com/influxdata/telegraf"
+	"github.com/influxdata/telegraf/testutil"
+	"github.com/snltd/illumos-telegraf-plugins/helpers"
+	"github.com/stretchr/testify/require"
+)
+
+func TestZoneMemoryFields(t *testing.T) {
+	t.Parallel()
+
+	token, err := helpers.FixtureKStatSource()()
+	require.NoError(t, err)
+
+	defer token.Close()
+
+	require.Equal(
+		t,
+		map[string]interface{}{
+			"rss":           float64(388493312),
+			"rss_cap":       float64(1073741824),
+			"swap":          float64(454160384),
+			"swap_cap":      float64(2147483648),
+			"lockedmem":     float64(0),
+			"lockedmem_cap": float64(1073741824),
+			"nover":         float64(0),
+			"pagedout":      float64(0),
+			"pgpgin":        float64(0),
+			"anonpgin":      float64(0),
+			"execpgin":      float64(0),
+			"fspgin":        float64(0),
+		},
+		zoneMemoryFields(token, 7, "serv-wf"),
+	)
+
+	require.Equal(
+		t,
+		map[string]interface{}{
+			"rss":       float64(181059584),
+			"swap":      float64(309559296),
+			"lockedmem": float64(4096000),
+		},
+		zoneMemoryFields(token, 0, "global"),
+	)
+
+	require.Empty(t, zoneMemoryFields(token, 9, "serv-old"))
+}
+
+func TestParseZoneRSS(t *testing.T) {
+	t.Parallel()
+
+	require.Equal(
+		t,
+		map[string]float64{
+			"global":   float64(3072 * 1024),
+			"serv-old": float64(1536 * 1024),
+		},
+		parseZoneRSS(samplePsRSSOutput),
+	)
+}
+
+// Not parallel, because it replaces runPsRSS.
+//
+//nolint:paralleltest
+func TestPluginZoneMemory(t *testing.T) {
+	s := &IllumosMemory{
+		ZoneMemoryOn:    true,
+		ZoneMemoryZones: []string{"global", "serv-old"},
+	}
+
+	runPsRSS = func() string {
+		return samplePsRSSOutput
+	}
+
+	newKStatSource = helpers.FixtureKStatSource()
+
+	acc := testutil.Accumulator{}
+	require.NoError(t, s.Gather(&acc))
+
+	testutil.RequireMetricsEqual(
+		t,
+		[]telegraf.Metric{
+			testutil.MustMetric(
+				"memory.zone.usage",
+				map[string]string{"zone": "global"},
+				map[string]interface{}{
+					"rss":       float64(181059584),
+					"swap":      float64(309559296),
+					"lockedmem": float64(4096000),
+				},
+				time.Now(),
+			),
+			testutil.MustMetric(
+				"memory.zone.usage",
+				map[string]string{"zone": "serv-old"},
+				map[string]interface{}{
+					"rss": float64(1536 * 1024),
+				},
+				time.Now(),
+			),
+		},
+		acc.GetTelegrafMetrics(),
+		testutil.SortMetrics(),
+		testutil.IgnoreTime())
+}
+
+const samplePsRSSOutput = `  global  1024
+  global  2048
+serv-old   512
+serv-old  1024
+junk
+`
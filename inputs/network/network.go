@@ -3,8 +3,9 @@ package network
 import (
 	"fmt"
 	"log"
+	"strings"
+	"time"
 
-	"github.com/illumos/go-kstat"
 	"github.com/influxdata/telegraf"
 	"github.com/influxdata/telegraf/plugins/inputs"
 	"github.com/snltd/illumos-telegraf-plugins/helpers"
@@ -17,7 +18,13 @@ var sampleConfig = `
 	## The VNICs you wish to observe. Again, specifying none collects all.
 	# vnics  = ["net0"]
 	## The zones you wish to monitor. Specifying none collects all.
-	# zones = ["zone1", "zone2"]`
+	# zones = ["zone1", "zone2"]
+	## Emit per-second rates rather than raw counters. Nothing is reported for a counter until it
+	## has been seen twice.
+	# rates = false
+	## Which links are in which zones, and what they sit on, comes from dladm. That is cached,
+	## and only looked at again when a link appears or is recreated, or after this long.
+	# topology_refresh = "5m"`
 
 func (s *IllumosNetwork) Description() string {
 	return "Reports on illumos NIC Usage. Zone-aware."
@@ -28,25 +35,45 @@ func (s *IllumosNetwork) SampleConfig() string {
 }
 
 type IllumosNetwork struct {
-	Zones  []helpers.ZoneName
-	Fields []string
-	Vnics  []string
+	Zones           []helpers.ZoneName
+	Fields          []string
+	Vnics           []string
+	Rates           bool
+	TopologyRefresh string
+	rates           *helpers.Rates
+	topologyRefresh time.Duration
+	topology        *topology
 }
 
-type zoneTagMap struct {
-	zone  helpers.ZoneName
-	link  string
-	speed string
-	name  string
+func (s *IllumosNetwork) Init() error {
+	if s.TopologyRefresh != "" {
+		refresh, err := time.ParseDuration(s.TopologyRefresh)
+		if err != nil {
+			return fmt.Errorf("cannot parse topology_refresh: %w", err)
+		}
+
+		s.topologyRefresh = refresh
+	}
+
+	return nil
+}
+
+// link stats which are not counters, so are never turned into rates.
+var linkGauges = map[string]bool{
+	"ifspeed":     true,
+	"link_duplex": true,
+	"link_state":  true,
 }
 
 var (
-	makeZoneVnicMap = helpers.NewZoneVnicMap
+	makeDatalinkMap = helpers.NewDatalinkMap
+	newKStatSource  = helpers.NewKStatSource
+	currentZone     = helpers.CurrentZone
 	zoneName        = helpers.ZoneName("")
 )
 
 func (s *IllumosNetwork) Gather(acc telegraf.Accumulator) error {
-	token, err := kstat.Open()
+	token, err := newKStatSource()
 	if err != nil {
 		log.Print("cannot get kstat token")
 
@@ -55,24 +82,31 @@ func (s *IllumosNetwork) Gather(acc telegraf.Accumulator) error {
 
 	defer token.Close()
 
-	links := helpers.KStatsInModule(token, "link")
+	if s.Rates && s.rates == nil {
+		s.rates = helpers.NewRates()
+	}
 
-	for _, link := range links {
-		// links are of the form link:0:dns_net0 for non-global zones, and link:0:rge0 (net) for the
-		// global. (On Solaris the module number corresponds to the zone ID, but not on Illumos.)
-		stats, _ := link.AllNamed()
+	defer s.rates.Sweep()
 
-		if err != nil {
-			log.Printf("cannot get named link kstats for %s\n", link.Name)
-		}
+	if zoneName == "" {
+		zoneName = currentZone()
+	}
+
+	if s.topology == nil {
+		s.topology = newTopology(s.topologyRefresh)
+	}
+
+	// links are of the form link:0:dns_net0 for non-global zones, and link:0:rge0 (net) for the
+	// global. (On Solaris the module number corresponds to the zone ID, but not on Illumos.)
+	links := helpers.KStatsInModule(token, "link")
+	datalinks := s.topology.datalinksFor(links)
 
-		vnicMap := makeZoneVnicMap()
-		vnic := vnicMap[link.Name]
-		zone := vnic.Zone
+	for _, link := range links {
+		datalink := datalinks[link.Name]
+		zone := datalink.Zone
 
-		// If our vnicMap can't tell us which zone this belongs to, let's assume that it belongs to
-		// the current zone. This might need to be smarter, but it's a reasonable first step. It
-		// might be nice to pull some info about the physical NIC out into tags.
+		// If dladm can't tell us which zone this belongs to, let's assume that it belongs to the
+		// current zone. Only VNICs are given to zones, so this is right for everything else.
 		if zone == "" {
 			zone = zoneName
 		}
@@ -81,42 +115,72 @@ func (s *IllumosNetwork) Gather(acc telegraf.Accumulator) error {
 			continue
 		}
 
-		zoneTags := zoneTags(zone, link.Name, vnic)
-		zoneTagsMap := make(map[string]string)
-		zoneTagsMap["zone"] = string(zoneTags.zone)
-		zoneTagsMap["link"] = zoneTags.link
-		zoneTagsMap["speed"] = zoneTags.speed
-		zoneTagsMap["name"] = zoneTags.name
-
-		acc.AddFields(
-			"net",
-			parseNamedStats(s, stats),
-			zoneTagsMap,
-		)
+		stats, err := link.AllNamed()
+		if err != nil {
+			log.Printf("cannot get named link kstats for %s\n", link.Name)
+
+			continue
+		}
+
+		fields := parseNamedStats(s, stats)
+
+		if len(fields) == 0 {
+			continue
+		}
+
+		acc.AddFields("net", fields, linkTags(zone, link.Name, datalink))
 	}
 
 	return nil
 }
 
-func zoneTags(zone helpers.ZoneName, link string, vnic helpers.Vnic) zoneTagMap {
-	if zone == zoneName {
-		return zoneTagMap{
-			zone:  zoneName,
-			link:  "none",
-			speed: "unknown",
-			name:  link,
-		}
+// linkTags describes a link, and what it sits on. A link dladm doesn't know about, like one
+// in a zone with a shared IP stack, only gets its zone and name.
+func linkTags(zone helpers.ZoneName, name string, link helpers.Datalink) map[string]string {
+	tags := map[string]string{
+		"zone":  string(zone),
+		"name":  name,
+		"link":  "none",
+		"speed": "unknown",
+	}
+
+	if link.Name == "" {
+		return tags
 	}
 
-	return zoneTagMap{
-		zone:  vnic.Zone,
-		link:  vnic.Link,
-		speed: fmt.Sprintf("%dmbit", vnic.Speed),
-		name:  vnic.Name,
+	if len(link.Over) > 0 {
+		tags["link"] = strings.Join(link.Over, ",")
 	}
+
+	if link.Speed > 0 {
+		tags["speed"] = fmt.Sprintf("%dmbit", link.Speed)
+	}
+
+	tags["device"] = "none"
+
+	if len(link.Devices) > 0 {
+		tags["device"] = strings.Join(link.Devices, ",")
+	}
+
+	if link.Class != "" {
+		tags["class"] = link.Class
+		tags["lower_class"] = link.LowerClass
+		tags["mtu"] = fmt.Sprint(link.MTU)
+		tags["state"] = link.State
+	}
+
+	if link.VLAN > 0 {
+		tags["vlan"] = fmt.Sprint(link.VLAN)
+	}
+
+	if link.MAC != "" {
+		tags["mac"] = link.MAC
+	}
+
+	return tags
 }
 
-func parseNamedStats(s *IllumosNetwork, stats []*kstat.Named) map[string]interface{} {
+func parseNamedStats(s *IllumosNetwork, stats []*helpers.Named) map[string]interface{} {
 	fields := make(map[string]interface{})
 
 	for _, stat := range stats {
@@ -124,14 +188,16 @@ func parseNamedStats(s *IllumosNetwork, stats []*kstat.Named) map[string]interfa
 			continue
 		}
 
-		fields[stat.Name] = helpers.NamedValue(stat).(float64)
+		if linkGauges[stat.Name] {
+			fields[stat.Name] = helpers.NamedValue(stat).(float64)
+		} else if value, ok := s.rates.CounterValue(stat); ok {
+			fields[stat.Name] = value
+		}
 	}
 
 	return fields
 }
 
 func init() {
-	zoneName = helpers.CurrentZone()
-
 	inputs.Add("illumos_network", func() telegraf.Input { return &IllumosNetwork{} })
 }
@@ -44,37 +44,89 @@ func TestParseNamedStatsNoSelectedNics(t *testing.T) {
 	require.Equal(t, map[string]interface{}{}, fields)
 }
 
-func TestZoneTags(t *testing.T) {
+func TestLinkTags(t *testing.T) {
 	t.Parallel()
 
-	zoneName = "global"
+	datalinks := helpers.ParseDatalinks(sampleDladmOutput)
 
 	require.Equal(
 		t,
-		zoneTagMap{
-			zone:  "cube-dns",
-			link:  "rge0",
-			speed: "1000mbit",
-			name:  "dns_net0",
+		map[string]string{
+			"zone":        "cube-dns",
+			"name":        "dns_net0",
+			"link":        "rge0",
+			"speed":       "1000mbit",
+			"class":       "vnic",
+			"lower_class": "phys",
+			"device":      "rge0",
+			"mtu":         "1500",
+			"state":       "up",
+			"mac":         "2:8:20:d:e:f",
 		},
-		zoneTags("cube-dns", "dns_net0", helpers.ParseZoneVnics(sampleDladmOutput)["dns_net0"]),
+		linkTags("cube-dns", "dns_net0", datalinks["dns_net0"]),
 	)
-}
 
-func TestZoneTagsGlobal(t *testing.T) {
-	t.Parallel()
+	require.Equal(
+		t,
+		map[string]string{
+			"zone":        "global",
+			"name":        "rge0",
+			"link":        "none",
+			"speed":       "1000mbit",
+			"class":       "phys",
+			"lower_class": "phys",
+			"device":      "rge0",
+			"mtu":         "1500",
+			"state":       "up",
+			"mac":         "0:c:29:aa:bb:cc",
+		},
+		linkTags("global", "rge0", datalinks["rge0"]),
+	)
 
-	zoneName = "global"
+	require.Equal(
+		t,
+		map[string]string{
+			"zone":        "cube-db",
+			"name":        "db_int0",
+			"link":        "stub0",
+			"speed":       "unknown",
+			"class":       "vnic",
+			"lower_class": "etherstub",
+			"device":      "none",
+			"mtu":         "9000",
+			"state":       "up",
+			"vlan":        "20",
+			"mac":         "2:8:20:4:5:6",
+		},
+		linkTags("cube-db", "db_int0", datalinks["db_int0"]),
+	)
 
 	require.Equal(
 		t,
-		zoneTagMap{
-			zone:  "global",
-			link:  "none",
-			speed: "unknown",
-			name:  "rge0",
+		map[string]string{
+			"zone":        "global",
+			"name":        "backup0",
+			"link":        "rge0",
+			"speed":       "unknown",
+			"class":       "vlan",
+			"lower_class": "phys",
+			"device":      "rge0",
+			"mtu":         "1500",
+			"state":       "up",
+			"vlan":        "30",
 		},
-		zoneTags("global", "rge0", helpers.ParseZoneVnics(sampleDladmOutput)["rge0"]),
+		linkTags("global", "backup0", datalinks["backup0"]),
+	)
+
+	require.Equal(
+		t,
+		map[string]string{
+			"zone":  "global",
+			"name":  "mystery0",
+			"link":  "none",
+			"speed": "unknown",
+		},
+		linkTags("global", "mystery0", datalinks["mystery0"]),
 	)
 }
 
@@ -85,6 +137,14 @@ func TestPlugin(t *testing.T) {
 		Fields: []string{"obytes64", "rbytes64", "collisions", "ierrors"},
 	}
 
+	zoneName = "global"
+
+	makeDatalinkMap = func() helpers.DatalinkMap {
+		return helpers.ParseDatalinks(sampleDladmOutput)
+	}
+
+	newKStatSource = helpers.FixtureKStatSource()
+
 	acc := testutil.Accumulator{}
 	require.NoError(t, s.Gather(&acc))
 
@@ -94,6 +154,8 @@ func TestPlugin(t *testing.T) {
 	require.True(t, metric.HasTag("link"))
 	require.True(t, metric.HasTag("speed"))
 	require.True(t, metric.HasTag("name"))
+	require.True(t, metric.HasTag("class"))
+	require.True(t, metric.HasTag("device"))
 
 	for _, field := range s.Fields {
 		_, present := metric.GetField(field)
@@ -101,7 +163,53 @@ func TestPlugin(t *testing.T) {
 	}
 }
 
-var sampleDladmOutput = `media_net0:cube-media:rge0:1000
-dns_net0:cube-dns:rge0:1000
-pkgsrc_net0:cube-pkgsrc:rge0:1000
-backup_net0:cube-backup:rge0:1000`
+// Link counters only become rates on the second tick of a replay, which needs newKStatSource.
+func TestPluginRates(t *testing.T) { //nolint:paralleltest
+	s := &IllumosNetwork{
+		Fields: []string{"obytes64", "rbytes64", "ifspeed"},
+		Rates:  true,
+	}
+
+	zoneName = "global"
+
+	makeDatalinkMap = func() helpers.DatalinkMap {
+		return helpers.ParseDatalinks(sampleDladmOutput)
+	}
+
+	helpers.UseFixtureReplay(t, &newKStatSource)
+
+	acc := testutil.Accumulator{}
+	require.NoError(t, s.Gather(&acc))
+	require.Equal(
+		t,
+		map[string]interface{}{"ifspeed": float64(1000000000)},
+		acc.GetTelegrafMetrics()[0].Fields(),
+	)
+
+	acc.ClearMetrics()
+	require.NoError(t, s.Gather(&acc))
+	require.Equal(
+		t,
+		map[string]interface{}{
+			"ifspeed":  float64(2000000000),
+			"obytes64": float64(6905387),
+			"rbytes64": float64(151877304.4),
+		},
+		acc.GetTelegrafMetrics()[0].Fields(),
+	)
+}
+
+var sampleDladmOutput = helpers.DladmOutput{
+	Link: `rge0:phys:1500:up:
+stub0:etherstub:9000:unknown:
+media_net0:vnic:1500:up:rge0
+dns_net0:vnic:1500:up:rge0
+db_int0:vnic:9000:up:stub0
+backup0:vlan:1500:up:rge0`,
+	Phys:    "rge0:rge0:1000",
+	PhysMAC: `rge0:primary:0\:c\:29\:aa\:bb\:cc`,
+	Vnic: `media_net0:rge0:1000:2\:8\:20\:a\:b\:c:0:cube-media
+dns_net0:rge0:1000:2\:8\:20\:d\:e\:f:0:cube-dns
+db_int0:stub0:0:2\:8\:20\:4\:5\:6:20:cube-db`,
+	Vlan: "backup0:30:rge0",
+}
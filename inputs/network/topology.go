@@ -0,0 +1,65 @@
+package network
+
+import (
+	"time"
+
+	"github.com/snltd/illumos-telegraf-plugins/helpers"
+)
+
+const defaultTopologyRefresh = 5 * time.Minute
+
+// topology holds what dladm told us about the links on the system, which VNICs are in which
+// zones and what they all sit on. Finding that out means running dladm several times, which
+// is far too slow to do on every collection, let alone for every link. So we keep it until it is
+// older than the refresh interval, or until the link kstats change. A link we haven't seen means
+// a new VNIC, and a link whose kstat has been recreated has been moved or had its zone rebooted.
+type topology struct {
+	refresh   time.Duration
+	datalinks helpers.DatalinkMap
+	crtimes   map[string]int64
+	loaded    time.Time
+	build     func() helpers.DatalinkMap
+	now       func() time.Time
+}
+
+func newTopology(refresh time.Duration) *topology {
+	if refresh <= 0 {
+		refresh = defaultTopologyRefresh
+	}
+
+	return &topology{
+		refresh: refresh,
+		build:   makeDatalinkMap,
+		now:     time.Now,
+	}
+}
+
+// datalinksFor returns the datalinks, rebuilding them first if they are stale, or if the given
+// link kstats are not the ones they were built for.
+func (t *topology) datalinksFor(links []*helpers.KStat) helpers.DatalinkMap {
+	if t.datalinks == nil || t.now().Sub(t.loaded) >= t.refresh || t.changed(links) {
+		t.datalinks = t.build()
+		t.loaded = t.now()
+		t.crtimes = make(map[string]int64, len(links))
+
+		for _, link := range links {
+			t.crtimes[link.Name] = link.Crtime
+		}
+	}
+
+	return t.datalinks
+}
+
+func (t *topology) changed(links []*helpers.KStat) bool {
+	if len(links) != len(t.crtimes) {
+		return true
+	}
+
+	for _, link := range links {
+		if crtime, ok := t.crtimes[link.Name]; !ok || crtime != link.Crtime {
+			return true
+		}
+	}
+
+	return false
+}
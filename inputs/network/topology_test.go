@@ -0,0 +1,178 @@
+package network
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/influxdata/telegraf/testutil"
+	"github.com/snltd/illumos-telegraf-plugins/helpers"
+	"github.com/stretchr/testify/require"
+)
+
+func TestTopology(t *testing.T) {
+	t.Parallel()
+
+	var builds int
+
+	clock := time.Date(2024, 9, 27, 12, 0, 0, 0, time.UTC)
+	topo := newTopology(time.Minute)
+	topo.now = func() time.Time { return clock }
+	topo.build = func() helpers.DatalinkMap {
+		builds++
+
+		return helpers.ParseDatalinks(sampleDladmOutput)
+	}
+
+	links := []*helpers.KStat{
+		{Module: "link", Name: "rge0", Crtime: 100},
+		{Module: "link", Name: "dns_net0", Crtime: 200},
+	}
+
+	require.Equal(t, helpers.ZoneName("cube-dns"), topo.datalinksFor(links)["dns_net0"].Zone)
+	require.Equal(t, 1, builds)
+
+	clock = clock.Add(59 * time.Second)
+	topo.datalinksFor(links)
+	require.Equal(t, 1, builds, "nothing has changed")
+
+	clock = clock.Add(time.Second)
+	topo.datalinksFor(links)
+	require.Equal(t, 2, builds, "refresh interval has passed")
+
+	links = append(links, &helpers.KStat{Module: "link", Name: "media_net0", Crtime: 300})
+	topo.datalinksFor(links)
+	require.Equal(t, 3, builds, "a new link")
+
+	links[1] = &helpers.KStat{Module: "link", Name: "dns_net0", Crtime: 400}
+	topo.datalinksFor(links)
+	require.Equal(t, 4, builds, "a recreated link")
+
+	topo.datalinksFor(links[:2])
+	require.Equal(t, 5, builds, "a link has gone")
+
+	topo.datalinksFor(links[:2])
+	require.Equal(t, 5, builds)
+}
+
+func TestNewTopology(t *testing.T) {
+	t.Parallel()
+
+	require.Equal(t, defaultTopologyRefresh, newTopology(0).refresh)
+	require.Equal(t, time.Minute, newTopology(time.Minute).refresh)
+}
+
+func TestInit(t *testing.T) {
+	t.Parallel()
+
+	s := &IllumosNetwork{TopologyRefresh: "90s"}
+	require.NoError(t, s.Init())
+	require.Equal(t, 90*time.Second, s.topologyRefresh)
+
+	require.NoError(t, (&IllumosNetwork{}).Init())
+	require.Error(t, (&IllumosNetwork{TopologyRefresh: "often"}).Init())
+}
+
+// benchmarkSystem makes a kstat snapshot with the given number of VNICs, each a copy of the link
+// in testdata, and the dladm output which describes them, each in its own zone.
+func benchmarkSystem(b *testing.B, vnics int) (*helpers.Snapshot, helpers.DladmOutput) {
+	b.Helper()
+
+	stats := helpers.FromFixture("link--0--dns_net0.kstat")
+	snapshot := helpers.NewSnapshot()
+	linkLines := []string{"rge0:phys:1500:up:"}
+	vnicLines := make([]string, 0, vnics)
+
+	for i := range vnics {
+		name := fmt.Sprintf("z%d_net0", i)
+		ks := &helpers.KStat{Module: "link", Name: name, Class: "net", Type: helpers.NamedStat}
+
+		named := make([]*helpers.Named, len(stats))
+
+		for j, stat := range stats {
+			copied := *stat
+			copied.KStat = ks
+			named[j] = &copied
+		}
+
+		snapshot.KStats = append(snapshot.KStats, ks)
+		snapshot.Named["link:0:"+name] = named
+		linkLines = append(linkLines, name+":vnic:1500:up:rge0")
+		vnicLines = append(vnicLines, fmt.Sprintf(`%s:rge0:1000:2\:8\:20\:0\:0\:%x:0:zone%d`, name, i, i))
+	}
+
+	return snapshot, helpers.DladmOutput{
+		Link: strings.Join(linkLines, "\n"),
+		Phys: "rge0:rge0:1000",
+		Vnic: strings.Join(vnicLines, "\n"),
+	}
+}
+
+// BenchmarkGather shows how the cost of a collection grows with the number of links. Reading
+// the kstats is always per link, but dladm is only run when the topology changes, so the
+// dladm/op metric, how many times per collection it is run, should be zero whatever the link
+// count.
+func BenchmarkGather(b *testing.B) {
+	origDatalinks, origSource, origZone := makeDatalinkMap, newKStatSource, zoneName
+
+	b.Cleanup(func() {
+		makeDatalinkMap, newKStatSource, zoneName = origDatalinks, origSource, origZone
+	})
+
+	for _, vnics := range []int{10, 100, 1000} {
+		b.Run(fmt.Sprintf("links=%d", vnics), func(b *testing.B) {
+			snapshot, dladm := benchmarkSystem(b, vnics)
+			builds := 0
+
+			makeDatalinkMap = func() helpers.DatalinkMap {
+				builds++
+
+				return helpers.ParseDatalinks(dladm)
+			}
+
+			newKStatSource = func() (helpers.KStatSource, error) {
+				return helpers.NewSnapshotKStatSource(snapshot), nil
+			}
+
+			zoneName = "global"
+
+			s := &IllumosNetwork{Fields: []string{"obytes64", "rbytes64"}}
+			acc := testutil.Accumulator{}
+
+			require.NoError(b, s.Gather(&acc))
+
+			builds = 0
+
+			b.ReportAllocs()
+			b.ResetTimer()
+
+			for range b.N {
+				acc.ClearMetrics()
+				_ = s.Gather(&acc)
+			}
+
+			b.ReportMetric(float64(builds)/float64(b.N), "dladm/op")
+		})
+	}
+}
+
+// BenchmarkDatalinksFor is the per-collection cost of deciding whether the topology has to be
+// rebuilt.
+func BenchmarkDatalinksFor(b *testing.B) {
+	for _, vnics := range []int{10, 100, 1000} {
+		b.Run(fmt.Sprintf("links=%d", vnics), func(b *testing.B) {
+			snapshot, dladm := benchmarkSystem(b, vnics)
+			topo := newTopology(time.Hour)
+			topo.build = func() helpers.DatalinkMap { return helpers.ParseDatalinks(dladm) }
+			topo.datalinksFor(snapshot.KStats)
+
+			b.ReportAllocs()
+			b.ResetTimer()
+
+			for range b.N {
+				topo.datalinksFor(snapshot.KStats)
+			}
+		})
+	}
+}
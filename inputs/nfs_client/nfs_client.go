@@ -5,7 +5,6 @@ import (
 	"log"
 	"strings"
 
-	"github.com/illumos/go-kstat"
 	"github.com/influxdata/telegraf"
 	"github.com/influxdata/telegraf/plugins/inputs"
 	"github.com/snltd/illumos-telegraf-plugins/helpers"
@@ -17,6 +16,9 @@ var sampleConfig = `
   ## The kstat fields you wish to emit. 'kstat -p -m nfs -i 0 | grep rfsreqcnt' lists the
 	## possibilities
 	# fields = ["read", "write", "remove", "create", "getattr", "setattr"]
+	## Emit per-second rates rather than raw counters. Nothing is reported for a counter until it
+	## has been seen twice.
+	# rates = false
 `
 
 func (s *IllumosNfsClient) Description() string {
@@ -30,10 +32,14 @@ func (s *IllumosNfsClient) SampleConfig() string {
 type IllumosNfsClient struct {
 	Fields      []string
 	NfsVersions []string
+	Rates       bool
+	rates       *helpers.Rates
 }
 
+var newKStatSource = helpers.NewKStatSource
+
 func (s *IllumosNfsClient) Gather(acc telegraf.Accumulator) error {
-	token, err := kstat.Open()
+	token, err := newKStatSource()
 	if err != nil {
 		log.Print("cannot get kstat token")
 
@@ -42,6 +48,12 @@ func (s *IllumosNfsClient) Gather(acc telegraf.Accumulator) error {
 
 	defer token.Close()
 
+	if s.Rates && s.rates == nil {
+		s.rates = helpers.NewRates()
+	}
+
+	defer s.rates.Sweep()
+
 	stats := helpers.KStatsInModule(token, "nfs")
 
 	for _, stat := range stats {
@@ -56,27 +68,30 @@ func (s *IllumosNfsClient) Gather(acc telegraf.Accumulator) error {
 		}
 
 		stats, err := stat.AllNamed()
-
-		if err == nil {
-			acc.AddFields(
-				"nfs.client",
-				parseNamedStats(s, stats),
-				map[string]string{"nfsVersion": nfsVersion},
-			)
-		} else {
+		if err != nil {
 			log.Printf("cannot get named NFS client kstats for %s\n", stat.Name)
+
+			continue
+		}
+
+		fields := parseNamedStats(s, stats)
+
+		if len(fields) > 0 {
+			acc.AddFields("nfs.client", fields, map[string]string{"nfsVersion": nfsVersion})
 		}
 	}
 
 	return nil
 }
 
-func parseNamedStats(s *IllumosNfsClient, stats []*kstat.Named) map[string]interface{} {
+func parseNamedStats(s *IllumosNfsClient, stats []*helpers.Named) map[string]interface{} {
 	fields := make(map[string]interface{})
 
 	for _, stat := range stats {
 		if helpers.WeWant(stat.Name, s.Fields) {
-			fields[stat.Name] = helpers.NamedValue(stat).(float64)
+			if value, ok := s.rates.CounterValue(stat); ok {
+				fields[stat.Name] = value
+			}
 		}
 	}
 
@@ -18,6 +18,8 @@ func TestPlugin(t *testing.T) {
 		NfsVersions: []string{"v4"},
 	}
 
+	newKStatSource = helpers.FixtureKStatSource()
+
 	acc := testutil.Accumulator{}
 	require.NoError(t, s.Gather(&acc))
 	metric := acc.GetTelegrafMetrics()[0]
@@ -53,3 +55,29 @@ func TestParseNamedStats(t *testing.T) {
 		},
 	)
 }
+
+// Replays two ticks of the client kstats through newKStatSource, so can't run in parallel.
+func TestPluginRates(t *testing.T) { //nolint:paralleltest
+	s := &IllumosNfsClient{
+		Fields:      []string{"read", "write"},
+		NfsVersions: []string{"v4"},
+		Rates:       true,
+	}
+
+	helpers.UseFixtureReplay(t, &newKStatSource)
+
+	acc := testutil.Accumulator{}
+	require.NoError(t, s.Gather(&acc))
+	require.Empty(t, acc.GetTelegrafMetrics())
+
+	acc.ClearMetrics()
+	require.NoError(t, s.Gather(&acc))
+	require.Equal(
+		t,
+		map[string]interface{}{
+			"read":  float64(2301),
+			"write": float64(75),
+		},
+		acc.GetTelegrafMetrics()[0].Fields(),
+	)
+}
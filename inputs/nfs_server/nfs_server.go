@@ -5,7 +5,6 @@ import (
 	"log"
 	"strings"
 
-	"github.com/illumos/go-kstat"
 	"github.com/influxdata/telegraf"
 	"github.com/influxdata/telegraf/plugins/inputs"
 	"github.com/snltd/illumos-telegraf-plugins/helpers"
@@ -17,6 +16,9 @@ var sampleConfig = `
 	## The kstat fields you wish to emit. 'kstat -p -m nfs -i 0 | grep rfsproccnt' lists the
 	## possibilities
 	# fields = ["read", "write", "remove", "create", "getattr", "setattr"]
+	## Emit per-second rates rather than raw counters. Nothing is reported for a counter until it
+	## has been seen twice.
+	# rates = false
 `
 
 func (s *IllumosNfsServer) Description() string {
@@ -30,16 +32,26 @@ func (s *IllumosNfsServer) SampleConfig() string {
 type IllumosNfsServer struct {
 	Fields      []string
 	NfsVersions []string
+	Rates       bool
+	rates       *helpers.Rates
 }
 
+var newKStatSource = helpers.NewKStatSource
+
 func (s *IllumosNfsServer) Gather(acc telegraf.Accumulator) error {
-	token, err := kstat.Open()
+	token, err := newKStatSource()
 	if err != nil {
 		log.Print("cannot get kstat token")
 
 		return err
 	}
 
+	if s.Rates && s.rates == nil {
+		s.rates = helpers.NewRates()
+	}
+
+	defer s.rates.Sweep()
+
 	stats := helpers.KStatsInModule(token, "nfs")
 
 	for _, stat := range stats {
@@ -54,15 +66,16 @@ func (s *IllumosNfsServer) Gather(acc telegraf.Accumulator) error {
 		}
 
 		stats, err := stat.AllNamed()
-
-		if err == nil {
-			acc.AddFields(
-				"nfs.server",
-				parseNamedStats(s, stats),
-				map[string]string{"nfsVersion": nfsVersion},
-			)
-		} else {
+		if err != nil {
 			log.Printf("cannot get named NFS server kstats for %s\n", stat.Name)
+
+			continue
+		}
+
+		fields := parseNamedStats(s, stats)
+
+		if len(fields) > 0 {
+			acc.AddFields("nfs.server", fields, map[string]string{"nfsVersion": nfsVersion})
 		}
 	}
 
@@ -71,12 +84,14 @@ func (s *IllumosNfsServer) Gather(acc telegraf.Accumulator) error {
 	return nil
 }
 
-func parseNamedStats(s *IllumosNfsServer, stats []*kstat.Named) map[string]interface{} {
+func parseNamedStats(s *IllumosNfsServer, stats []*helpers.Named) map[string]interface{} {
 	fields := make(map[string]interface{})
 
 	for _, stat := range stats {
 		if helpers.WeWant(stat.Name, s.Fields) {
-			fields[stat.Name] = helpers.NamedValue(stat).(float64)
+			if value, ok := s.rates.CounterValue(stat); ok {
+				fields[stat.Name] = value
+			}
 		}
 	}
 
@@ -0,0 +1,68 @@
+package process
+
+import (
+	"fmt"
+	"os"
+	"path"
+	"regexp"
+)
+
+// Rewrite is a rule for rewriting the args or exec_path tag of a process, to keep down the
+// number of distinct values. Pattern is a regular expression and Replacement can refer to its
+// submatches, as in regexp.ReplaceAllString. If Tag is empty, the rule applies to both tags.
+type Rewrite struct {
+	Tag         string
+	Pattern     string
+	Replacement string
+	regex       *regexp.Regexp
+}
+
+// rewritableTags are the tags which Rewrites and TagLength apply to.
+var rewritableTags = map[string]bool{
+	"args":      true,
+	"exec_path": true,
+}
+
+// compileRewrites checks and compiles the rewrite rules. It is called by Init.
+func (s *IllumosProcess) compileRewrites() error {
+	for i, rule := range s.Rewrites {
+		if rule.Tag != "" && !rewritableTags[rule.Tag] {
+			return fmt.Errorf("cannot rewrite %s tag: only args and exec_path can be rewritten", rule.Tag)
+		}
+
+		regex, err := regexp.Compile(rule.Pattern)
+		if err != nil {
+			return fmt.Errorf("cannot compile rewrite pattern %q: %w", rule.Pattern, err)
+		}
+
+		s.Rewrites[i].regex = regex
+	}
+
+	return nil
+}
+
+// rewriteTag applies every rule for the tag, in order, then cuts the result down to TagLength
+// characters.
+func (s *IllumosProcess) rewriteTag(tag, value string) string {
+	for _, rule := range s.Rewrites {
+		if rule.regex == nil || (rule.Tag != "" && rule.Tag != tag) {
+			continue
+		}
+
+		value = rule.regex.ReplaceAllString(value, rule.Replacement)
+	}
+
+	if s.TagLength > 0 {
+		if runes := []rune(value); len(runes) > s.TagLength {
+			value = string(runes[:s.TagLength])
+		}
+	}
+
+	return value
+}
+
+// loadExecPath is a var so it can be injected by the tests. The a.out link can be missing, for
+// instance for system processes, or if the executable has been removed.
+var loadExecPath = func(pid int) (string, error) {
+	return os.Readlink(path.Join(procRootDir, fmt.Sprint(pid), "path", "a.out"))
+}
@@ -0,0 +1,81 @@
+package process
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestInit(t *testing.T) {
+	t.Parallel()
+
+	require.NoError(t, (&IllumosProcess{}).Init())
+
+	s := &IllumosProcess{Rewrites: []Rewrite{{Tag: "args", Pattern: "^-"}}}
+	require.NoError(t, s.Init())
+	require.NotNil(t, s.Rewrites[0].regex)
+
+	require.Error(t, (&IllumosProcess{Rewrites: []Rewrite{{Pattern: "(unclosed"}}}).Init())
+	require.Error(t, (&IllumosProcess{Rewrites: []Rewrite{{Tag: "name", Pattern: "x"}}}).Init())
+}
+
+func TestRewriteTag(t *testing.T) {
+	t.Parallel()
+
+	s := &IllumosProcess{
+		Rewrites: []Rewrite{
+			{Tag: "args", Pattern: `^java .*-jar (\S+).*$`, Replacement: "java -jar $1"},
+			{Tag: "args", Pattern: `^-`, Replacement: ""},
+			{Pattern: `/opt/ooce/`, Replacement: "/opt/"},
+		},
+		TagLength: 20,
+	}
+
+	require.NoError(t, s.Init())
+
+	require.Equal(
+		t,
+		"java -jar /srv/app.j",
+		s.rewriteTag("args", "java -Xmx4g -Dfoo=bar -jar /srv/app.jar --port 8080"),
+	)
+
+	require.Equal(t, "zsh", s.rewriteTag("args", "-zsh"))
+	require.Equal(t, "/opt/bin/python3", s.rewriteTag("exec_path", "/opt/ooce/bin/python3"))
+	require.Equal(t, "-zsh", s.rewriteTag("exec_path", "-zsh"))
+	require.Equal(t, "/opt/bin/python3", s.rewriteTag("args", "/opt/ooce/bin/python3"))
+	// Truncation counts characters, not bytes.
+	require.Equal(t, strings.Repeat("é", 20), s.rewriteTag("args", strings.Repeat("é", 25)))
+}
+
+func TestPluginArgs(t *testing.T) {
+	t.Parallel()
+
+	s := &IllumosProcess{
+		Values:    []string{"size"},
+		Tags:      []string{"args", "exec_path"},
+		TopK:      2,
+		Rewrites:  []Rewrite{{Tag: "args", Pattern: `^-`}},
+		TagLength: 10,
+	}
+
+	require.NoError(t, s.Init())
+
+	procRootDir = "testdata/proc"
+
+	loadProcPsinfo = func(pid int) (psinfo_t, error) {
+		return psinfoFromFixture(pid), nil
+	}
+
+	loadProcUsage = func(pid int) (prusage_t, error) {
+		return usageFromFixture(pid), nil
+	}
+
+	zsh, err := newProcObject(s, 26939)
+	require.NoError(t, err)
+	require.Equal(t, procObjectTags{"args": "zsh", "exec_path": "/usr/bin/z"}, zsh.Tags)
+
+	cron, err := newProcObject(s, 8055)
+	require.NoError(t, err)
+	require.Equal(t, procObjectTags{"args": "/usr/sbin/", "exec_path": "/usr/sbin/"}, cron.Tags)
+}
@@ -0,0 +1,168 @@
+package process
+
+import (
+	"fmt"
+	"log"
+	"os"
+	"path"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/snltd/illumos-telegraf-plugins/helpers"
+)
+
+// wantFDs is true if we need to count the open files of every process. Counting means reading a
+// directory for every process, so unlike most values, we only do it if nfd, or something which
+// needs it, was asked for by name.
+func (s *IllumosProcess) wantFDs() bool {
+	return slices.Contains(s.Values, "nfd") || s.wantFDLimits()
+}
+
+// wantFDLimits is true if fdlimit or fdratio were asked for by name. Getting limits is too
+// expensive to do just because Values is empty.
+func (s *IllumosProcess) wantFDLimits() bool {
+	return slices.Contains(s.Values, "fdlimit") || slices.Contains(s.Values, "fdratio")
+}
+
+// parseFDLimits turns the output of runPrctl into a map of pid to open file limit. The basic
+// value of process.max-file-descriptor is the soft RLIMIT_NOFILE, which is the one a process
+// runs into. If a process has no basic value, we use the privileged one.
+func parseFDLimits(raw string) map[int]int64 {
+	ret := make(map[int]int64)
+	privileged := make(map[int]int64)
+
+	for _, rctl := range helpers.ParsePrctl(raw) {
+		if rctl.EntityType != "process" || rctl.Name != "process.max-file-descriptor" {
+			continue
+		}
+
+		pid, err := strconv.Atoi(rctl.EntityID)
+		if err != nil {
+			continue
+		}
+
+		switch rctl.Privilege {
+		case "basic":
+			ret[pid] = int64(rctl.Value)
+		case "privileged":
+			privileged[pid] = int64(rctl.Value)
+		}
+	}
+
+	for pid, limit := range privileged {
+		if _, ok := ret[pid]; !ok {
+			ret[pid] = limit
+		}
+	}
+
+	return ret
+}
+
+// defaultFDLimitThreshold is the fewest open files a process can have for us to look up its
+// limit, if it isn't one of the top K by nfd. It is half of 256, the lowest soft limit any illumos
+// process gets unless someone lowers it.
+const defaultFDLimitThreshold = 128
+
+// fdLimitCandidates returns the pids of the processes which could be near their open file limit,
+// in order. Those are the topK processes with the most open files, and any others with at least
+// threshold of them.
+func fdLimitCandidates(processMap *procObjectMap, threshold, topK int) []int {
+	if threshold <= 0 {
+		threshold = defaultFDLimitThreshold
+	}
+
+	candidates := make(map[int]bool)
+
+	for _, pid := range topKPids(processMap, "nfd", topK) {
+		candidates[pid] = true
+	}
+
+	for pid, procObj := range *processMap {
+		if nfd, ok := procObj.Values["nfd"].(int64); ok && nfd >= int64(threshold) {
+			candidates[pid] = true
+		}
+	}
+
+	ret := make([]int, 0, len(candidates))
+
+	for pid := range candidates {
+		ret = append(ret, pid)
+	}
+
+	sort.Ints(ret)
+
+	return ret
+}
+
+// addFDLimits gives fdlimit and fdratio values to the processes which could be anywhere near
+// their limit. There is nowhere in /proc to read another process's limits, and prctl(1) has to
+// grab every process it looks at, so we don't do it for everything. A process outside the top K
+// with fewer files than FDLimitThreshold is missed if its limit has been set very low.
+func addFDLimits(s *IllumosProcess, processMap *procObjectMap) {
+	pids := fdLimitCandidates(processMap, s.FDLimitThreshold, s.TopK)
+
+	if len(pids) == 0 {
+		return
+	}
+
+	raw, err := runPrctl(pids)
+	if err != nil {
+		log.Printf("cannot get file descriptor limits: %v", err)
+	}
+
+	for pid, limit := range parseFDLimits(raw) {
+		procObj, ok := (*processMap)[pid]
+		if !ok {
+			continue
+		}
+
+		nfd, ok := procObj.Values["nfd"].(int64)
+		if !ok {
+			continue
+		}
+
+		if slices.Contains(s.Values, "fdlimit") {
+			procObj.Values["fdlimit"] = limit
+		}
+
+		if slices.Contains(s.Values, "fdratio") && limit > 0 {
+			procObj.Values["fdratio"] = float64(nfd) / float64(limit)
+		}
+	}
+}
+
+// Functions below here are vars so they can be injected by the tests.
+
+var countFDs = func(pid int) (int, error) {
+	dir, err := os.Open(path.Join(procRootDir, fmt.Sprint(pid), "fd"))
+	if err != nil {
+		return 0, err
+	}
+
+	defer dir.Close()
+
+	fds, err := dir.Readdirnames(-1)
+	if err != nil {
+		return 0, err
+	}
+
+	return len(fds), nil
+}
+
+// runPrctl gets the open file limits of the given processes, in prctl's parseable format.
+// prctl exits non-zero if any of the processes has gone, but still reports on the rest.
+var runPrctl = func(pids []int) (string, error) {
+	args := make([]string, len(pids))
+
+	for i, pid := range pids {
+		args[i] = fmt.Sprint(pid)
+	}
+
+	stdout, _, err := helpers.RunCmd(
+		"/usr/bin/prctl -P -n process.max-file-descriptor -i process " + strings.Join(args, " "),
+	)
+
+	return stdout, err
+}
@@ -0,0 +1,136 @@
+package process
+
+import (
+	"testing"
+	"time"
+
+	"github.com/influxdata/telegraf"
+	"github.com/influxdata/telegraf/testutil"
+	"github.com/stretchr/testify/require"
+)
+
+const sampleFDPrctl = `process: 26939: -zsh
+process.max-file-descriptor basic 256 - deny 26939
+process.max-file-descriptor privileged 65536 - deny -
+process.max-file-descriptor system 2147483647 max deny -
+process: 8055: /usr/sbin/cron
+process.max-file-descriptor privileged 1024 - deny -
+process.max-file-descriptor system 2147483647 max deny -`
+
+// Not parallel, because it replaces runPrctl and countFDs.
+//
+//nolint:paralleltest
+func TestPluginFDs(t *testing.T) {
+	s := &IllumosProcess{
+		Values:           []string{"nfd", "fdlimit", "fdratio"},
+		Tags:             []string{"name"},
+		TopK:             1,
+		FDLimitThreshold: 4,
+	}
+
+	procRootDir = "testdata/proc"
+
+	loadProcPsinfo = func(pid int) (psinfo_t, error) {
+		return psinfoFromFixture(pid), nil
+	}
+
+	loadProcUsage = func(pid int) (prusage_t, error) {
+		return usageFromFixture(pid), nil
+	}
+
+	openFiles := map[int]int{26939: 200, 8055: 4}
+
+	defer func(f func(int) (int, error)) { countFDs = f }(countFDs)
+
+	countFDs = func(pid int) (int, error) {
+		return openFiles[pid], nil
+	}
+
+	var asked []int
+
+	runPrctl = func(pids []int) (string, error) {
+		asked = pids
+
+		return sampleFDPrctl, nil
+	}
+
+	acc := testutil.Accumulator{}
+	require.NoError(t, s.Gather(&acc))
+	require.Equal(t, []int{8055, 26939}, asked)
+
+	testutil.RequireMetricsEqual(
+		t,
+		[]telegraf.Metric{
+			testutil.MustMetric(
+				"process",
+				map[string]string{"name": "zsh"},
+				map[string]interface{}{"nfd": int64(200)},
+				time.Now(),
+			),
+			testutil.MustMetric(
+				"process",
+				map[string]string{"name": "cron"},
+				map[string]interface{}{"fdlimit": int64(1024)},
+				time.Now(),
+			),
+			testutil.MustMetric(
+				"process",
+				map[string]string{"name": "zsh"},
+				map[string]interface{}{"fdratio": float64(200) / 256},
+				time.Now(),
+			),
+		},
+		acc.GetTelegrafMetrics(),
+		testutil.SortMetrics(),
+		testutil.IgnoreTime())
+}
+
+func TestParseFDLimits(t *testing.T) {
+	t.Parallel()
+
+	require.Equal(t, map[int]int64{26939: 256, 8055: 1024}, parseFDLimits(sampleFDPrctl))
+	require.Empty(t, parseFDLimits("zone: 5: cube-ws\nzone.max-swap usage 2083221504"))
+}
+
+func TestWantFDs(t *testing.T) {
+	t.Parallel()
+
+	require.False(t, (&IllumosProcess{}).wantFDs())
+	require.True(t, (&IllumosProcess{Values: []string{"nfd"}}).wantFDs())
+	require.False(t, (&IllumosProcess{}).wantFDLimits())
+	require.False(t, (&IllumosProcess{Values: []string{"rtime"}}).wantFDs())
+	require.True(t, (&IllumosProcess{Values: []string{"fdratio"}}).wantFDs())
+	require.True(t, (&IllumosProcess{Values: []string{"fdlimit"}}).wantFDLimits())
+}
+
+func TestFDLimitCandidates(t *testing.T) {
+	t.Parallel()
+
+	processMap := procObjectMap{
+		1:   procObject{Values: procObjectValues{"nfd": int64(defaultFDLimitThreshold)}},
+		2:   procObject{Values: procObjectValues{"nfd": int64(defaultFDLimitThreshold - 1)}},
+		3:   procObject{Values: procObjectValues{"rtime": int64(1000)}},
+		4:   procObject{Values: procObjectValues{"nfd": int64(60)}},
+		400: procObject{Values: procObjectValues{"nfd": int64(60000)}},
+	}
+
+	require.Equal(t, []int{1, 400}, fdLimitCandidates(&processMap, 0, 0))
+	require.Equal(t, []int{1, 2, 400}, fdLimitCandidates(&processMap, 0, 3))
+	require.Equal(t, []int{1, 2, 4, 400}, fdLimitCandidates(&processMap, 50, 1))
+	require.Equal(t, []int{400}, fdLimitCandidates(&processMap, 1000, 1))
+	require.Empty(t, fdLimitCandidates(&procObjectMap{}, 0, 10))
+}
+
+// Not parallel, because it sets procRootDir.
+//
+//nolint:paralleltest
+func TestCountFDs(t *testing.T) {
+	procRootDir = "testdata/proc"
+
+	nfd, err := countFDs(26939)
+	require.NoError(t, err)
+	require.Equal(t, 10, nfd)
+
+	_, err = countFDs(1)
+	require.Error(t, err)
+}
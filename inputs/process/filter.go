@@ -0,0 +1,141 @@
+package process
+
+import (
+	"bytes"
+	"fmt"
+
+	"github.com/snltd/illumos-telegraf-plugins/helpers"
+)
+
+// procKeys are the raw names and IDs which summaries and filters work on, whatever tags the user
+// asked for. Zone and service names are filled in later, by expandKeys.
+func procKeys(psinfo psinfo_t) procObjectTags {
+	return procObjectTags{
+		"name":     string(bytes.TrimRight(psinfo.Pr_fname[:], "\x00")),
+		"args":     string(bytes.TrimRight(psinfo.Pr_psargs[:], "\x00")),
+		"zoneid":   fmt.Sprint(psinfo.Pr_zoneid),
+		"projid":   fmt.Sprint(psinfo.Pr_projid),
+		"uid":      fmt.Sprint(psinfo.Pr_uid),
+		"taskid":   fmt.Sprint(psinfo.Pr_taskid),
+		"contract": fmt.Sprint(psinfo.Pr_contract),
+		"ppid":     fmt.Sprint(psinfo.Pr_ppid),
+		"state":    procState(psinfo),
+	}
+}
+
+func expandKeys(processMap *procObjectMap, zoneMap helpers.ZoneMap, contractMap contractMap) {
+	for _, procObj := range *processMap {
+		if procObj.Keys == nil {
+			continue
+		}
+
+		if zone, ok := zoneName(zoneMap, procObj.Keys["zoneid"]); ok {
+			procObj.Keys["zone"] = zone
+		}
+
+		if svc, ok := serviceName(contractMap, procObj.Keys["contract"]); ok {
+			procObj.Keys["service"] = svc
+		}
+	}
+}
+
+// procFilter is an include and an exclude list for one thing about a process, which can be seen
+// through any of the given keys.
+type procFilter struct {
+	keys    []string
+	include *helpers.Matcher
+	exclude *helpers.Matcher
+}
+
+// newProcFilter returns nil if there is nothing to filter on.
+func newProcFilter(keys, include, exclude []string) (*procFilter, error) {
+	if len(include) == 0 && len(exclude) == 0 {
+		return nil, nil
+	}
+
+	includeMatcher, err := helpers.NewMatcher(include)
+	if err != nil {
+		return nil, err
+	}
+
+	excludeMatcher, err := helpers.NewMatcher(exclude)
+	if err != nil {
+		return nil, err
+	}
+
+	return &procFilter{keys: keys, include: includeMatcher, exclude: excludeMatcher}, nil
+}
+
+// allows is true if a process with the given keys matches the include list, if there is one, and
+// does not match the exclude list. A process without any of the keys cannot be included.
+func (f *procFilter) allows(procKeys procObjectTags) bool {
+	values := make([]string, 0, len(f.keys))
+
+	for _, key := range f.keys {
+		if value, ok := procKeys[key]; ok {
+			values = append(values, value)
+		}
+	}
+
+	if f.include != nil && !f.include.Match(values...) {
+		return false
+	}
+
+	return !f.exclude.Match(values...)
+}
+
+// compileFilters turns the Include and Exclude lists into filters. It is called by Init.
+func (s *IllumosProcess) compileFilters() error {
+	lists := []struct {
+		keys             []string
+		include, exclude []string
+	}{
+		{[]string{"name", "args"}, s.IncludeNames, s.ExcludeNames},
+		{[]string{"zone", "zoneid"}, s.IncludeZones, s.ExcludeZones},
+		{[]string{"uid", "user"}, s.IncludeUsers, s.ExcludeUsers},
+		{[]string{"projid", "project"}, s.IncludeProjects, s.ExcludeProjects},
+		{[]string{"service"}, s.IncludeServices, s.ExcludeServices},
+	}
+
+	s.filters = nil
+
+	for _, list := range lists {
+		procFilter, err := newProcFilter(list.keys, list.include, list.exclude)
+		if err != nil {
+			return err
+		}
+
+		if procFilter != nil {
+			s.filters = append(s.filters, procFilter)
+		}
+	}
+
+	return nil
+}
+
+func (s *IllumosProcess) filterOn(keys ...string) bool {
+	for _, f := range s.filters {
+		for _, key := range keys {
+			for _, filterKey := range f.keys {
+				if key == filterKey {
+					return true
+				}
+			}
+		}
+	}
+
+	return false
+}
+
+// filterProcs removes from the map every process which any filter does not allow.
+func filterProcs(s *IllumosProcess, processMap *procObjectMap) {
+	for pid, procObj := range *processMap {
+		for _, f := range s.filters {
+			if !f.allows(procObj.Keys) {
+				delete(*processMap, pid)
+
+				break
+			}
+		}
+	}
+}
@@ -0,0 +1,157 @@
+package process
+
+import (
+	"testing"
+	"time"
+
+	"github.com/influxdata/telegraf"
+	"github.com/influxdata/telegraf/testutil"
+	"github.com/snltd/illumos-telegraf-plugins/helpers"
+	"github.com/stretchr/testify/require"
+)
+
+func TestPluginFilters(t *testing.T) {
+	t.Parallel()
+
+	procRootDir = "testdata/proc"
+
+	loadProcPsinfo = func(pid int) (psinfo_t, error) {
+		return psinfoFromFixture(pid), nil
+	}
+
+	loadProcUsage = func(pid int) (prusage_t, error) {
+		return usageFromFixture(pid), nil
+	}
+
+	collectContractInfo = func() (string, error) {
+		return ctidMapTxt, nil
+	}
+
+	newZoneMap = func() helpers.ZoneMap {
+		return helpers.NewZoneMapFromText(zoneMapTxt)
+	}
+
+	tests := []struct {
+		name   string
+		plugin *IllumosProcess
+		want   []string
+	}{
+		{"no filters", &IllumosProcess{}, []string{"cron", "zsh"}},
+		{"exclude name", &IllumosProcess{ExcludeNames: []string{"cro*"}}, []string{"zsh"}},
+		{"include args regex", &IllumosProcess{IncludeNames: []string{"/^-/"}}, []string{"zsh"}},
+		{"include zone", &IllumosProcess{IncludeZones: []string{"serv-*"}}, []string{"cron", "zsh"}},
+		{"exclude zone id", &IllumosProcess{ExcludeZones: []string{"6"}}, []string{}},
+		{"exclude user", &IllumosProcess{ExcludeUsers: []string{"0"}}, []string{"zsh"}},
+		{"include project", &IllumosProcess{IncludeProjects: []string{"3"}}, []string{"zsh"}},
+		{"include service", &IllumosProcess{IncludeServices: []string{"svc:/system/*"}}, []string{"cron"}},
+		{"exclude service", &IllumosProcess{ExcludeServices: []string{"svc:/system/*"}}, []string{"zsh"}},
+		{
+			"everything",
+			&IllumosProcess{
+				IncludeZones: []string{"serv-build"},
+				IncludeUsers: []string{"0", "264"},
+				ExcludeNames: []string{"zsh"},
+			},
+			[]string{"cron"},
+		},
+	}
+
+	for _, tt := range tests {
+		s := tt.plugin
+		s.Values = []string{"size"}
+		s.Tags = []string{"name"}
+		s.TopK = 5
+
+		require.NoError(t, s.Init(), tt.name)
+
+		acc := testutil.Accumulator{}
+		require.NoError(t, s.Gather(&acc), tt.name)
+
+		names := []string{}
+
+		for _, metric := range acc.GetTelegrafMetrics() {
+			name, _ := metric.GetTag("name")
+			names = append(names, name)
+		}
+
+		require.ElementsMatch(t, tt.want, names, tt.name)
+	}
+}
+
+// Filtered processes don't count towards summaries.
+func TestPluginFiltersSummaries(t *testing.T) {
+	t.Parallel()
+
+	s := &IllumosProcess{
+		Summaries:    []string{"user"},
+		ExcludeNames: []string{"zsh"},
+	}
+
+	require.NoError(t, s.Init())
+
+	procRootDir = "testdata/proc"
+
+	loadProcPsinfo = func(pid int) (psinfo_t, error) {
+		return psinfoFromFixture(pid), nil
+	}
+
+	loadProcUsage = func(pid int) (prusage_t, error) {
+		return usageFromFixture(pid), nil
+	}
+
+	acc := testutil.Accumulator{}
+	require.NoError(t, s.Gather(&acc))
+
+	testutil.RequireMetricsEqual(
+		t,
+		[]telegraf.Metric{
+			testutil.MustMetric(
+				"process.summary",
+				map[string]string{"groupBy": "user", "uid": "0"},
+				map[string]interface{}{
+					"rtime":  int64(55522972213516),
+					"utime":  int64(722329),
+					"stime":  int64(1697875),
+					"rssize": int64(1146880),
+					"size":   int64(1978368),
+					"count":  int64(1),
+				},
+				time.Now(),
+			),
+		},
+		acc.GetTelegrafMetrics(),
+		testutil.IgnoreTime())
+}
+
+func TestProcFilterAllows(t *testing.T) {
+	t.Parallel()
+
+	f, err := newProcFilter([]string{"service"}, []string{"svc:/system/*"}, []string{"*:rsyslog"})
+	require.NoError(t, err)
+
+	require.True(t, f.allows(procObjectTags{"service": "svc:/system/cron:default"}))
+	require.False(t, f.allows(procObjectTags{"service": "svc:/system/system-log:rsyslog"}))
+	require.False(t, f.allows(procObjectTags{"service": "svc:/network/ssh:default"}))
+	require.False(t, f.allows(procObjectTags{}))
+
+	f, err = newProcFilter([]string{"service"}, nil, []string{"svc:/network/*"})
+	require.NoError(t, err)
+	require.True(t, f.allows(procObjectTags{}))
+	require.False(t, f.allows(procObjectTags{"service": "svc:/network/ssh:default"}))
+
+	f, err = newProcFilter([]string{"service"}, nil, nil)
+	require.NoError(t, err)
+	require.Nil(t, f)
+}
+
+func TestInitFilters(t *testing.T) {
+	t.Parallel()
+
+	s := &IllumosProcess{ExcludeNames: []string{"sched"}, IncludeServices: []string{"svc:*"}}
+	require.NoError(t, s.Init())
+	require.Len(t, s.filters, 2)
+	require.True(t, s.filterOn("service"))
+	require.False(t, s.filterOn("zone"))
+
+	require.Error(t, (&IllumosProcess{IncludeZones: []string{"/[/"}}).Init())
+}
@@ -0,0 +1,253 @@
+package process
+
+import (
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/snltd/illumos-telegraf-plugins/helpers"
+	"golang.org/x/sys/unix"
+)
+
+const defaultIDCacheTTL = 10 * time.Minute
+
+// idTags maps each numeric ID tag to the tag which gets its name, and the file in the zone's
+// /etc which the name comes from.
+var idTags = []struct {
+	tag, nameTag, file string
+}{
+	{"uid", "user", "passwd"},
+	{"euid", "euser", "passwd"},
+	{"gid", "group", "group"},
+	{"egid", "egroup", "group"},
+	{"projid", "project", "project"},
+}
+
+// idFields says which colon-separated field of each file holds the ID. The name is always first.
+var idFields = map[string]int{
+	"passwd":  2,
+	"group":   2,
+	"project": 1,
+}
+
+// idNames maps IDs to names for one zone. It is keyed by file, then by ID.
+type idNames map[string]map[string]string
+
+type idNamesEntry struct {
+	names  idNames
+	loaded time.Time
+}
+
+// idCache holds the idNames of every zone we have seen, keyed by the zone's root. Zones can each
+// have their own users and projects, so we can't use the global zone's files for everything.
+// Entries are reloaded once they are older than the ttl, which is how we pick up new users.
+type idCache struct {
+	mtx   sync.Mutex
+	ttl   time.Duration
+	zones map[string]idNamesEntry
+	now   func() time.Time
+}
+
+func newIDCache(ttl time.Duration) *idCache {
+	if ttl <= 0 {
+		ttl = defaultIDCacheTTL
+	}
+
+	return &idCache{
+		ttl:   ttl,
+		zones: make(map[string]idNamesEntry),
+		now:   time.Now,
+	}
+}
+
+// names returns the idNames for the zone with the given root, reading them if we don't have
+// them, or if they have gone stale.
+func (c *idCache) names(root string) idNames {
+	c.mtx.Lock()
+	defer c.mtx.Unlock()
+
+	entry, ok := c.zones[root]
+
+	if !ok || c.now().Sub(entry.loaded) >= c.ttl {
+		entry = idNamesEntry{names: loadIDNames(root), loaded: c.now()}
+		c.zones[root] = entry
+	}
+
+	return entry.names
+}
+
+// maxIDFileSize is far bigger than any real passwd, group or project file.
+const maxIDFileSize = 16 << 20
+
+// readZoneFile reads a file under the root of a zone, without trusting anything in the zone. Root
+// in a non-global zone can make etc/passwd a symlink to a file in the global zone, a device which
+// never ends, or a FIFO which never gives us anything. So we open each part of the path relative
+// to the one before without following symlinks, never block on the open, and only read a regular
+// file, and not too much of it.
+func readZoneFile(root string, components ...string) ([]byte, error) {
+	fd, err := unix.Open(root, unix.O_RDONLY|unix.O_CLOEXEC, 0)
+	if err != nil {
+		return nil, fmt.Errorf("cannot open %s: %w", root, err)
+	}
+
+	for _, component := range components {
+		next, err := unix.Openat(fd, component, unix.O_RDONLY|unix.O_NOFOLLOW|unix.O_NONBLOCK|unix.O_CLOEXEC, 0)
+		unix.Close(fd)
+
+		if err != nil {
+			return nil, fmt.Errorf("cannot open %s in %s: %w", component, root, err)
+		}
+
+		fd = next
+	}
+
+	file := path.Join(append([]string{root}, components...)...)
+	fh := os.NewFile(uintptr(fd), file)
+
+	defer fh.Close()
+
+	info, err := fh.Stat()
+	if err != nil {
+		return nil, err
+	}
+
+	if !info.Mode().IsRegular() {
+		return nil, fmt.Errorf("%s is not a regular file", file)
+	}
+
+	raw, err := io.ReadAll(io.LimitReader(fh, maxIDFileSize+1))
+	if err != nil {
+		return nil, err
+	}
+
+	if len(raw) > maxIDFileSize {
+		return nil, fmt.Errorf("%s is bigger than %d bytes", file, maxIDFileSize)
+	}
+
+	return raw, nil
+}
+
+func loadIDNames(root string) idNames {
+	ret := make(idNames, len(idFields))
+
+	for file, field := range idFields {
+		raw, err := readZoneFile(root, "etc", file)
+		if err != nil {
+			log.Printf("cannot read ID names: %v", err)
+			ret[file] = map[string]string{}
+			continue
+		}
+
+		ret[file] = parseIDFile(string(raw), field)
+	}
+
+	return ret
+}
+
+// parseIDFile turns passwd, group or project into a map of ID to name. Comments and NIS
+// entries are skipped. If an ID appears more than once, the first name wins, as it does for
+// getpwuid(3C) and friends.
+func parseIDFile(raw string, field int) map[string]string {
+	ret := make(map[string]string)
+
+	for _, line := range strings.Split(raw, "\n") {
+		if line == "" || strings.HasPrefix(line, "#") || strings.HasPrefix(line, "+") ||
+			strings.HasPrefix(line, "-") {
+			continue
+		}
+
+		fields := strings.Split(line, ":")
+
+		if len(fields) <= field || fields[0] == "" {
+			continue
+		}
+
+		if _, err := strconv.Atoi(fields[field]); err != nil {
+			continue
+		}
+
+		if _, ok := ret[fields[field]]; !ok {
+			ret[fields[field]] = fields[0]
+		}
+	}
+
+	return ret
+}
+
+// zoneRoot is the path, from the global zone, to the root of the zone with the given ID.
+func zoneRoot(zoneMap helpers.ZoneMap, zid string) (string, bool) {
+	if zid == "0" {
+		return "/", true
+	}
+
+	zoneID, err := strconv.Atoi(zid)
+	if err != nil {
+		return "", false
+	}
+
+	zone, err := zoneMap.ZoneByID(zoneID)
+	if err != nil || zone.Path == "" {
+		return "", false
+	}
+
+	return path.Join(zone.Path, "root"), true
+}
+
+// procIDNames returns the names for the zone the process is in. Keys always has the zone ID.
+func procIDNames(procObj procObject, zoneMap helpers.ZoneMap, cache *idCache) (idNames, bool) {
+	root, ok := zoneRoot(zoneMap, procObj.Keys["zoneid"])
+	if !ok {
+		return nil, false
+	}
+
+	return cache.names(root), true
+}
+
+// expandIDTags adds a name tag next to each numeric ID tag which we can resolve.
+func expandIDTags(processMap *procObjectMap, zoneMap helpers.ZoneMap, cache *idCache) {
+	for _, procObj := range *processMap {
+		names, ok := procIDNames(procObj, zoneMap, cache)
+		if !ok {
+			continue
+		}
+
+		for _, idTag := range idTags {
+			id, ok := procObj.Tags[idTag.tag]
+			if !ok {
+				continue
+			}
+
+			if name, ok := names[idTag.file][id]; ok {
+				procObj.Tags[idTag.nameTag] = name
+			}
+		}
+	}
+}
+
+// expandIDKeys adds user and project names to the keys, for filters and summaries.
+func expandIDKeys(processMap *procObjectMap, zoneMap helpers.ZoneMap, cache *idCache) {
+	for _, procObj := range *processMap {
+		if procObj.Keys == nil {
+			continue
+		}
+
+		names, ok := procIDNames(procObj, zoneMap, cache)
+		if !ok {
+			continue
+		}
+
+		if name, ok := names["passwd"][procObj.Keys["uid"]]; ok {
+			procObj.Keys["user"] = name
+		}
+
+		if name, ok := names["project"][procObj.Keys["projid"]]; ok {
+			procObj.Keys["project"] = name
+		}
+	}
+}
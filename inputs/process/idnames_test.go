@@ -0,0 +1,269 @@
+package process
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/influxdata/telegraf"
+	"github.com/influxdata/telegraf/testutil"
+	"github.com/snltd/illumos-telegraf-plugins/helpers"
+	"github.com/stretchr/testify/require"
+	"golang.org/x/sys/unix"
+)
+
+func TestPluginIDTags(t *testing.T) {
+	t.Parallel()
+
+	s := &IllumosProcess{
+		Values:       []string{"size"},
+		Tags:         []string{"name", "uid", "euid", "gid", "egid", "projid"},
+		TopK:         2,
+		ExpandIDTags: true,
+	}
+
+	require.NoError(t, s.Init())
+
+	procRootDir = "testdata/proc"
+
+	loadProcPsinfo = func(pid int) (psinfo_t, error) {
+		return psinfoFromFixture(pid), nil
+	}
+
+	loadProcUsage = func(pid int) (prusage_t, error) {
+		return usageFromFixture(pid), nil
+	}
+
+	newZoneMap = func() helpers.ZoneMap {
+		return helpers.NewZoneMapFromText(zoneMapTxt)
+	}
+
+	acc := testutil.Accumulator{}
+	require.NoError(t, s.Gather(&acc))
+
+	testutil.RequireMetricsEqual(
+		t,
+		[]telegraf.Metric{
+			testutil.MustMetric(
+				"process",
+				map[string]string{
+					"name":    "cron",
+					"uid":     "0",
+					"user":    "root",
+					"euid":    "0",
+					"euser":   "root",
+					"gid":     "0",
+					"group":   "root",
+					"egid":    "0",
+					"egroup":  "root",
+					"projid":  "0",
+					"project": "system",
+				},
+				map[string]interface{}{"size": int64(1978368)},
+				time.Now(),
+			),
+			testutil.MustMetric(
+				"process",
+				map[string]string{
+					"name":    "zsh",
+					"uid":     "264",
+					"user":    "rob",
+					"euid":    "264",
+					"euser":   "rob",
+					"gid":     "1",
+					"group":   "other",
+					"egid":    "1",
+					"egroup":  "other",
+					"projid":  "3",
+					"project": "default",
+				},
+				map[string]interface{}{"size": int64(9805824)},
+				time.Now(),
+			),
+		},
+		acc.GetTelegrafMetrics(),
+		testutil.SortMetrics(),
+		testutil.IgnoreTime())
+}
+
+// User and project filters can use names, even without ExpandIDTags.
+func TestPluginIDFilters(t *testing.T) {
+	t.Parallel()
+
+	procRootDir = "testdata/proc"
+
+	loadProcPsinfo = func(pid int) (psinfo_t, error) {
+		return psinfoFromFixture(pid), nil
+	}
+
+	loadProcUsage = func(pid int) (prusage_t, error) {
+		return usageFromFixture(pid), nil
+	}
+
+	newZoneMap = func() helpers.ZoneMap {
+		return helpers.NewZoneMapFromText(zoneMapTxt)
+	}
+
+	for _, s := range []*IllumosProcess{
+		{IncludeUsers: []string{"rob"}},
+		{ExcludeProjects: []string{"sys*"}},
+	} {
+		s.Values = []string{"size"}
+		s.Tags = []string{"name"}
+		s.TopK = 2
+
+		require.NoError(t, s.Init())
+
+		acc := testutil.Accumulator{}
+		require.NoError(t, s.Gather(&acc))
+		require.Len(t, acc.GetTelegrafMetrics(), 1)
+
+		name, _ := acc.GetTelegrafMetrics()[0].GetTag("name")
+		require.Equal(t, "zsh", name)
+	}
+}
+
+func TestParseIDFile(t *testing.T) {
+	t.Parallel()
+
+	raw, err := os.ReadFile("testdata/zones/serv-build/root/etc/passwd")
+	require.NoError(t, err)
+
+	require.Equal(
+		t,
+		map[string]string{
+			"0":     "root",
+			"1":     "daemon",
+			"2":     "bin",
+			"3":     "sys",
+			"60001": "nobody",
+			"264":   "rob",
+		},
+		parseIDFile(string(raw), idFields["passwd"]),
+	)
+
+	raw, err = os.ReadFile("testdata/zones/serv-build/root/etc/project")
+	require.NoError(t, err)
+
+	require.Equal(
+		t,
+		map[string]string{
+			"0":  "system",
+			"1":  "user.root",
+			"2":  "noproject",
+			"3":  "default",
+			"10": "group.staff",
+		},
+		parseIDFile(string(raw), idFields["project"]),
+	)
+
+	require.Equal(
+		t,
+		map[string]string{"0": "root"},
+		parseIDFile("root::0:\ntoor::0:\nbroken\n:x:1:\nwords::abc:", idFields["group"]),
+	)
+}
+
+func TestZoneRoot(t *testing.T) {
+	t.Parallel()
+
+	zoneMap := helpers.NewZoneMapFromText(
+		"0:global:running:/::ipkg:shared:0\n" +
+			"6:serv-build:running:/zones/serv-build:bb60d6b1:lipkg:excl:0",
+	)
+
+	root, ok := zoneRoot(zoneMap, "0")
+	require.True(t, ok)
+	require.Equal(t, "/", root)
+
+	root, ok = zoneRoot(zoneMap, "6")
+	require.True(t, ok)
+	require.Equal(t, "/zones/serv-build/root", root)
+
+	_, ok = zoneRoot(zoneMap, "9")
+	require.False(t, ok)
+
+	_, ok = zoneRoot(zoneMap, "junk")
+	require.False(t, ok)
+}
+
+func TestIDCache(t *testing.T) {
+	t.Parallel()
+
+	root := t.TempDir()
+	passwd := filepath.Join(root, "etc", "passwd")
+
+	require.NoError(t, os.MkdirAll(filepath.Dir(passwd), 0o755))
+	require.NoError(t, os.WriteFile(passwd, []byte("root:x:0:0::/root:/bin/sh\n"), 0o600))
+
+	now := time.Date(2024, 4, 27, 12, 0, 0, 0, time.UTC)
+	cache := newIDCache(time.Minute)
+	cache.now = func() time.Time { return now }
+
+	names := cache.names(root)
+	require.Equal(t, map[string]string{"0": "root"}, names["passwd"])
+	require.Empty(t, names["group"])
+	require.Empty(t, names["project"])
+
+	require.NoError(t, os.WriteFile(passwd, []byte("root:x:0:0::/root:/bin/sh\nrob:x:264:1:::\n"), 0o600))
+
+	now = now.Add(30 * time.Second)
+	require.Equal(t, map[string]string{"0": "root"}, cache.names(root)["passwd"])
+
+	now = now.Add(30 * time.Second)
+	require.Equal(t, map[string]string{"0": "root", "264": "rob"}, cache.names(root)["passwd"])
+
+	require.Equal(t, defaultIDCacheTTL, newIDCache(0).ttl)
+}
+
+func TestInitIDCacheTTL(t *testing.T) {
+	t.Parallel()
+
+	s := &IllumosProcess{IDCacheTTL: "90s"}
+	require.NoError(t, s.Init())
+	require.Equal(t, 90*time.Second, s.idCacheTTL)
+
+	require.Error(t, (&IllumosProcess{IDCacheTTL: "often"}).Init())
+}
+
+func TestReadZoneFile(t *testing.T) {
+	t.Parallel()
+
+	raw, err := readZoneFile("testdata/zones/serv-build/root", "etc", "passwd")
+	require.NoError(t, err)
+	require.Contains(t, string(raw), "root:x:0:0")
+
+	// Things root in a zone could do to its /etc, to trip up the global zone.
+	outside := t.TempDir()
+	secret := filepath.Join(outside, "shadow")
+	require.NoError(t, os.WriteFile(secret, []byte("root:secret:0:0::/root:/bin/sh\n"), 0o600))
+
+	root := t.TempDir()
+	etc := filepath.Join(root, "etc")
+	require.NoError(t, os.Mkdir(etc, 0o755))
+	require.NoError(t, os.Symlink(secret, filepath.Join(etc, "passwd")))
+	require.NoError(t, unix.Mkfifo(filepath.Join(etc, "group"), 0o600))
+	require.NoError(t, os.Mkdir(filepath.Join(etc, "project"), 0o755))
+
+	_, err = readZoneFile(root, "etc", "passwd")
+	require.Error(t, err, "symlinked file")
+
+	_, err = readZoneFile(root, "etc", "group")
+	require.Error(t, err, "FIFO")
+
+	_, err = readZoneFile(root, "etc", "project")
+	require.Error(t, err, "directory")
+
+	linkedRoot := t.TempDir()
+	require.NoError(t, os.Symlink(outside, filepath.Join(linkedRoot, "etc")))
+
+	_, err = readZoneFile(linkedRoot, "etc", "shadow")
+	require.Error(t, err, "symlinked directory")
+
+	require.Equal(
+		t,
+		idNames{"passwd": {}, "group": {}, "project": {}},
+		loadIDNames(root),
+	)
+}
@@ -0,0 +1,158 @@
+package process
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"path"
+	"sort"
+	"strconv"
+
+	"github.com/influxdata/telegraf"
+	"github.com/snltd/illumos-telegraf-plugins/helpers"
+)
+
+// syncObjects names the synchronization object a sleeping LWP is blocked on, from
+// /usr/include/sys/sobject.h.
+var syncObjects = map[byte]string{
+	0: "none",
+	1: "mutex",
+	2: "rwlock",
+	3: "cv",
+	4: "sema",
+	5: "user",
+	6: "user_pi",
+	7: "shuttle",
+}
+
+// lwpKStat is procKStat for a single LWP.
+func lwpKStat(pid int, lwpsinfo lwpsinfo_t) *helpers.KStat {
+	return &helpers.KStat{
+		Module:   "lwp",
+		Instance: pid,
+		Name:     fmt.Sprint(lwpsinfo.Pr_lwpid),
+		Crtime:   toNs(lwpsinfo.Pr_start),
+	}
+}
+
+// threadName is the name the program gave the thread, or, failing that, what the system calls
+// it.
+func threadName(lwpsinfo lwpsinfo_t) string {
+	name := string(bytes.TrimRight(lwpsinfo.Pr_name[:], "\x00"))
+
+	if name == "" {
+		name = string(bytes.TrimRight(lwpsinfo.Pr_oldname[:], "\x00"))
+	}
+
+	return name
+}
+
+// newLwpFields turns an LWP's lwpsinfo and lwpusage into fields and tags. The process's own
+// tags are copied onto every LWP.
+func newLwpFields(
+	s *IllumosProcess,
+	pid int,
+	lwpsinfo lwpsinfo_t,
+	lwpusage prusage_t,
+	procTags procObjectTags,
+) (map[string]interface{}, map[string]string) {
+	fields := make(map[string]interface{})
+	ks := lwpKStat(pid, lwpsinfo)
+	snaptime := toNs(lwpusage.Pr_tstamp)
+
+	for _, counter := range usageCounters {
+		if helpers.WeWant(counter.name, s.Values) {
+			s.addCounter(fields, ks, counter.name, counter.value(&lwpusage), snaptime)
+		}
+	}
+
+	s.addMicrostatePcts(fields, ks, &lwpusage, snaptime)
+
+	if helpers.WeWant("pctcpu", s.Values) {
+		fields["pctcpu"] = int64(lwpsinfo.Pr_pctcpu)
+	}
+
+	fields["pri"] = int64(lwpsinfo.Pr_pri)
+	fields["syscall"] = int64(lwpsinfo.Pr_syscall)
+
+	// LWPs waiting on the same address are all held up by the same thing. It is a kernel address
+	// which changes whenever the LWP sleeps on something else, so it would make a terrible tag.
+	if lwpsinfo.Pr_wchan != 0 {
+		fields["wchan"] = fmt.Sprintf("0x%x", lwpsinfo.Pr_wchan)
+	}
+
+	tags := make(map[string]string, len(procTags)+4)
+
+	for k, v := range procTags {
+		tags[k] = v
+	}
+
+	tags["lwpid"] = fmt.Sprint(lwpsinfo.Pr_lwpid)
+	tags["state"] = string(lwpsinfo.Pr_sname)
+
+	if name := threadName(lwpsinfo); name != "" {
+		tags["thread"] = name
+	}
+
+	if obj, ok := syncObjects[lwpsinfo.Pr_stype]; ok {
+		tags["syncObject"] = obj
+	}
+
+	return fields, tags
+}
+
+// gatherLwpStats sends a process.lwp metric for every thread of the given process.
+func gatherLwpStats(s *IllumosProcess, acc telegraf.Accumulator, pid int, procObj procObject) {
+	lwpids, err := loadLwpIDs(pid)
+	if err != nil {
+		return
+	}
+
+	for _, lwpid := range lwpids {
+		lwpsinfo, err := loadLwpPsinfo(pid, lwpid)
+		if err != nil {
+			continue
+		}
+
+		lwpusage, err := loadLwpUsage(pid, lwpid)
+		if err != nil {
+			continue
+		}
+
+		fields, tags := newLwpFields(s, pid, lwpsinfo, lwpusage, procObj.Tags)
+		acc.AddFields("process.lwp", fields, tags)
+	}
+}
+
+// Functions below here are vars so they can be injected by the tests, along with the functions
+// they use.
+
+var loadLwpIDs = func(pid int) ([]int, error) {
+	entries, err := os.ReadDir(path.Join(procRootDir, fmt.Sprint(pid), "lwp"))
+	if err != nil {
+		return nil, err
+	}
+
+	ret := make([]int, 0, len(entries))
+
+	for _, entry := range entries {
+		lwpid, err := strconv.Atoi(entry.Name())
+		if err != nil {
+			continue
+		}
+
+		ret = append(ret, lwpid)
+	}
+
+	sort.Ints(ret)
+
+	return ret, nil
+}
+
+var loadLwpPsinfo = func(pid, lwpid int) (lwpsinfo_t, error) {
+	return readLwpsinfo(path.Join(procRootDir, fmt.Sprint(pid), "lwp", fmt.Sprint(lwpid), "lwpsinfo"))
+}
+
+var loadLwpUsage = func(pid, lwpid int) (prusage_t, error) {
+	return readPrusage(path.Join(procRootDir, fmt.Sprint(pid), "lwp", fmt.Sprint(lwpid), "lwpusage"))
+}
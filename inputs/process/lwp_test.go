@@ -0,0 +1,210 @@
+package process
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/gob"
+	"fmt"
+	"log"
+	"os"
+	"path"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/influxdata/telegraf"
+	"github.com/influxdata/telegraf/testutil"
+	"github.com/stretchr/testify/require"
+)
+
+func TestPluginLWPs(t *testing.T) {
+	t.Parallel()
+
+	s := &IllumosProcess{
+		Values:   []string{"size"},
+		Tags:     []string{"name"},
+		TopK:     1,
+		LWPStats: true,
+	}
+
+	procRootDir = "testdata/proc"
+
+	loadProcPsinfo = func(pid int) (psinfo_t, error) {
+		return psinfoFromFixture(pid), nil
+	}
+
+	loadProcUsage = func(pid int) (prusage_t, error) {
+		return usageFromFixture(pid), nil
+	}
+
+	loadLwpPsinfo = func(pid, lwpid int) (lwpsinfo_t, error) {
+		return lwpsinfoFromFixture(pid, lwpid), nil
+	}
+
+	loadLwpUsage = func(pid, lwpid int) (prusage_t, error) {
+		return lwpusageFromFixture(pid, lwpid), nil
+	}
+
+	acc := testutil.Accumulator{}
+	require.NoError(t, s.Gather(&acc))
+
+	testutil.RequireMetricsEqual(
+		t,
+		[]telegraf.Metric{
+			testutil.MustMetric(
+				"process",
+				map[string]string{"name": "zsh"},
+				map[string]interface{}{"size": int64(9805824)},
+				time.Now(),
+			),
+			testutil.MustMetric(
+				"process.lwp",
+				map[string]string{
+					"name":       "zsh",
+					"lwpid":      "1",
+					"state":      "S",
+					"syncObject": "cv",
+				},
+				map[string]interface{}{
+					"pri":     int64(59),
+					"syscall": int64(0),
+					"wchan":   "0xfffffe5a1c2e5f1e",
+				},
+				time.Now(),
+			),
+		},
+		acc.GetTelegrafMetrics(),
+		testutil.SortMetrics(),
+		testutil.IgnoreTime())
+}
+
+func TestNewLwpFields(t *testing.T) {
+	t.Parallel()
+
+	s := &IllumosProcess{Values: []string{"utime", "vctx", "pctcpu"}}
+
+	lwpsinfo := lwpsinfo_t{
+		Pr_lwpid:   14,
+		Pr_wchan:   0,
+		Pr_stype:   0,
+		Pr_sname:   'O',
+		Pr_syscall: 0,
+		Pr_pri:     60,
+		Pr_pctcpu:  1834,
+	}
+
+	copy(lwpsinfo.Pr_name[:], "GC Thread#0")
+
+	lwpusage := prusage_t{
+		Pr_lwpid: 14,
+		Pr_utime: timestruc_t{12, 345},
+		Pr_vctx:  9876,
+	}
+
+	fields, tags := newLwpFields(s, 1234, lwpsinfo, lwpusage, procObjectTags{"name": "java"})
+
+	require.Equal(
+		t,
+		map[string]interface{}{
+			"utime":   int64(12000000345),
+			"vctx":    int64(9876),
+			"pctcpu":  int64(1834),
+			"pri":     int64(60),
+			"syscall": int64(0),
+		},
+		fields,
+	)
+
+	require.Equal(
+		t,
+		map[string]string{
+			"name":       "java",
+			"lwpid":      "14",
+			"thread":     "GC Thread#0",
+			"state":      "O",
+			"syncObject": "none",
+		},
+		tags,
+	)
+}
+
+func TestThreadName(t *testing.T) {
+	t.Parallel()
+
+	var lwpsinfo lwpsinfo_t
+	require.Equal(t, "", threadName(lwpsinfo))
+
+	copy(lwpsinfo.Pr_oldname[:], "zpool-rpool")
+	require.Equal(t, "zpool-rpool", threadName(lwpsinfo))
+
+	copy(lwpsinfo.Pr_name[:], "postgres: walwriter")
+	require.Equal(t, "postgres: walwriter", threadName(lwpsinfo))
+}
+
+// Systems from before thread names have a shorter lwpsinfo_t.
+func TestReadLwpsinfo(t *testing.T) {
+	t.Parallel()
+
+	lwpsinfo := lwpsinfoFromFixture(26939, 1)
+	copy(lwpsinfo.Pr_name[:], "main")
+
+	var buf bytes.Buffer
+	require.NoError(t, binary.Write(&buf, binary.LittleEndian, lwpsinfo))
+
+	dir := t.TempDir()
+	full := filepath.Join(dir, "full")
+	short := filepath.Join(dir, "short")
+	raw := buf.Bytes()
+
+	require.NoError(t, os.WriteFile(full, raw, 0o600))
+	require.NoError(t, os.WriteFile(short, raw[:len(raw)-len(lwpsinfo.Pr_name)], 0o600))
+
+	result, err := readLwpsinfo(full)
+	require.NoError(t, err)
+	require.Equal(t, lwpsinfo, result)
+
+	result, err = readLwpsinfo(short)
+	require.NoError(t, err)
+	require.Equal(t, "", threadName(result))
+	require.Equal(t, lwpsinfo.Pr_wchan, result.Pr_wchan)
+	require.Equal(t, lwpsinfo.Pr_last_onproc, result.Pr_last_onproc)
+
+	require.NoError(t, os.WriteFile(short, []byte("short"), 0o600))
+
+	_, err = readLwpsinfo(short)
+	require.Error(t, err)
+
+	_, err = readLwpsinfo(filepath.Join(dir, "missing"))
+	require.Error(t, err)
+}
+
+func lwpsinfoFromFixture(pid, lwpid int) lwpsinfo_t {
+	var ret lwpsinfo_t
+
+	lwpFromFixture(pid, lwpid, "lwpsinfo", &ret)
+
+	return ret
+}
+
+func lwpusageFromFixture(pid, lwpid int) prusage_t {
+	var ret prusage_t
+
+	lwpFromFixture(pid, lwpid, "lwpusage", &ret)
+
+	return ret
+}
+
+func lwpFromFixture(pid, lwpid int, file string, target interface{}) {
+	filename := path.Join("testdata", "proc", fmt.Sprint(pid), "lwp", fmt.Sprint(lwpid), file)
+
+	raw, err := os.Open(filename)
+	if err != nil {
+		log.Fatalf("Could not load serialized data from disk: %v\n", err)
+	}
+
+	defer raw.Close()
+
+	if err := gob.NewDecoder(raw).Decode(target); err != nil {
+		log.Fatalf("Could not decode %s: %v\n", filename, err)
+	}
+}
@@ -0,0 +1,89 @@
+package process
+
+import "github.com/snltd/illumos-telegraf-plugins/helpers"
+
+type usageCounter struct {
+	name  string
+	value func(*prusage_t) int64
+}
+
+// usageCounters are all the prusage_t fields which only go up, along with cputime, which is
+// utime and stime together. Times are in nanoseconds. They are sent as rates if Rates is set.
+var usageCounters = []usageCounter{
+	{"rtime", func(p *prusage_t) int64 { return toNs(p.Pr_rtime) }},
+	{"utime", func(p *prusage_t) int64 { return toNs(p.Pr_utime) }},
+	{"stime", func(p *prusage_t) int64 { return toNs(p.Pr_stime) }},
+	{"cputime", func(p *prusage_t) int64 { return toNs(p.Pr_utime) + toNs(p.Pr_stime) }},
+	{"ttime", func(p *prusage_t) int64 { return toNs(p.Pr_ttime) }},
+	{"tftime", func(p *prusage_t) int64 { return toNs(p.Pr_tftime) }},
+	{"dftime", func(p *prusage_t) int64 { return toNs(p.Pr_dftime) }},
+	{"kftime", func(p *prusage_t) int64 { return toNs(p.Pr_kftime) }},
+	{"ltime", func(p *prusage_t) int64 { return toNs(p.Pr_ltime) }},
+	{"slptime", func(p *prusage_t) int64 { return toNs(p.Pr_slptime) }},
+	{"wtime", func(p *prusage_t) int64 { return toNs(p.Pr_wtime) }},
+	{"stoptime", func(p *prusage_t) int64 { return toNs(p.Pr_stoptime) }},
+	{"minf", func(p *prusage_t) int64 { return int64(p.Pr_minf) }},
+	{"majf", func(p *prusage_t) int64 { return int64(p.Pr_majf) }},
+	{"nswap", func(p *prusage_t) int64 { return int64(p.Pr_nswap) }},
+	{"inblk", func(p *prusage_t) int64 { return int64(p.Pr_inblk) }},
+	{"oublk", func(p *prusage_t) int64 { return int64(p.Pr_oublk) }},
+	{"msnd", func(p *prusage_t) int64 { return int64(p.Pr_msnd) }},
+	{"mrcv", func(p *prusage_t) int64 { return int64(p.Pr_mrcv) }},
+	{"sigs", func(p *prusage_t) int64 { return int64(p.Pr_sigs) }},
+	{"vctx", func(p *prusage_t) int64 { return int64(p.Pr_vctx) }},
+	{"ictx", func(p *prusage_t) int64 { return int64(p.Pr_ictx) }},
+	{"sysc", func(p *prusage_t) int64 { return int64(p.Pr_sysc) }},
+	{"ioch", func(p *prusage_t) int64 { return int64(p.Pr_ioch) }},
+}
+
+// microstatePcts are the time columns of prstat -m: the percentage of the time since the last
+// collection that the process's LWPs spent in each microstate. The prusage_t field in
+// usageCounters with the same value function is what each one is worked out from.
+var microstatePcts = []usageCounter{
+	{"pctusr", func(p *prusage_t) int64 { return toNs(p.Pr_utime) }},
+	{"pctsys", func(p *prusage_t) int64 { return toNs(p.Pr_stime) }},
+	{"pcttrp", func(p *prusage_t) int64 { return toNs(p.Pr_ttime) }},
+	{"pcttfl", func(p *prusage_t) int64 { return toNs(p.Pr_tftime) }},
+	{"pctdfl", func(p *prusage_t) int64 { return toNs(p.Pr_dftime) }},
+	{"pctlck", func(p *prusage_t) int64 { return toNs(p.Pr_ltime) }},
+	{"pctslp", func(p *prusage_t) int64 { return toNs(p.Pr_slptime) }},
+	{"pctlat", func(p *prusage_t) int64 { return toNs(p.Pr_wtime) }},
+}
+
+func (s *IllumosProcess) wantMicrostatePcts() bool {
+	for _, pct := range microstatePcts {
+		if helpers.WeWant(pct.name, s.Values) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// addMicrostatePcts works out the prstat -m percentages. rtime is the total time of every LWP in
+// the process, in every state, so each state's share of its change is the time spent in that
+// state. There is nothing to report the first time a process is seen.
+func (s *IllumosProcess) addMicrostatePcts(
+	values procObjectValues,
+	ks *helpers.KStat,
+	prusage *prusage_t,
+	snaptime int64,
+) {
+	if s.microstateRates == nil {
+		return
+	}
+
+	total, haveTotal := s.microstateRates.Rate(ks, "rtime", uint64(toNs(prusage.Pr_rtime)), 64, snaptime)
+
+	for _, pct := range microstatePcts {
+		if !helpers.WeWant(pct.name, s.Values) {
+			continue
+		}
+
+		rate, ok := s.microstateRates.Rate(ks, pct.name, uint64(pct.value(prusage)), 64, snaptime)
+
+		if ok && haveTotal && total > 0 {
+			values[pct.name] = rate / total * 100
+		}
+	}
+}
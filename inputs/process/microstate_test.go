@@ -0,0 +1,84 @@
+package process
+
+import (
+	"testing"
+
+	"github.com/snltd/illumos-telegraf-plugins/helpers"
+	"github.com/stretchr/testify/require"
+)
+
+func TestAddMicrostatePcts(t *testing.T) {
+	t.Parallel()
+
+	s := &IllumosProcess{
+		Values:          []string{"pctusr", "pctslp", "pctlat", "pctlck"},
+		microstateRates: helpers.NewRates(),
+	}
+
+	ks := &helpers.KStat{Module: "proc", Instance: 123, Name: "usage", Crtime: 1}
+
+	prusage := prusage_t{
+		Pr_rtime:   timestruc_t{100, 0},
+		Pr_utime:   timestruc_t{20, 0},
+		Pr_slptime: timestruc_t{70, 0},
+		Pr_wtime:   timestruc_t{10, 0},
+	}
+
+	values := procObjectValues{}
+	s.addMicrostatePcts(values, ks, &prusage, 1e9)
+	require.Empty(t, values)
+
+	// Over the next ten seconds, two LWPs spend 20s between them, mostly asleep.
+	prusage.Pr_rtime[0] += 20
+	prusage.Pr_utime[0] += 4
+	prusage.Pr_slptime[0] += 15
+	prusage.Pr_wtime[0]++
+
+	s.addMicrostatePcts(values, ks, &prusage, 11e9)
+	require.Equal(
+		t,
+		procObjectValues{
+			"pctusr": float64(20),
+			"pctslp": float64(75),
+			"pctlat": float64(5),
+			"pctlck": float64(0),
+		},
+		values,
+	)
+}
+
+func TestWantMicrostatePcts(t *testing.T) {
+	t.Parallel()
+
+	require.True(t, (&IllumosProcess{}).wantMicrostatePcts())
+	require.True(t, (&IllumosProcess{Values: []string{"rtime", "pctlck"}}).wantMicrostatePcts())
+	require.False(t, (&IllumosProcess{Values: []string{"rtime", "ltime"}}).wantMicrostatePcts())
+}
+
+func TestCputimeRate(t *testing.T) {
+	t.Parallel()
+
+	s := &IllumosProcess{rates: helpers.NewRates()}
+	ks := &helpers.KStat{Module: "proc", Instance: 123, Name: "usage", Crtime: 1}
+	prusage := prusage_t{}
+	values := procObjectValues{}
+
+	add := func(snaptime int64) {
+		for _, counter := range usageCounters {
+			if counter.name == "rtime" || counter.name == "cputime" {
+				s.addCounter(values, ks, counter.name, counter.value(&prusage), snaptime)
+			}
+		}
+	}
+
+	add(1e9)
+	require.Empty(t, values)
+
+	// Over ten seconds, two LWPs use five seconds of CPU between them. rtime only counts LWPs.
+	prusage.Pr_rtime[0] += 20
+	prusage.Pr_utime[0] += 3
+	prusage.Pr_stime[0] += 2
+
+	add(11e9)
+	require.Equal(t, procObjectValues{"rtime": float64(2e9), "cputime": float64(5e8)}, values)
+}
@@ -1,6 +1,8 @@
 /*
- * This collector sends everything as a gauge, so it's up to you and your
- * graphing software to convert them into meaningful rates.
+ * By default this collector sends everything as a gauge, so it's up to you
+ * and your graphing software to convert them into meaningful rates. Set
+ * Rates = true and the counters become per-second rates, which are also what
+ * the top K processes are chosen by.
  * It's work-in-progress, rough-and-ready, there to do a quick job.
  *
  * If you want to add more tags, like project ID or something, they
@@ -11,17 +13,19 @@ package process
 
 import (
 	"bytes"
-	"encoding/binary"
 	"errors"
 	"fmt"
 	"io/fs"
 	"log"
 	"os"
 	"path"
+	"runtime"
+	"slices"
 	"sort"
 	"strconv"
 	"strings"
 	"sync"
+	"time"
 
 	"github.com/influxdata/telegraf"
 	"github.com/influxdata/telegraf/plugins/inputs"
@@ -30,19 +34,72 @@ import (
 
 var sampleConfig = `
 	## A list of the kstat values you wish to turn into metrics. Each value
-	## will create a new timeseries. Look at the plugin source for a full
-	## list of values.
-	# Values = ["rtime", "rsssize", "inblk", "oublk", "prtcpu", "prtmem"]
+	## will create a new timeseries. Look at the plugin README for a full
+	## list of values, which includes every prusage_t counter and the
+	## prstat -m microstate percentages. nfd is the number of open files,
+	## and fdlimit and fdratio are the open file limit and how close to it a
+	## process is. All three must be asked for by name, and the last two use
+	## prctl(1), which briefly stops the processes it looks at.
+	# Values = ["cputime", "rssize", "inblk", "oublk", "pctcpu", "pctmem"]
 	## Tags you wish to be attached to ALL metrics. Again, see source for 
 	## all your options.
 	# Tags = ["name", "zoneid", "uid", "contract"]
 	## How many processes to send metrics for. You get this many process for 
 	## EACH of the Values you listed above. Don't set it to zero.
 	# TopK = 10
+	## fdlimit and fdratio are looked up for the top K processes by nfd, and for any other
+	## process with at least this many open files. Lower it if you run things with a very low
+	## open file limit.
+	# FDLimitThreshold = 128
 	## It's slightly expensive, but we can expand zone IDs and contract IDs
 	## to zone names and service names.
 	# ExpandZoneTag = true
 	# ExpandContractTag = true
+	## Add user, euser, group, egroup and project tags with the names of the IDs in the uid,
+	## euid, gid, egid and projid tags. Names come from the passwd, group and project files
+	## of the zone the process is in, which are re-read every IDCacheTTL.
+	# ExpandIDTags = false
+	# IDCacheTTL = "10m"
+	## Add up rtime, utime, stime, rssize and size, and count the processes, for every zone,
+	## project, user, task or SMF service, like prstat -Z, -J, -a and -T. Each grouping you list
+	## produces a process.summary metric per group.
+	# Summaries = ["zone", "project", "user", "task", "service"]
+	## Count the processes in each state in every zone, as process.states, and the zombie
+	## children of every parent which has any, as process.zombies.
+	# States = false
+	## Send the prusage_t counters, like cputime, sysc and inblk, as per-second rates, and pick
+	## the top K processes by those rates rather than by their lifetime totals. Rank by cputime,
+	## not rtime, whose rate is just the number of LWPs. A process is not reported until it has
+	## been seen twice.
+	# Rates = false
+	## Also send a process.lwp metric for every thread of each of the top K processes, with the
+	## same Values, and the thread's state and what it is waiting on.
+	# LWPStats = false
+	## The args tag is the start of the command line, and the exec_path tag is the full path to
+	## the executable. To keep the number of distinct values down, they can be rewritten with
+	## regular expressions, in the order given. Leave out Tag and a rule applies to both. After
+	## that, they are cut down to TagLength characters, if you set it.
+	# TagLength = 0
+	# [[inputs.illumos_process.Rewrites]]
+	#   Tag = "args"
+	#   Pattern = '^java .*-jar (\S+).*$'
+	#   Replacement = "java -jar $1"
+	## Only look at processes which match the Include lists, and ignore any which match the
+	## Exclude lists. This happens before anything else, so it affects the summaries and the top
+	## K. Patterns are globs, unless they are between slashes, when they are regular expressions.
+	## Names are matched against the name and the args of a process, zones against zone names
+	## and IDs, users against UIDs and user names, projects against project IDs and names, and
+	## services against FMRIs.
+	# IncludeNames = []
+	# ExcludeNames = ["sched", "fsflush", "zpool-*"]
+	# IncludeZones = []
+	# ExcludeZones = []
+	# IncludeUsers = []
+	# ExcludeUsers = []
+	# IncludeProjects = []
+	# ExcludeProjects = []
+	# IncludeServices = []
+	# ExcludeServices = []
 `
 
 func (s *IllumosProcess) Description() string {
@@ -53,17 +110,66 @@ func (s *IllumosProcess) SampleConfig() string {
 	return sampleConfig
 }
 
+// Init compiles the filters and the rewrite rules, and parses the cache TTL, so a bad one stops
+// Telegraf starting, rather than failing on every collection.
+func (s *IllumosProcess) Init() error {
+	if err := s.compileFilters(); err != nil {
+		return err
+	}
+
+	if err := s.checkSummaries(); err != nil {
+		return err
+	}
+
+	if s.IDCacheTTL != "" {
+		ttl, err := time.ParseDuration(s.IDCacheTTL)
+		if err != nil {
+			return fmt.Errorf("cannot parse IDCacheTTL: %w", err)
+		}
+
+		s.idCacheTTL = ttl
+	}
+
+	return s.compileRewrites()
+}
+
 type IllumosProcess struct {
 	Values            []string
 	Tags              []string
 	TopK              int
+	FDLimitThreshold  int
 	ExpandZoneTag     bool
 	ExpandContractTag bool
+	ExpandIDTags      bool
+	IDCacheTTL        string
+	Summaries         []string
+	States            bool
+	Rates             bool
+	LWPStats          bool
+	TagLength         int
+	Rewrites          []Rewrite
+	IncludeNames      []string
+	ExcludeNames      []string
+	IncludeZones      []string
+	ExcludeZones      []string
+	IncludeUsers      []string
+	ExcludeUsers      []string
+	IncludeProjects   []string
+	ExcludeProjects   []string
+	IncludeServices   []string
+	ExcludeServices   []string
+	filters           []*procFilter
+	idCache           *idCache
+	idCacheTTL        time.Duration
+	rates             *helpers.Rates
+	microstateRates   *helpers.Rates
 }
 
 type procObject struct {
-	Values procObjectValues
-	Tags   procObjectTags
+	Values        procObjectValues
+	Tags          procObjectTags
+	SummaryValues procObjectValues
+	Keys          procObjectTags
 }
 
 type (
@@ -79,6 +185,37 @@ func toNs(ts timestruc_t) int64 {
 	return ts[0]*1e9 + ts[1]
 }
 
+// procKStat lets helpers.Rates track a process's counters as if they came from a kstat. Using the
+// start time as the creation time means a recycled pid is seen as a reset, not as a new sample.
+func procKStat(psinfo psinfo_t) *helpers.KStat {
+	return &helpers.KStat{
+		Module:   "proc",
+		Instance: int(psinfo.Pr_pid),
+		Name:     "usage",
+		Crtime:   toNs(psinfo.Pr_start),
+	}
+}
+
+// addCounter puts the raw value of a prusage_t counter into values, or its per-second rate if we
+// are doing rates. There is no rate the first time a process is seen.
+func (s *IllumosProcess) addCounter(
+	values procObjectValues,
+	ks *helpers.KStat,
+	field string,
+	value int64,
+	snaptime int64,
+) {
+	if s.rates == nil {
+		values[field] = value
+
+		return
+	}
+
+	if rate, ok := s.rates.Rate(ks, field, uint64(value), 64, snaptime); ok {
+		values[field] = rate
+	}
+}
+
 func newProcObject(s *IllumosProcess, pid int) (procObject, error) {
 	psinfo, err := loadProcPsinfo(pid)
 	if err != nil {
@@ -90,42 +227,20 @@ func newProcObject(s *IllumosProcess, pid int) (procObject, error) {
 		return procObject{}, err
 	}
 
-	// This is my selection of prusage_t fields which I think might be useful.
-	// If you want any that aren't here, add them and recompile.
+	// Every prusage_t counter can be selected. Everything else is a gauge, and
+	// this is my selection of the psinfo_t fields which I think might be useful.
 
 	values := procObjectValues{}
+	ks := procKStat(psinfo)
+	snaptime := toNs(prusage.Pr_tstamp)
 
-	if helpers.WeWant("rtime", s.Values) {
-		values["rtime"] = toNs(prusage.Pr_rtime)
-	}
-
-	if helpers.WeWant("utime", s.Values) {
-		values["utime"] = toNs(prusage.Pr_utime)
-	}
-
-	if helpers.WeWant("stime", s.Values) {
-		values["stime"] = toNs(prusage.Pr_stime)
-	}
-
-	if helpers.WeWant("wtime", s.Values) {
-		values["wtime"] = toNs(prusage.Pr_wtime)
-	}
-
-	if helpers.WeWant("inblk", s.Values) {
-		values["inblk"] = int64(prusage.Pr_inblk)
-	}
-
-	if helpers.WeWant("oublk", s.Values) {
-		values["oublk"] = int64(prusage.Pr_oublk)
-	}
-
-	if helpers.WeWant("sysc", s.Values) {
-		values["sysc"] = int64(prusage.Pr_sysc)
+	for _, counter := range usageCounters {
+		if helpers.WeWant(counter.name, s.Values) {
+			s.addCounter(values, ks, counter.name, counter.value(&prusage), snaptime)
+		}
 	}
 
-	if helpers.WeWant("ioch", s.Values) {
-		values["ioch"] = int64(prusage.Pr_ioch)
-	}
+	s.addMicrostatePcts(values, ks, &prusage, snaptime)
 
 	if helpers.WeWant("size", s.Values) { // kb to b
 		values["size"] = int64(psinfo.Pr_size) * 1024
@@ -147,10 +262,20 @@ func newProcObject(s *IllumosProcess, pid int) (procObject, error) {
 		values["nlwp"] = int64(psinfo.Pr_nlwp)
 	}
 
+	if helpers.WeWant("nzomb", s.Values) {
+		values["nzomb"] = int64(psinfo.Pr_nzomb)
+	}
+
 	if helpers.WeWant("count", s.Values) {
 		values["count"] = int64(prusage.Pr_count)
 	}
 
+	if s.wantFDs() {
+		if nfd, err := countFDs(pid); err == nil {
+			values["nfd"] = int64(nfd)
+		}
+	}
+
 	// Another educated guess, this time at things which might be useful as
 	// point tags.
 
@@ -160,6 +285,23 @@ func newProcObject(s *IllumosProcess, pid int) (procObject, error) {
 		tags["name"] = string(bytes.TrimRight(psinfo.Pr_fname[:], "\x00"))
 	}
 
+	if helpers.WeWant("args", s.Tags) {
+		args := s.rewriteTag("args", string(bytes.TrimRight(psinfo.Pr_psargs[:], "\x00")))
+
+		if args != "" {
+			tags["args"] = args
+		}
+	}
+
+	if helpers.WeWant("exec_path", s.Tags) {
+		execPath, err := loadExecPath(pid)
+		if err == nil {
+			if execPath = s.rewriteTag("exec_path", execPath); execPath != "" {
+				tags["exec_path"] = execPath
+			}
+		}
+	}
+
 	if helpers.WeWant("uid", s.Tags) {
 		tags["uid"] = fmt.Sprint(psinfo.Pr_uid)
 	}
@@ -200,11 +342,50 @@ func newProcObject(s *IllumosProcess, pid int) (procObject, error) {
 		tags["ppid"] = fmt.Sprint(psinfo.Pr_ppid)
 	}
 
-	return procObject{Values: values, Tags: tags}, nil
+	procObj := procObject{Values: values, Tags: tags}
+
+	if len(s.Summaries) > 0 {
+		procObj.SummaryValues = summaryValues(psinfo, prusage)
+	}
+
+	if len(s.Summaries) > 0 || len(s.filters) > 0 || s.ExpandIDTags || s.States {
+		procObj.Keys = procKeys(psinfo)
+	}
+
+	return procObj, nil
 }
 
+// procWorkers is how many processes we read at once. Reading /proc is almost all system time,
+// so there is nothing to gain from having many more workers than CPUs.
+var procWorkers = runtime.GOMAXPROCS(0)
+
+// newProcObjectMap reads every process with a bounded pool of workers. Processes often go away
+// before we get to them, so those we can't read are left out.
 func newProcObjectMap(s *IllumosProcess, procs []fs.DirEntry) procObjectMap {
-	ret := procObjectMap{}
+	var wg sync.WaitGroup
+	var mtx sync.Mutex
+
+	ret := make(procObjectMap, len(procs))
+	pids := make(chan int)
+
+	for range procWorkers {
+		wg.Add(1)
+
+		go func() {
+			defer wg.Done()
+
+			for pid := range pids {
+				procObj, err := newProcObject(s, pid)
+				if err != nil {
+					continue
+				}
+
+				mtx.Lock()
+				ret[pid] = procObj
+				mtx.Unlock()
+			}
+		}()
+	}
 
 	for _, proc := range procs {
 		pid, err := strconv.Atoi(proc.Name())
@@ -213,9 +394,12 @@ func newProcObjectMap(s *IllumosProcess, procs []fs.DirEntry) procObjectMap {
 			continue
 		}
 
-		ret[pid], _ = newProcObject(s, pid)
+		pids <- pid
 	}
 
+	close(pids)
+	wg.Wait()
+
 	return ret
 }
 
@@ -258,107 +442,145 @@ func expandZoneTag(processMap *procObjectMap, zoneMap helpers.ZoneMap) {
 		zid, ok := procObj.Tags["zoneid"]
 
 		if ok {
-			zoneId, err := strconv.Atoi(zid)
-			if err == nil {
-				zone, err := zoneMap.ZoneByID(zoneId)
-				if err == nil {
-					procObj.Tags["zone"] = string(zone.Name)
-				}
+			if zone, ok := zoneName(zoneMap, zid); ok {
+				procObj.Tags["zone"] = zone
 			}
 		}
 	}
 }
 
+func zoneName(zoneMap helpers.ZoneMap, zid string) (string, bool) {
+	zoneId, err := strconv.Atoi(zid)
+	if err != nil {
+		return "", false
+	}
+
+	zone, err := zoneMap.ZoneByID(zoneId)
+	if err != nil {
+		return "", false
+	}
+
+	return string(zone.Name), true
+}
+
 func expandContractTag(processMap *procObjectMap, contractMap contractMap) {
 	for _, procObj := range *processMap {
 		ctid, ok := procObj.Tags["contract"]
 
 		// If we can't find a contract with the given ID, we don't add a tag.
 		if ok {
-			ctid, err := strconv.Atoi(ctid)
-			if err == nil {
-				svc, ok := contractMap[id_t(ctid)]
-				if ok {
-					procObj.Tags["service"] = svc
-				}
+			if svc, ok := serviceName(contractMap, ctid); ok {
+				procObj.Tags["service"] = svc
 			}
 		}
 	}
 }
 
-type SortPair struct {
-	Pid   int
-	Value int64
-}
+func serviceName(contractMap contractMap, ctid string) (string, bool) {
+	id, err := strconv.Atoi(ctid)
+	if err != nil {
+		return "", false
+	}
 
-func topKPids(processMap *procObjectMap, field string, topK int) []int {
-	pairs := make([]SortPair, len(*processMap))
-	i := 0
+	svc, ok := contractMap[id_t(id)]
 
-	for pid, procObj := range *processMap {
-		value, ok := procObj.Values[field]
+	return svc, ok
+}
 
-		if ok {
-			pairs[i] = SortPair{pid, value.(int64)}
-			i++
-		}
+func (s *IllumosProcess) Gather(acc telegraf.Accumulator) error {
+	if s.Rates && s.rates == nil {
+		s.rates = helpers.NewRates()
 	}
 
-	sort.Slice(pairs, func(i, j int) bool {
-		return pairs[i].Value > pairs[j].Value
-	})
+	defer s.rates.Sweep()
 
-	ret := make([]int, topK)
-	i = 0
-
-	for _, pair := range pairs[0:topK] {
-		ret[i] = pair.Pid
-		i++
+	if s.microstateRates == nil && s.wantMicrostatePcts() {
+		s.microstateRates = helpers.NewRates()
 	}
 
-	return ret
-}
+	defer s.microstateRates.Sweep()
 
-func (s *IllumosProcess) Gather(acc telegraf.Accumulator) error {
 	processMap := newProcObjectMap(s, allProcs())
 
-	if s.ExpandZoneTag {
-		expandZoneTag(&processMap, newZoneMap())
+	needIDNames := s.ExpandIDTags || s.filterOn("user", "project")
+
+	if needIDNames && s.idCache == nil {
+		s.idCache = newIDCache(s.idCacheTTL)
 	}
 
-	if s.ExpandContractTag {
+	var zoneMap helpers.ZoneMap
+
+	if s.ExpandZoneTag || slices.Contains(s.Summaries, "zone") || s.States || s.filterOn("zone") ||
+		needIDNames {
+		zoneMap = newZoneMap()
+	}
+
+	ctidMap := contractMap{}
+
+	if s.ExpandContractTag || slices.Contains(s.Summaries, "service") || s.filterOn("service") {
 		svcsOutput, err := collectContractInfo()
 		if err == nil {
-			expandContractTag(&processMap, newContractMap(svcsOutput))
+			ctidMap = newContractMap(svcsOutput)
 		}
 	}
 
-	var wg sync.WaitGroup
-	var mtx sync.Mutex
+	expandKeys(&processMap, zoneMap, ctidMap)
+
+	if needIDNames {
+		expandIDKeys(&processMap, zoneMap, s.idCache)
+	}
+
+	filterProcs(s, &processMap)
+
+	if s.wantFDLimits() {
+		addFDLimits(s, &processMap)
+	}
+
+	if s.ExpandIDTags {
+		expandIDTags(&processMap, zoneMap, s.idCache)
+	}
+
+	if s.ExpandZoneTag {
+		expandZoneTag(&processMap, zoneMap)
+	}
+
+	if s.ExpandContractTag {
+		expandContractTag(&processMap, ctidMap)
+	}
+
+	if len(s.Summaries) > 0 {
+		gatherSummaries(s, acc, &processMap)
+	}
+
+	if s.States {
+		gatherStates(acc, &processMap)
+	}
+
+	topPids := make(map[int]bool)
+	topKByField := topKPidsByField(&processMap, s.Values, s.TopK)
 
 	for _, field := range s.Values {
-		wg.Add(1)
-		go func(field string) {
-			defer wg.Done()
-			pidList := topKPids(&processMap, field, s.TopK)
-
-			for _, pid := range pidList {
-				procObj, ok := processMap[pid]
-				if ok {
-					fields := make(map[string]interface{})
-					val, ok := procObj.Values[field]
-					if ok {
-						fields[field] = val.(int64)
-						mtx.Lock()
-						acc.AddFields("process", fields, procObj.Tags)
-						mtx.Unlock()
-					}
-				}
-			}
-		}(field)
+		for _, pid := range topKByField[field] {
+			topPids[pid] = true
+			procObj := processMap[pid]
+
+			acc.AddFields("process", map[string]interface{}{field: procObj.Values[field]}, procObj.Tags)
+		}
 	}
 
-	wg.Wait()
+	if s.LWPStats {
+		pids := make([]int, 0, len(topPids))
+
+		for pid := range topPids {
+			pids = append(pids, pid)
+		}
+
+		sort.Ints(pids)
+
+		for _, pid := range pids {
+			gatherLwpStats(s, acc, pid, processMap[pid])
+		}
+	}
 
 	return nil
 }
@@ -385,37 +607,9 @@ var newZoneMap = func() helpers.ZoneMap {
 }
 
 var loadProcUsage = func(pid int) (prusage_t, error) {
-	file := fmt.Sprintf("/proc/%d/usage", pid)
-	var prusage prusage_t
-	fh, err := os.Open(file)
-	// Often the process is gone before we get to inspect it. Don't bother
-	// logging an error.
-	if err != nil {
-		return prusage, err
-	}
-
-	err = binary.Read(fh, binary.LittleEndian, &prusage)
-	if err != nil {
-		log.Printf("cannot parse %s\n", file)
-		return prusage, err
-	}
-
-	return prusage, err
+	return readPrusage(path.Join(procRootDir, fmt.Sprint(pid), "usage"))
 }
 
 var loadProcPsinfo = func(pid int) (psinfo_t, error) {
-	file := path.Join(procRootDir, fmt.Sprint(pid), "psinfo")
-	var psinfo psinfo_t
-	fh, err := os.Open(file)
-	if err != nil {
-		return psinfo, err
-	}
-
-	err = binary.Read(fh, binary.LittleEndian, &psinfo)
-	if err != nil {
-		log.Printf("cannot parse %s\n", file)
-		return psinfo, err
-	}
-
-	return psinfo, nil
+	return readPsinfo(path.Join(procRootDir, fmt.Sprint(pid), "psinfo"))
 }
@@ -18,7 +18,7 @@ import (
 )
 
 const (
-	zoneMapTxt = "0:global:running:/::ipkg:shared:0\n6:serv-build:running:/:bb60d6b1-de11-4714-a748-fd67dfde44bd:native:excl:0"
+	zoneMapTxt = "0:global:running:/::ipkg:shared:0\n6:serv-build:running:testdata/zones/serv-build:bb60d6b1-de11-4714-a748-fd67dfde44bd:native:excl:0"
 	ctidMapTxt = "   433 svc:/test/service:default\n - svc:/system/rbac:default\n  845 svc:/system/cron:default"
 )
 
@@ -123,11 +123,109 @@ func TestPlugin(t *testing.T) {
 		testutil.IgnoreTime())
 }
 
+// Not parallel, because it gives loadProcUsage its own view of time passing.
+func TestPluginRates(t *testing.T) { //nolint:paralleltest
+	s := &IllumosProcess{
+		Values: []string{"sysc", "size"},
+		Tags:   []string{"name"},
+		TopK:   1,
+		Rates:  true,
+	}
+
+	procRootDir = "testdata/proc"
+	tick := int64(0)
+	started := map[int]int64{}
+
+	loadProcPsinfo = func(pid int) (psinfo_t, error) {
+		psinfo := psinfoFromFixture(pid)
+		psinfo.Pr_start[0] += started[pid]
+
+		return psinfo, nil
+	}
+
+	// Every tick is ten seconds. cron makes 100 system calls a second, and zsh makes 2.
+	loadProcUsage = func(pid int) (prusage_t, error) {
+		prusage := usageFromFixture(pid)
+		prusage.Pr_tstamp[0] += tick * 10
+
+		if pid == 8055 {
+			prusage.Pr_sysc += ulong_t(tick * 1000)
+		} else {
+			prusage.Pr_sysc += ulong_t(tick * 20)
+		}
+
+		return prusage, nil
+	}
+
+	t.Cleanup(func() {
+		loadProcPsinfo = func(pid int) (psinfo_t, error) { return psinfoFromFixture(pid), nil }
+		loadProcUsage = func(pid int) (prusage_t, error) { return usageFromFixture(pid), nil }
+	})
+
+	acc := testutil.Accumulator{}
+
+	// First time round there are no rates, but gauges are fine.
+	require.NoError(t, s.Gather(&acc))
+	require.Equal(
+		t,
+		map[string]interface{}{"size": int64(9805824)},
+		acc.GetTelegrafMetrics()[0].Fields(),
+	)
+	require.Len(t, acc.GetTelegrafMetrics(), 1)
+
+	// zsh has made far more system calls, but cron is making them faster.
+	tick++
+	acc.ClearMetrics()
+	require.NoError(t, s.Gather(&acc))
+
+	testutil.RequireMetricsEqual(
+		t,
+		[]telegraf.Metric{
+			testutil.MustMetric(
+				"process",
+				map[string]string{"name": "cron"},
+				map[string]interface{}{"sysc": float64(100)},
+				time.Now(),
+			),
+			testutil.MustMetric(
+				"process",
+				map[string]string{"name": "zsh"},
+				map[string]interface{}{"size": int64(9805824)},
+				time.Now(),
+			),
+		},
+		acc.GetTelegrafMetrics(),
+		testutil.SortMetrics(),
+		testutil.IgnoreTime())
+
+	// cron's pid gets recycled, so there is nothing to compare the new process with.
+	tick++
+	started[8055] = 1000
+	s.Values = []string{"sysc"}
+	acc.ClearMetrics()
+	require.NoError(t, s.Gather(&acc))
+
+	testutil.RequireMetricsEqual(
+		t,
+		[]telegraf.Metric{
+			testutil.MustMetric(
+				"process",
+				map[string]string{"name": "zsh"},
+				map[string]interface{}{"sysc": float64(2)},
+				time.Now(),
+			),
+		},
+		acc.GetTelegrafMetrics(),
+		testutil.IgnoreTime())
+}
+
 func TestTopKPids(t *testing.T) {
 	t.Parallel()
 	require.Equal(t, []int{10, 40, 20, 60}, topKPids(&testObject, "rtime", 4))
 	require.Equal(t, []int{10, 40, 20}, topKPids(&testObject, "rtime", 3))
 	require.Equal(t, []int{30, 10, 60}, topKPids(&testObject, "sysc", 3))
+	require.Len(t, topKPids(&testObject, "sysc", 10), 6)
+	require.Empty(t, topKPids(&testObject, "ioch", 3))
 }
 
 func TestToNs(t *testing.T) {
@@ -208,20 +306,37 @@ func TestNewProcObjectNoFilters(t *testing.T) {
 	require.Equal(
 		t,
 		procObjectValues{
-			"rtime":  int64(55522972213516),
-			"utime":  int64(722329),
-			"stime":  int64(1697875),
-			"wtime":  int64(8630699),
-			"inblk":  int64(0),
-			"oublk":  int64(0),
-			"sysc":   int64(149),
-			"ioch":   int64(4050),
-			"size":   int64(1978368),
-			"rssize": int64(1146880),
-			"pctcpu": int64(0),
-			"pctmem": int64(2),
-			"nlwp":   int64(1),
-			"count":  int64(1),
+			"rtime":    int64(55522972213516),
+			"utime":    int64(722329),
+			"stime":    int64(1697875),
+			"cputime":  int64(722329 + 1697875),
+			"ttime":    int64(4372),
+			"tftime":   int64(0),
+			"dftime":   int64(0),
+			"kftime":   int64(0),
+			"ltime":    int64(0),
+			"slptime":  int64(55522961149250),
+			"wtime":    int64(8630699),
+			"stoptime": int64(8987),
+			"minf":     int64(0),
+			"majf":     int64(0),
+			"nswap":    int64(0),
+			"inblk":    int64(0),
+			"oublk":    int64(0),
+			"msnd":     int64(0),
+			"mrcv":     int64(0),
+			"sigs":     int64(0),
+			"vctx":     int64(5),
+			"ictx":     int64(2),
+			"sysc":     int64(149),
+			"ioch":     int64(4050),
+			"size":     int64(1978368),
+			"rssize":   int64(1146880),
+			"pctcpu":   int64(0),
+			"pctmem":   int64(2),
+			"nlwp":     int64(1),
+			"nzomb":    int64(0),
+			"count":    int64(1),
 		},
 		result.Values,
 	)
@@ -229,17 +344,19 @@ func TestNewProcObjectNoFilters(t *testing.T) {
 	require.Equal(
 		t,
 		procObjectTags{
-			"name":     "cron",
-			"uid":      "0",
-			"gid":      "0",
-			"euid":     "0",
-			"egid":     "0",
-			"taskid":   "769",
-			"projid":   "0",
-			"zoneid":   "6",
-			"contract": "845",
-			"pid":      "8055",
-			"ppid":     "4231",
+			"name":      "cron",
+			"args":      "/usr/sbin/cron",
+			"exec_path": "/usr/sbin/cron",
+			"uid":       "0",
+			"gid":       "0",
+			"euid":      "0",
+			"egid":      "0",
+			"taskid":    "769",
+			"projid":    "0",
+			"zoneid":    "6",
+			"contract":  "845",
+			"pid":       "8055",
+			"ppid":      "4231",
 		},
 		result.Tags,
 	)
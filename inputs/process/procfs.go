@@ -0,0 +1,259 @@
+package process
+
+import (
+	"encoding/binary"
+	"fmt"
+	"io"
+	"os"
+	"sync"
+)
+
+// Reading psinfo, lwpsinfo and usage with binary.Read is slow, because it works out the layout of the
+// struct by reflection every time, and it allocates a buffer for every file. With tens of
+// thousands of processes that adds up, so we read into pooled buffers and pick the fields out
+// at their fixed offsets.
+
+var (
+	psinfoSize        = binary.Size(psinfo_t{})
+	lwpsinfoSize      = binary.Size(lwpsinfo_t{})
+	lwpsinfoShortSize = lwpsinfoSize - len(lwpsinfo_t{}.Pr_name)
+	prusageSize       = binary.Size(prusage_t{})
+	filltimeSize      = binary.Size(prusage_t{}.Filltime)
+)
+
+var bufPool = sync.Pool{
+	New: func() interface{} {
+		buf := make([]byte, max(psinfoSize, lwpsinfoSize, prusageSize))
+
+		return &buf
+	},
+}
+
+// readProcFile reads the start of a /proc file into a pooled buffer, and passes what it read to
+// decode. The buffer goes back in the pool afterwards, so decode must not keep hold of it.
+func readProcFile(file string, decode func([]byte) error) error {
+	fh, err := os.Open(file)
+	if err != nil {
+		return err
+	}
+
+	defer fh.Close()
+
+	bufp, _ := bufPool.Get().(*[]byte)
+	defer bufPool.Put(bufp)
+
+	n, err := io.ReadFull(fh, *bufp)
+	if err != nil && err != io.ErrUnexpectedEOF {
+		return err
+	}
+
+	return decode((*bufp)[:n])
+}
+
+// procDecoder walks through a buffer, in the order and at the sizes of the fields of a procfs
+// struct.
+type procDecoder struct {
+	buf []byte
+	off int
+}
+
+func (d *procDecoder) skip(n int) {
+	d.off += n
+}
+
+func (d *procDecoder) byte() byte {
+	ret := d.buf[d.off]
+	d.off++
+
+	return ret
+}
+
+func (d *procDecoder) uint16() uint16 {
+	ret := binary.LittleEndian.Uint16(d.buf[d.off:])
+	d.off += 2
+
+	return ret
+}
+
+func (d *procDecoder) int32() int32 {
+	ret := int32(binary.LittleEndian.Uint32(d.buf[d.off:]))
+	d.off += 4
+
+	return ret
+}
+
+func (d *procDecoder) uint64() uint64 {
+	ret := binary.LittleEndian.Uint64(d.buf[d.off:])
+	d.off += 8
+
+	return ret
+}
+
+func (d *procDecoder) int64() int64 {
+	return int64(d.uint64())
+}
+
+func (d *procDecoder) timestruc() timestruc_t {
+	return timestruc_t{int64(d.uint64()), int64(d.uint64())}
+}
+
+func (d *procDecoder) bytes(dst []byte) {
+	d.off += copy(dst, d.buf[d.off:])
+}
+
+func decodePsinfo(buf []byte, psinfo *psinfo_t) error {
+	if len(buf) < psinfoSize {
+		return fmt.Errorf("psinfo is %d bytes, expected %d", len(buf), psinfoSize)
+	}
+
+	d := procDecoder{buf: buf}
+
+	psinfo.Pr_flag = d.int32()
+	psinfo.Pr_nlwp = d.int32()
+	psinfo.Pr_pid = pid_t(d.int32())
+	psinfo.Pr_ppid = pid_t(d.int32())
+	psinfo.Pr_pgid = pid_t(d.int32())
+	psinfo.Pr_sid = pid_t(d.int32())
+	psinfo.Pr_uid = uid_t(d.int32())
+	psinfo.Pr_euid = uid_t(d.int32())
+	psinfo.Pr_gid = gid_t(d.int32())
+	psinfo.Pr_egid = gid_t(d.int32())
+	psinfo.Pr_addr = uintptr_t(d.uint64())
+	psinfo.Pr_size = size_t(d.uint64())
+	psinfo.Pr_rssize = size_t(d.uint64())
+	psinfo.Pr_pad1 = size_t(d.uint64())
+	psinfo.Pr_ttydev = dev_t(d.uint64())
+	psinfo.Pr_pctcpu = ushort_t(d.uint16())
+	psinfo.Pr_pctmem = ushort_t(d.uint16())
+	d.bytes(psinfo.Pr_pad64bit[:])
+	psinfo.Pr_start = d.timestruc()
+	psinfo.Pr_time = d.timestruc()
+	psinfo.Pr_ctime = d.timestruc()
+	d.bytes(psinfo.Pr_fname[:])
+	d.bytes(psinfo.Pr_psargs[:])
+	psinfo.Pr_wstat = d.int32()
+	psinfo.Pr_argc = d.int32()
+	psinfo.Pr_argv = uintptr_t(d.uint64())
+	psinfo.Pr_envp = uintptr_t(d.uint64())
+	d.bytes(psinfo.Pr_dmodel[:])
+	d.bytes(psinfo.Pr_pad2[:])
+	psinfo.Pr_taskid = id_t(d.int32())
+	psinfo.Pr_projid = id_t(d.int32())
+	psinfo.Pr_nzomb = d.int32()
+	psinfo.Pr_poolid = id_t(d.int32())
+	psinfo.Pr_zoneid = id_t(d.int32())
+	psinfo.Pr_contract = id_t(d.int32())
+	psinfo.Pr_filler = d.int32()
+	d.bytes(psinfo.Pr_lwp[:])
+
+	return nil
+}
+
+// decodeLwpsinfo fills in an lwpsinfo_t. Systems without thread names have no pr_name on the
+// end, and for those the name is left empty.
+func decodeLwpsinfo(buf []byte, lwpsinfo *lwpsinfo_t) error {
+	if len(buf) < lwpsinfoShortSize {
+		return fmt.Errorf("lwpsinfo is %d bytes, expected %d", len(buf), lwpsinfoSize)
+	}
+
+	d := procDecoder{buf: buf}
+
+	lwpsinfo.Pr_flag = d.int32()
+	lwpsinfo.Pr_lwpid = id_t(d.int32())
+	lwpsinfo.Pr_addr = uintptr_t(d.uint64())
+	lwpsinfo.Pr_wchan = uintptr_t(d.uint64())
+	lwpsinfo.Pr_stype = d.byte()
+	lwpsinfo.Pr_state = d.byte()
+	lwpsinfo.Pr_sname = d.byte()
+	lwpsinfo.Pr_nice = d.byte()
+	lwpsinfo.Pr_syscall = int16(d.uint16())
+	lwpsinfo.Pr_oldpri = d.byte()
+	lwpsinfo.Pr_cpu = d.byte()
+	lwpsinfo.Pr_pri = d.int32()
+	lwpsinfo.Pr_pctcpu = ushort_t(d.uint16())
+	lwpsinfo.Pr_pad = ushort_t(d.uint16())
+	lwpsinfo.Pr_start = d.timestruc()
+	lwpsinfo.Pr_time = d.timestruc()
+	d.bytes(lwpsinfo.Pr_clname[:])
+	d.bytes(lwpsinfo.Pr_oldname[:])
+	lwpsinfo.Pr_onpro = processorid_t(d.int32())
+	lwpsinfo.Pr_bindpro = processorid_t(d.int32())
+	lwpsinfo.Pr_bindpset = psetid_t(d.int32())
+	lwpsinfo.Pr_lgrp = d.int32()
+	lwpsinfo.Pr_last_onproc = d.int64()
+	lwpsinfo.Pr_name = [32]byte{}
+	d.bytes(lwpsinfo.Pr_name[:])
+
+	return nil
+}
+
+// decodePrusage fills in a prusage_t. The fillers are never used, so they are skipped.
+func decodePrusage(buf []byte, prusage *prusage_t) error {
+	if len(buf) < prusageSize {
+		return fmt.Errorf("prusage is %d bytes, expected %d", len(buf), prusageSize)
+	}
+
+	d := procDecoder{buf: buf}
+
+	prusage.Pr_lwpid = id_t(d.int32())
+	prusage.Pr_count = d.int32()
+	prusage.Pr_tstamp = d.timestruc()
+	prusage.Pr_create = d.timestruc()
+	prusage.Pr_term = d.timestruc()
+	prusage.Pr_rtime = d.timestruc()
+	prusage.Pr_utime = d.timestruc()
+	prusage.Pr_stime = d.timestruc()
+	prusage.Pr_ttime = d.timestruc()
+	prusage.Pr_tftime = d.timestruc()
+	prusage.Pr_dftime = d.timestruc()
+	prusage.Pr_kftime = d.timestruc()
+	prusage.Pr_ltime = d.timestruc()
+	prusage.Pr_slptime = d.timestruc()
+	prusage.Pr_wtime = d.timestruc()
+	prusage.Pr_stoptime = d.timestruc()
+	d.skip(filltimeSize)
+	prusage.Pr_minf = ulong_t(d.uint64())
+	prusage.Pr_majf = ulong_t(d.uint64())
+	prusage.Pr_nswap = ulong_t(d.uint64())
+	prusage.Pr_inblk = ulong_t(d.uint64())
+	prusage.Pr_oublk = ulong_t(d.uint64())
+	prusage.Pr_msnd = ulong_t(d.uint64())
+	prusage.Pr_mrcv = ulong_t(d.uint64())
+	prusage.Pr_sigs = ulong_t(d.uint64())
+	prusage.Pr_vctx = ulong_t(d.uint64())
+	prusage.Pr_ictx = ulong_t(d.uint64())
+	prusage.Pr_sysc = ulong_t(d.uint64())
+	prusage.Pr_ioch = ulong_t(d.uint64())
+
+	return nil
+}
+
+func readPsinfo(file string) (psinfo_t, error) {
+	var psinfo psinfo_t
+
+	err := readProcFile(file, func(buf []byte) error {
+		return decodePsinfo(buf, &psinfo)
+	})
+
+	return psinfo, err
+}
+
+func readLwpsinfo(file string) (lwpsinfo_t, error) {
+	var lwpsinfo lwpsinfo_t
+
+	err := readProcFile(file, func(buf []byte) error {
+		return decodeLwpsinfo(buf, &lwpsinfo)
+	})
+
+	return lwpsinfo, err
+}
+
+func readPrusage(file string) (prusage_t, error) {
+	var prusage prusage_t
+
+	err := readProcFile(file, func(buf []byte) error {
+		return decodePrusage(buf, &prusage)
+	})
+
+	return prusage, err
+}
@@ -0,0 +1,247 @@
+package process
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"os"
+	"path"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestDecodePsinfo(t *testing.T) {
+	t.Parallel()
+
+	for _, pid := range []int{8055, 26939} {
+		expected := psinfoFromFixture(pid)
+
+		var result psinfo_t
+
+		require.NoError(t, decodePsinfo(toBinary(t, expected), &result))
+		require.Equal(t, expected, result)
+	}
+
+	require.Error(t, decodePsinfo(make([]byte, 100), &psinfo_t{}))
+}
+
+func TestDecodeLwpsinfo(t *testing.T) {
+	t.Parallel()
+
+	expected := lwpsinfoFromFixture(26939, 1)
+	copy(expected.Pr_name[:], "main")
+	raw := toBinary(t, expected)
+
+	result := lwpsinfo_t{}
+
+	require.NoError(t, decodeLwpsinfo(raw, &result))
+	require.Equal(t, expected, result)
+
+	expected.Pr_name = [32]byte{}
+
+	require.NoError(t, decodeLwpsinfo(raw[:lwpsinfoShortSize], &result))
+	require.Equal(t, expected, result)
+
+	require.Error(t, decodeLwpsinfo(raw[:lwpsinfoShortSize-1], &lwpsinfo_t{}))
+}
+
+func TestDecodePrusage(t *testing.T) {
+	t.Parallel()
+
+	for _, pid := range []int{8055, 26939} {
+		expected := usageFromFixture(pid)
+		expected.Filltime = [6]timestruc_t{}
+		expected.Filler = [10]ulong_t{}
+
+		var result prusage_t
+
+		require.NoError(t, decodePrusage(toBinary(t, expected), &result))
+		require.Equal(t, expected, result)
+	}
+
+	require.Error(t, decodePrusage(make([]byte, 100), &prusage_t{}))
+}
+
+func TestReadPsinfo(t *testing.T) {
+	t.Parallel()
+
+	dir := t.TempDir()
+	expected := psinfoFromFixture(26939)
+	file := path.Join(dir, "psinfo")
+
+	require.NoError(t, os.WriteFile(file, toBinary(t, expected), 0o600))
+
+	result, err := readPsinfo(file)
+	require.NoError(t, err)
+	require.Equal(t, expected, result)
+
+	require.NoError(t, os.WriteFile(file, []byte("short"), 0o600))
+
+	_, err = readPsinfo(file)
+	require.Error(t, err)
+
+	_, err = readPsinfo(path.Join(dir, "missing"))
+	require.Error(t, err)
+}
+
+func BenchmarkDecodePsinfo(b *testing.B) {
+	raw := toBinary(b, psinfoFromFixture(26939))
+
+	var psinfo psinfo_t
+
+	b.ReportAllocs()
+	b.ResetTimer()
+
+	for range b.N {
+		_ = decodePsinfo(raw, &psinfo)
+	}
+}
+
+func BenchmarkBinaryReadPsinfo(b *testing.B) {
+	raw := toBinary(b, psinfoFromFixture(26939))
+
+	var psinfo psinfo_t
+
+	b.ReportAllocs()
+	b.ResetTimer()
+
+	for range b.N {
+		_ = binary.Read(bytes.NewReader(raw), binary.LittleEndian, &psinfo)
+	}
+}
+
+func BenchmarkDecodeLwpsinfo(b *testing.B) {
+	raw := toBinary(b, lwpsinfoFromFixture(26939, 1))
+
+	var lwpsinfo lwpsinfo_t
+
+	b.ReportAllocs()
+	b.ResetTimer()
+
+	for range b.N {
+		_ = decodeLwpsinfo(raw, &lwpsinfo)
+	}
+}
+
+func BenchmarkBinaryReadLwpsinfo(b *testing.B) {
+	raw := toBinary(b, lwpsinfoFromFixture(26939, 1))
+
+	var lwpsinfo lwpsinfo_t
+
+	b.ReportAllocs()
+	b.ResetTimer()
+
+	for range b.N {
+		_ = binary.Read(bytes.NewReader(raw), binary.LittleEndian, &lwpsinfo)
+	}
+}
+
+func BenchmarkDecodePrusage(b *testing.B) {
+	raw := toBinary(b, usageFromFixture(26939))
+
+	var prusage prusage_t
+
+	b.ReportAllocs()
+	b.ResetTimer()
+
+	for range b.N {
+		_ = decodePrusage(raw, &prusage)
+	}
+}
+
+func BenchmarkBinaryReadPrusage(b *testing.B) {
+	raw := toBinary(b, usageFromFixture(26939))
+
+	var prusage prusage_t
+
+	b.ReportAllocs()
+	b.ResetTimer()
+
+	for range b.N {
+		_ = binary.Read(bytes.NewReader(raw), binary.LittleEndian, &prusage)
+	}
+}
+
+// BenchmarkNewProcObjectMap reads a /proc made of many copies of the processes in testdata/proc,
+// with the real loaders.
+func BenchmarkNewProcObjectMap(b *testing.B) {
+	useProcTree(b, 5000)
+
+	s := &IllumosProcess{
+		Values: []string{"rtime", "rssize", "inblk", "oublk", "pctcpu", "pctmem"},
+		Tags:   []string{"name", "zoneid", "uid", "contract"},
+	}
+
+	procs := allProcs()
+
+	b.ReportAllocs()
+	b.ResetTimer()
+
+	for range b.N {
+		newProcObjectMap(s, procs)
+	}
+}
+
+func BenchmarkTopKPidsByField(b *testing.B) {
+	useProcTree(b, 5000)
+
+	fields := []string{"rtime", "rssize", "inblk", "oublk", "pctcpu", "pctmem"}
+	processMap := newProcObjectMap(&IllumosProcess{Values: fields}, allProcs())
+
+	b.ReportAllocs()
+	b.ResetTimer()
+
+	for range b.N {
+		topKPidsByField(&processMap, fields, 10)
+	}
+}
+
+// useProcTree writes count processes, copied from the fixtures, as raw procfs files in a
+// temporary directory, and points the plugin at them.
+func useProcTree(b *testing.B, count int) {
+	b.Helper()
+
+	dir := b.TempDir()
+	fixturePids := []int{8055, 26939}
+	origRoot, origPsinfo, origUsage := procRootDir, loadProcPsinfo, loadProcUsage
+
+	b.Cleanup(func() {
+		procRootDir, loadProcPsinfo, loadProcUsage = origRoot, origPsinfo, origUsage
+	})
+
+	for pid := 1; pid <= count; pid++ {
+		psinfo := psinfoFromFixture(fixturePids[pid%len(fixturePids)])
+		psinfo.Pr_pid = pid_t(pid)
+		psinfo.Pr_rssize += size_t(pid)
+
+		prusage := usageFromFixture(fixturePids[pid%len(fixturePids)])
+		prusage.Pr_inblk += ulong_t(pid)
+
+		procDir := path.Join(dir, fmt.Sprint(pid))
+
+		require.NoError(b, os.Mkdir(procDir, 0o700))
+		require.NoError(b, os.WriteFile(path.Join(procDir, "psinfo"), toBinary(b, psinfo), 0o600))
+		require.NoError(b, os.WriteFile(path.Join(procDir, "usage"), toBinary(b, prusage), 0o600))
+	}
+
+	procRootDir = dir
+	loadProcPsinfo = func(pid int) (psinfo_t, error) {
+		return readPsinfo(path.Join(procRootDir, fmt.Sprint(pid), "psinfo"))
+	}
+	loadProcUsage = func(pid int) (prusage_t, error) {
+		return readPrusage(path.Join(procRootDir, fmt.Sprint(pid), "usage"))
+	}
+}
+
+// toBinary lays out a procfs struct the way the kernel does, using the slow, reflective encoder
+// which we no longer read with.
+func toBinary(tb testing.TB, data interface{}) []byte {
+	tb.Helper()
+
+	var buf bytes.Buffer
+
+	require.NoError(tb, binary.Write(&buf, binary.LittleEndian, data))
+
+	return buf.Bytes()
+}
@@ -0,0 +1,129 @@
+package process
+
+import (
+	"sort"
+	"strconv"
+
+	"github.com/influxdata/telegraf"
+)
+
+// lwpSnameOffset is where pr_sname, the printable state, lives in the lwpsinfo_t of the
+// representative LWP at the end of psinfo_t.
+const lwpSnameOffset = 26
+
+// procStates maps pr_sname to the field a process in that state is counted in, from
+// /usr/include/sys/proc.h.
+var procStates = map[byte]string{
+	'O': "onproc",
+	'R': "run",
+	'S': "sleep",
+	'T': "stop",
+	'Z': "zombie",
+	'I': "idle",
+	'W': "wait",
+}
+
+// procState is the state of a process, as prstat shows it. A zombie has no LWPs, but its
+// representative LWP still says Z.
+func procState(psinfo psinfo_t) string {
+	return string(psinfo.Pr_lwp[lwpSnameOffset])
+}
+
+// countStates counts the processes in each state for every zone, like the totals at the bottom
+// of prstat -Z. It is keyed on zone ID.
+func countStates(processMap *procObjectMap) map[string]processSummary {
+	ret := make(map[string]processSummary)
+
+	for _, procObj := range *processMap {
+		zid, ok := procObj.Keys["zoneid"]
+		if !ok {
+			continue
+		}
+
+		summary, ok := ret[zid]
+
+		if !ok {
+			summary = processSummary{Values: procObjectValues{}, Tags: procObjectTags{"zoneid": zid}}
+
+			for _, state := range procStates {
+				summary.Values[state] = int64(0)
+			}
+
+			if zone, ok := procObj.Keys["zone"]; ok {
+				summary.Tags["zone"] = zone
+			}
+
+			ret[zid] = summary
+		}
+
+		if sname := procObj.Keys["state"]; len(sname) == 1 {
+			if state, ok := procStates[sname[0]]; ok {
+				summary.Values[state] = summary.Values[state].(int64) + 1
+			}
+		}
+	}
+
+	return ret
+}
+
+// countZombies counts the zombie children of each process, keyed on the parent's pid. A parent
+// which never reaps its children shows up here long before it fills the process table.
+func countZombies(processMap *procObjectMap) map[string]processSummary {
+	ret := make(map[string]processSummary)
+
+	for _, procObj := range *processMap {
+		if procObj.Keys["state"] != "Z" {
+			continue
+		}
+
+		ppid := procObj.Keys["ppid"]
+		summary, ok := ret[ppid]
+
+		if !ok {
+			summary = processSummary{
+				Values: procObjectValues{"count": int64(0)},
+				Tags:   procObjectTags{"ppid": ppid},
+			}
+
+			for _, tag := range []string{"zoneid", "zone"} {
+				if val, ok := procObj.Keys[tag]; ok {
+					summary.Tags[tag] = val
+				}
+			}
+
+			if pid, err := strconv.Atoi(ppid); err == nil {
+				if parent, ok := (*processMap)[pid]; ok {
+					summary.Tags["parent"] = parent.Keys["name"]
+				}
+			}
+
+			ret[ppid] = summary
+		}
+
+		summary.Values["count"] = summary.Values["count"].(int64) + 1
+	}
+
+	return ret
+}
+
+func gatherStates(acc telegraf.Accumulator, processMap *procObjectMap) {
+	for _, counts := range []struct {
+		measurement string
+		summaries   map[string]processSummary
+	}{
+		{"process.states", countStates(processMap)},
+		{"process.zombies", countZombies(processMap)},
+	} {
+		keys := make([]string, 0, len(counts.summaries))
+
+		for key := range counts.summaries {
+			keys = append(keys, key)
+		}
+
+		sort.Strings(keys)
+
+		for _, key := range keys {
+			acc.AddFields(counts.measurement, counts.summaries[key].Values, counts.summaries[key].Tags)
+		}
+	}
+}
@@ -0,0 +1,174 @@
+package process
+
+import (
+	"testing"
+	"time"
+
+	"github.com/influxdata/telegraf"
+	"github.com/influxdata/telegraf/testutil"
+	"github.com/snltd/illumos-telegraf-plugins/helpers"
+	"github.com/stretchr/testify/require"
+)
+
+func TestProcState(t *testing.T) {
+	t.Parallel()
+
+	require.Equal(t, "S", procState(psinfoFromFixture(8055)))
+
+	var zombie psinfo_t
+
+	zombie.Pr_lwp[lwpSnameOffset] = 'Z'
+	require.Equal(t, "Z", procState(zombie))
+}
+
+func TestCountStates(t *testing.T) {
+	t.Parallel()
+
+	result := countStates(&testStateObject)
+
+	require.Equal(
+		t,
+		map[string]processSummary{
+			"0": {
+				Values: procObjectValues{
+					"onproc": int64(1),
+					"run":    int64(0),
+					"sleep":  int64(1),
+					"stop":   int64(0),
+					"zombie": int64(0),
+					"idle":   int64(0),
+					"wait":   int64(0),
+				},
+				Tags: procObjectTags{"zoneid": "0", "zone": "global"},
+			},
+			"6": {
+				Values: procObjectValues{
+					"onproc": int64(0),
+					"run":    int64(1),
+					"sleep":  int64(1),
+					"stop":   int64(0),
+					"zombie": int64(3),
+					"idle":   int64(0),
+					"wait":   int64(0),
+				},
+				Tags: procObjectTags{"zoneid": "6"},
+			},
+		},
+		result,
+	)
+}
+
+func TestCountZombies(t *testing.T) {
+	t.Parallel()
+
+	require.Equal(
+		t,
+		map[string]processSummary{
+			"20": {
+				Values: procObjectValues{"count": int64(2)},
+				Tags:   procObjectTags{"ppid": "20", "parent": "vendord", "zoneid": "6"},
+			},
+			"99": {
+				Values: procObjectValues{"count": int64(1)},
+				Tags:   procObjectTags{"ppid": "99", "zoneid": "6"},
+			},
+		},
+		countZombies(&testStateObject),
+	)
+}
+
+// Not parallel, because it replaces loadProcPsinfo to make a zombie.
+//
+//nolint:paralleltest
+func TestPluginStates(t *testing.T) {
+	s := &IllumosProcess{
+		Tags:   []string{"name"},
+		States: true,
+	}
+
+	procRootDir = "testdata/proc"
+
+	loadProcPsinfo = func(pid int) (psinfo_t, error) {
+		psinfo := psinfoFromFixture(pid)
+
+		if pid == 26939 {
+			psinfo.Pr_ppid = 8055
+			psinfo.Pr_nlwp = 0
+			psinfo.Pr_lwp[lwpSnameOffset] = 'Z'
+		}
+
+		return psinfo, nil
+	}
+
+	loadProcUsage = func(pid int) (prusage_t, error) {
+		return usageFromFixture(pid), nil
+	}
+
+	newZoneMap = func() helpers.ZoneMap {
+		return helpers.NewZoneMapFromText(zoneMapTxt)
+	}
+
+	t.Cleanup(func() {
+		loadProcPsinfo = func(pid int) (psinfo_t, error) { return psinfoFromFixture(pid), nil }
+	})
+
+	acc := testutil.Accumulator{}
+	require.NoError(t, s.Gather(&acc))
+
+	testutil.RequireMetricsEqual(
+		t,
+		[]telegraf.Metric{
+			testutil.MustMetric(
+				"process.states",
+				map[string]string{"zoneid": "6", "zone": "serv-build"},
+				map[string]interface{}{
+					"onproc": int64(0),
+					"run":    int64(0),
+					"sleep":  int64(1),
+					"stop":   int64(0),
+					"zombie": int64(1),
+					"idle":   int64(0),
+					"wait":   int64(0),
+				},
+				time.Now(),
+			),
+			testutil.MustMetric(
+				"process.zombies",
+				map[string]string{
+					"ppid":   "8055",
+					"parent": "cron",
+					"zoneid": "6",
+					"zone":   "serv-build",
+				},
+				map[string]interface{}{"count": int64(1)},
+				time.Now(),
+			),
+		},
+		acc.GetTelegrafMetrics(),
+		testutil.SortMetrics(),
+		testutil.IgnoreTime())
+}
+
+var testStateObject = procObjectMap{
+	1: procObject{
+		Keys: procObjectTags{"zoneid": "6", "ppid": "0", "state": "S", "name": "init"},
+	},
+	5: procObject{
+		Keys: procObjectTags{"zoneid": "0", "zone": "global", "ppid": "0", "state": "O"},
+	},
+	7: procObject{
+		Keys: procObjectTags{"zoneid": "0", "zone": "global", "ppid": "5", "state": "S"},
+	},
+	20: procObject{
+		Keys: procObjectTags{"zoneid": "6", "ppid": "1", "state": "R", "name": "vendord"},
+	},
+	21: procObject{
+		Keys: procObjectTags{"zoneid": "6", "ppid": "20", "state": "Z", "name": "vendord"},
+	},
+	22: procObject{
+		Keys: procObjectTags{"zoneid": "6", "ppid": "20", "state": "Z", "name": "vendord"},
+	},
+	30: procObject{
+		Keys: procObjectTags{"zoneid": "6", "ppid": "99", "state": "Z", "name": "lost"},
+	},
+}
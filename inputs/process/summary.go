@@ -0,0 +1,114 @@
+package process
+
+import (
+	"fmt"
+	"sort"
+
+	"github.com/influxdata/telegraf"
+)
+
+// summaryFields are the values which are added up for each group of processes. The number of
+// processes in the group is reported as count.
+var summaryFields = []string{"rtime", "utime", "stime", "rssize", "size"}
+
+// summaryTags maps each way of grouping processes to the tags which identify a group. The first
+// tag is the one we group on: a process without it is left out of that summary.
+var summaryTags = map[string][]string{
+	"zone":    {"zoneid", "zone"},
+	"project": {"projid", "project"},
+	"user":    {"uid", "user"},
+	"task":    {"taskid"},
+	"service": {"service"},
+}
+
+// checkSummaries makes sure every grouping in Summaries is one we know, so a typo doesn't
+// quietly give us nothing.
+func (s *IllumosProcess) checkSummaries() error {
+	for _, groupBy := range s.Summaries {
+		if _, ok := summaryTags[groupBy]; !ok {
+			return fmt.Errorf("cannot summarize by %s: use zone, project, user, task or service", groupBy)
+		}
+	}
+
+	return nil
+}
+
+// summaryValues pulls out the values we add up, regardless of the Values the user asked for.
+func summaryValues(psinfo psinfo_t, prusage prusage_t) procObjectValues {
+	return procObjectValues{
+		"rtime":  toNs(prusage.Pr_rtime),
+		"utime":  toNs(prusage.Pr_utime),
+		"stime":  toNs(prusage.Pr_stime),
+		"rssize": int64(psinfo.Pr_rssize) * 1024,
+		"size":   int64(psinfo.Pr_size) * 1024,
+	}
+}
+
+type processSummary struct {
+	Values procObjectValues
+	Tags   procObjectTags
+}
+
+// summarize adds up the summary values of every process, grouped as prstat(8) does with -Z, -J,
+// -a or -T. The result is keyed on the value of the tag we group on.
+func summarize(processMap *procObjectMap, groupBy string) map[string]processSummary {
+	ret := make(map[string]processSummary)
+	tagNames := summaryTags[groupBy]
+
+	if len(tagNames) == 0 {
+		return ret
+	}
+
+	for _, procObj := range *processMap {
+		key, ok := procObj.Keys[tagNames[0]]
+		if !ok {
+			continue
+		}
+
+		summary, ok := ret[key]
+
+		if !ok {
+			summary = processSummary{
+				Values: procObjectValues{"count": int64(0)},
+				Tags:   procObjectTags{"groupBy": groupBy},
+			}
+
+			for _, field := range summaryFields {
+				summary.Values[field] = int64(0)
+			}
+
+			for _, tag := range tagNames {
+				if val, ok := procObj.Keys[tag]; ok {
+					summary.Tags[tag] = val
+				}
+			}
+
+			ret[key] = summary
+		}
+
+		for _, field := range summaryFields {
+			summary.Values[field] = summary.Values[field].(int64) + procObj.SummaryValues[field].(int64)
+		}
+
+		summary.Values["count"] = summary.Values["count"].(int64) + 1
+	}
+
+	return ret
+}
+
+func gatherSummaries(s *IllumosProcess, acc telegraf.Accumulator, processMap *procObjectMap) {
+	for _, groupBy := range s.Summaries {
+		summaries := summarize(processMap, groupBy)
+		keys := make([]string, 0, len(summaries))
+
+		for key := range summaries {
+			keys = append(keys, key)
+		}
+
+		sort.Strings(keys)
+
+		for _, key := range keys {
+			acc.AddFields("process.summary", summaries[key].Values, summaries[key].Tags)
+		}
+	}
+}
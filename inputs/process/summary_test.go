@@ -0,0 +1,144 @@
+package process
+
+import (
+	"testing"
+	"time"
+
+	"github.com/influxdata/telegraf"
+	"github.com/influxdata/telegraf/testutil"
+	"github.com/snltd/illumos-telegraf-plugins/helpers"
+	"github.com/stretchr/testify/require"
+)
+
+func TestPluginSummaries(t *testing.T) {
+	t.Parallel()
+
+	s := &IllumosProcess{
+		Summaries: []string{"zone", "service"},
+	}
+
+	procRootDir = "testdata/proc"
+
+	loadProcPsinfo = func(pid int) (psinfo_t, error) {
+		return psinfoFromFixture(pid), nil
+	}
+
+	loadProcUsage = func(pid int) (prusage_t, error) {
+		return usageFromFixture(pid), nil
+	}
+
+	collectContractInfo = func() (string, error) {
+		return ctidMapTxt, nil
+	}
+
+	newZoneMap = func() helpers.ZoneMap {
+		return helpers.NewZoneMapFromText(zoneMapTxt)
+	}
+
+	acc := testutil.Accumulator{}
+	require.NoError(t, s.Gather(&acc))
+
+	testMetrics := []telegraf.Metric{
+		testutil.MustMetric(
+			"process.summary",
+			map[string]string{
+				"groupBy": "zone",
+				"zoneid":  "6",
+				"zone":    "serv-build",
+			},
+			map[string]interface{}{
+				"rtime":  int64(78899362732317),
+				"utime":  int64(2677903790),
+				"stime":  int64(1271795878),
+				"rssize": int64(8998912),
+				"size":   int64(11784192),
+				"count":  int64(2),
+			},
+			time.Now(),
+		),
+		testutil.MustMetric(
+			"process.summary",
+			map[string]string{
+				"groupBy": "service",
+				"service": "svc:/system/cron:default",
+			},
+			map[string]interface{}{
+				"rtime":  int64(55522972213516),
+				"utime":  int64(722329),
+				"stime":  int64(1697875),
+				"rssize": int64(1146880),
+				"size":   int64(1978368),
+				"count":  int64(1),
+			},
+			time.Now(),
+		),
+	}
+
+	testutil.RequireMetricsEqual(
+		t,
+		testMetrics,
+		acc.GetTelegrafMetrics(),
+		testutil.SortMetrics(),
+		testutil.IgnoreTime())
+}
+
+func TestSummarize(t *testing.T) {
+	t.Parallel()
+
+	processMap := procObjectMap{
+		10: procObject{
+			SummaryValues: procObjectValues{
+				"rtime": int64(100), "utime": int64(10), "stime": int64(1), "rssize": int64(4096),
+				"size": int64(8192),
+			},
+			Keys: procObjectTags{"uid": "264", "projid": "3"},
+		},
+		20: procObject{
+			SummaryValues: procObjectValues{
+				"rtime": int64(200), "utime": int64(20), "stime": int64(2), "rssize": int64(4096),
+				"size": int64(8192),
+			},
+			Keys: procObjectTags{"uid": "264", "projid": "0"},
+		},
+		30: procObject{
+			SummaryValues: procObjectValues{
+				"rtime": int64(300), "utime": int64(30), "stime": int64(3), "rssize": int64(4096),
+				"size": int64(8192),
+			},
+			Keys: procObjectTags{"uid": "0", "projid": "0"},
+		},
+	}
+
+	require.Equal(
+		t,
+		map[string]processSummary{
+			"264": {
+				Values: procObjectValues{
+					"rtime": int64(300), "utime": int64(30), "stime": int64(3),
+					"rssize": int64(8192), "size": int64(16384), "count": int64(2),
+				},
+				Tags: procObjectTags{"groupBy": "user", "uid": "264"},
+			},
+			"0": {
+				Values: procObjectValues{
+					"rtime": int64(300), "utime": int64(30), "stime": int64(3),
+					"rssize": int64(4096), "size": int64(8192), "count": int64(1),
+				},
+				Tags: procObjectTags{"groupBy": "user", "uid": "0"},
+			},
+		},
+		summarize(&processMap, "user"),
+	)
+
+	require.Len(t, summarize(&processMap, "project"), 2)
+	require.Empty(t, summarize(&processMap, "service"))
+	require.Empty(t, summarize(&processMap, "nonsense"))
+}
+
+func TestCheckSummaries(t *testing.T) {
+	t.Parallel()
+
+	require.NoError(t, (&IllumosProcess{}).Init())
+	require.NoError(t, (&IllumosProcess{Summaries: []string{"zone", "project", "user", "task", "service"}}).Init())
+	require.Error(t, (&IllumosProcess{Summaries: []string{"zone", "zones"}}).Init())
+}
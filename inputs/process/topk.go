@@ -0,0 +1,105 @@
+package process
+
+import (
+	"container/heap"
+)
+
+type SortPair struct {
+	Pid   int
+	Value float64
+}
+
+// topKHeap is a min-heap holding the best K processes seen so far, so the root is always the
+// one to drop when a better one comes along. Ties go to the lower pid, to keep the output
+// steady.
+type topKHeap []SortPair
+
+func worse(a, b SortPair) bool {
+	return a.Value < b.Value || (a.Value == b.Value && a.Pid > b.Pid)
+}
+
+func (h topKHeap) Len() int           { return len(h) }
+func (h topKHeap) Less(i, j int) bool { return worse(h[i], h[j]) }
+func (h topKHeap) Swap(i, j int)      { h[i], h[j] = h[j], h[i] }
+
+func (h *topKHeap) Push(x interface{}) {
+	pair, _ := x.(SortPair)
+	*h = append(*h, pair)
+}
+
+func (h *topKHeap) Pop() interface{} {
+	old := *h
+	ret := old[len(old)-1]
+	*h = old[:len(old)-1]
+
+	return ret
+}
+
+func (h *topKHeap) offer(pair SortPair, topK int) {
+	switch {
+	case h.Len() < topK:
+		heap.Push(h, pair)
+	case worse((*h)[0], pair):
+		(*h)[0] = pair
+		heap.Fix(h, 0)
+	}
+}
+
+// pids empties the heap, returning the pids best first.
+func (h *topKHeap) pids() []int {
+	ret := make([]int, h.Len())
+
+	for i := len(ret) - 1; i >= 0; i-- {
+		pair, _ := heap.Pop(h).(SortPair)
+		ret[i] = pair.Pid
+	}
+
+	return ret
+}
+
+// Values are int64s, unless they are rates, which are float64s.
+func sortValue(value interface{}) float64 {
+	switch v := value.(type) {
+	case int64:
+		return float64(v)
+	case float64:
+		return v
+	default:
+		return 0
+	}
+}
+
+// topKPidsByField finds the pids of the topK processes with the highest value of each of the
+// fields, in a single pass over the map. Processes without a field, such as those which have no
+// rate yet, are not considered for it.
+func topKPidsByField(processMap *procObjectMap, fields []string, topK int) map[string][]int {
+	heaps := make(map[string]*topKHeap, len(fields))
+
+	for _, field := range fields {
+		h := make(topKHeap, 0, max(topK, 0))
+		heaps[field] = &h
+	}
+
+	if topK > 0 {
+		for pid, procObj := range *processMap {
+			for field, h := range heaps {
+				if value, ok := procObj.Values[field]; ok {
+					h.offer(SortPair{pid, sortValue(value)}, topK)
+				}
+			}
+		}
+	}
+
+	ret := make(map[string][]int, len(heaps))
+
+	for field, h := range heaps {
+		ret[field] = h.pids()
+	}
+
+	return ret
+}
+
+// topKPids returns the pids of the topK processes with the highest values of field.
+func topKPids(processMap *procObjectMap, field string, topK int) []int {
+	return topKPidsByField(processMap, []string{field}, topK)[field]
+}
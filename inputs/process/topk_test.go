@@ -0,0 +1,41 @@
+package process
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestTopKPidsByField(t *testing.T) {
+	t.Parallel()
+
+	require.Equal(
+		t,
+		map[string][]int{
+			"rtime": {10, 40, 20},
+			"sysc":  {30, 10, 60},
+			"ioch":  {},
+		},
+		topKPidsByField(&testObject, []string{"rtime", "sysc", "ioch"}, 3),
+	)
+
+	require.Equal(
+		t,
+		map[string][]int{"rtime": {}},
+		topKPidsByField(&testObject, []string{"rtime"}, 0),
+	)
+}
+
+func TestTopKPidsTies(t *testing.T) {
+	t.Parallel()
+
+	processMap := procObjectMap{
+		5: procObject{Values: procObjectValues{"nlwp": int64(2)}},
+		3: procObject{Values: procObjectValues{"nlwp": int64(2)}},
+		9: procObject{Values: procObjectValues{"nlwp": float64(4)}},
+		1: procObject{Values: procObjectValues{"nlwp": int64(1)}},
+	}
+
+	require.Equal(t, []int{9, 3, 5}, topKPids(&processMap, "nlwp", 3))
+	require.Equal(t, []int{9, 3}, topKPids(&processMap, "nlwp", 2))
+}
@@ -0,0 +1,356 @@
+package rctl
+
+import (
+	"fmt"
+	"log"
+	"maps"
+	"math"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/influxdata/telegraf"
+	"github.com/influxdata/telegraf/plugins/inputs"
+	"github.com/snltd/illumos-telegraf-plugins/helpers"
+)
+
+var sampleConfig = `
+	## The entities whose resource controls you wish to see. Any of "zone", "project" and "task".
+	## Zone controls come from kstats. Project and task controls come from prctl(1), which
+	## briefly stops one process in every project, or in every task if you ask for tasks.
+	## Per-process controls are not collected: look at fdlimit in the process input instead.
+	# entities = ["zone", "project"]
+	## The resource controls you wish to report. If this is unset or empty, every control with a
+	## usage or a privileged limit is reported.
+	# rctls = ["zone.max-lwps", "zone.max-swap", "project.max-shm-memory"]
+	## The zones you wish to examine. If this is unset or empty, all visible zones are examined.
+	# zones = ["zone1", "zone2"]
+`
+
+var (
+	defaultEntities = []string{"zone", "project"}
+	knownEntities   = []string{"zone", "project", "task"}
+)
+
+var newKStatSource = helpers.NewKStatSource
+
+type IllumosRctl struct {
+	Entities []string
+	Rctls    []string
+	Zones    []string
+}
+
+func (s *IllumosRctl) Description() string {
+	return "Reports the usage and limits of zone, project and task resource controls."
+}
+
+func (s *IllumosRctl) SampleConfig() string {
+	return sampleConfig
+}
+
+func (s *IllumosRctl) Init() error {
+	for _, entity := range s.Entities {
+		if !slices.Contains(knownEntities, entity) {
+			return fmt.Errorf("cannot collect resource controls for %q entities", entity)
+		}
+	}
+
+	return nil
+}
+
+func (s *IllumosRctl) entities() []string {
+	if len(s.Entities) == 0 {
+		return defaultEntities
+	}
+
+	return s.Entities
+}
+
+// rctlKey identifies one control on one entity. Project IDs are only unique inside a zone.
+type rctlKey struct {
+	entityType string
+	entityID   string
+	zone       string
+	name       string
+}
+
+type rctlStat struct {
+	entityName string
+	usage      *uint64
+	limit      *uint64
+	action     string
+}
+
+// zoneCaps maps the caps:N:<kstat>_zone_N kstats to the zone controls they follow. usage is what
+// the zone is using and value is its limit, which is noLimit if the zone has none: INT_MAX for
+// things which are counted, and UINT64_MAX for everything else. cpucaps only exists for a zone
+// with a CPU cap.
+var zoneCaps = map[string]struct {
+	rctl    string
+	noLimit uint64
+}{
+	"lwps":      {"zone.max-lwps", math.MaxInt32},
+	"nprocs":    {"zone.max-processes", math.MaxInt32},
+	"swapresv":  {"zone.max-swap", math.MaxUint64},
+	"lockedmem": {"zone.max-locked-memory", math.MaxUint64},
+	"cpucaps":   {"zone.cpu-cap", math.MaxUint64},
+}
+
+// zoneRctls reads zone controls from the caps kstats, which every running zone has, so we don't
+// have to go near any of its processes. The kernel denies anything which would take a zone over
+// one of these limits.
+func zoneRctls(s *IllumosRctl, token helpers.KStatSource) map[rctlKey]*rctlStat {
+	ret := make(map[rctlKey]*rctlStat)
+
+	for _, ks := range helpers.KStatsInModule(token, "caps") {
+		suffix := fmt.Sprintf("_zone_%d", ks.Instance)
+
+		if !strings.HasSuffix(ks.Name, suffix) {
+			continue
+		}
+
+		zoneCap, ok := zoneCaps[strings.TrimSuffix(ks.Name, suffix)]
+		if !ok || !helpers.WeWant(zoneCap.rctl, s.Rctls) {
+			continue
+		}
+
+		zone, err := token.GetNamed("caps", ks.Instance, ks.Name, "zonename")
+		if err != nil || !helpers.WeWant(zone.StringVal, s.Zones) {
+			continue
+		}
+
+		stat := &rctlStat{entityName: zone.StringVal}
+
+		if usage, err := token.GetNamed("caps", ks.Instance, ks.Name, "usage"); err == nil {
+			value := usage.UintVal
+			stat.usage = &value
+		}
+
+		if limit, err := token.GetNamed("caps", ks.Instance, ks.Name, "value"); err == nil &&
+			limit.UintVal != zoneCap.noLimit {
+			value := limit.UintVal
+			stat.limit = &value
+			stat.action = "deny"
+		}
+
+		key := rctlKey{
+			entityType: "zone",
+			entityID:   strconv.Itoa(ks.Instance),
+			zone:       zone.StringVal,
+			name:       zoneCap.rctl,
+		}
+
+		ret[key] = stat
+	}
+
+	return ret
+}
+
+// parsePs turns the output of runPs into a map of pid to zone name, holding the lowest pid in
+// each project we are interested in, or in each task if we want tasks. prctl reports every
+// control on the task and project of a process, so one process from each is enough to see
+// everything, and prctl stops every process it looks at, so we don't want any more.
+func parsePs(s *IllumosRctl, raw string) map[int]string {
+	perTask := slices.Contains(s.entities(), "task")
+	groups := make(map[string]int)
+	zones := make(map[int]string)
+
+	for _, line := range strings.Split(raw, "\n") {
+		chunks := strings.Fields(line)
+
+		if len(chunks) != 4 {
+			continue
+		}
+
+		pid, err := strconv.Atoi(chunks[0])
+		if err != nil {
+			continue
+		}
+
+		taskID, projID, zone := chunks[1], chunks[2], chunks[3]
+
+		if !helpers.WeWant(zone, s.Zones) {
+			continue
+		}
+
+		group := zone + ":" + projID
+
+		if perTask {
+			group = taskID
+		}
+
+		if lowest, ok := groups[group]; !ok || pid < lowest {
+			groups[group] = pid
+		}
+
+		zones[pid] = zone
+	}
+
+	ret := make(map[int]string, len(groups))
+
+	for _, pid := range groups {
+		ret[pid] = zones[pid]
+	}
+
+	return ret
+}
+
+// parseRctls turns the output of runPrctl into one rctlStat per control per project or task. Each
+// process in the output starts with a process header, and we get its zone from the pid. Zone
+// controls are in the output too, but we get those from kstats. Where there is more than one
+// privileged value, the lowest is the limit.
+func parseRctls(s *IllumosRctl, raw string, pidZones map[int]string) map[rctlKey]*rctlStat {
+	ret := make(map[rctlKey]*rctlStat)
+	zone := ""
+
+	for _, rctl := range helpers.ParsePrctl(raw) {
+		if rctl.EntityType == "process" {
+			pid, _ := strconv.Atoi(rctl.EntityID)
+			zone = pidZones[pid]
+
+			continue
+		}
+
+		if rctl.EntityType == "zone" || !slices.Contains(s.entities(), rctl.EntityType) ||
+			!helpers.WeWant(rctl.Name, s.Rctls) {
+			continue
+		}
+
+		if rctl.Privilege != "usage" && rctl.Privilege != "privileged" {
+			continue
+		}
+
+		key := rctlKey{entityType: rctl.EntityType, entityID: rctl.EntityID, zone: zone, name: rctl.Name}
+
+		stat, ok := ret[key]
+		if !ok {
+			stat = &rctlStat{entityName: rctl.EntityName}
+			ret[key] = stat
+		}
+
+		value := rctl.Value
+
+		switch {
+		case rctl.Privilege == "usage":
+			stat.usage = &value
+		case stat.limit == nil || value < *stat.limit:
+			stat.limit = &value
+			stat.action = rctl.Action
+		}
+	}
+
+	return ret
+}
+
+// processRctls gets project and task controls from prctl, run on one process in each.
+func processRctls(s *IllumosRctl) map[rctlKey]*rctlStat {
+	pidZones := parsePs(s, runPs())
+
+	if len(pidZones) == 0 {
+		return nil
+	}
+
+	pids := make([]int, 0, len(pidZones))
+
+	for pid := range pidZones {
+		pids = append(pids, pid)
+	}
+
+	sort.Ints(pids)
+
+	raw, err := runPrctl(pids)
+	if err != nil {
+		log.Printf("cannot get resource controls: %v", err)
+	}
+
+	return parseRctls(s, raw, pidZones)
+}
+
+func (s *IllumosRctl) Gather(acc telegraf.Accumulator) error {
+	stats := make(map[rctlKey]*rctlStat)
+
+	if slices.Contains(s.entities(), "zone") {
+		token, err := newKStatSource()
+		if err != nil {
+			log.Print("cannot get kstat token")
+
+			return err
+		}
+
+		maps.Copy(stats, zoneRctls(s, token))
+		token.Close()
+	}
+
+	if slices.Contains(s.entities(), "project") || slices.Contains(s.entities(), "task") {
+		maps.Copy(stats, processRctls(s))
+	}
+
+	for key, stat := range stats {
+		fields := make(map[string]interface{})
+
+		if stat.usage != nil {
+			fields["usage"] = float64(*stat.usage)
+		}
+
+		if stat.limit != nil {
+			fields["limit"] = float64(*stat.limit)
+		}
+
+		if stat.usage != nil && stat.limit != nil && *stat.limit > 0 {
+			fields["ratio"] = float64(*stat.usage) / float64(*stat.limit)
+		}
+
+		tags := map[string]string{
+			"rctl":   key.name,
+			"entity": key.entityType,
+			"id":     key.entityID,
+			"zone":   key.zone,
+		}
+
+		if stat.entityName != "" {
+			tags["name"] = stat.entityName
+		} else {
+			tags["name"] = key.entityID
+		}
+
+		if stat.action != "" {
+			tags["action"] = stat.action
+		}
+
+		acc.AddFields("rctl", fields, tags)
+	}
+
+	return nil
+}
+
+// Functions below here are vars so they can be injected by the tests.
+
+// runPs lists every process with its task, project and zone.
+var runPs = func() string {
+	stdout, stderr, err := helpers.RunCmd("/usr/bin/ps -e -o pid= -o taskid= -o projid= -o zone=")
+	if err != nil {
+		log.Print(stderr)
+		log.Print(err)
+	}
+
+	return stdout
+}
+
+// runPrctl gets every resource control on the given processes, in prctl's parseable format.
+// prctl exits non-zero if any of the processes has gone, but still reports on the rest.
+var runPrctl = func(pids []int) (string, error) {
+	args := make([]string, len(pids))
+
+	for i, pid := range pids {
+		args[i] = fmt.Sprint(pid)
+	}
+
+	stdout, _, err := helpers.RunCmd("/usr/bin/prctl -P " + strings.Join(args, " "))
+
+	return stdout, err
+}
+
+func init() {
+	inputs.Add("illumos_rctl", func() telegraf.Input { return &IllumosRctl{} })
+}
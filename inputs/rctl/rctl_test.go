@@ -0,0 +1,238 @@
+package rctl
+
+import (
+	"testing"
+	"time"
+
+	"github.com/influxdata/telegraf"
+	"github.com/influxdata/telegraf/testutil"
+	"github.com/snltd/illumos-telegraf-plugins/helpers"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParsePs(t *testing.T) {
+	t.Parallel()
+
+	require.Equal(
+		t,
+		map[int]string{
+			1:    "global",
+			8055: "cube-ws",
+		},
+		parsePs(&IllumosRctl{}, samplePsOutput),
+	)
+
+	require.Equal(
+		t,
+		map[int]string{
+			8055: "cube-ws",
+			8102: "cube-ws",
+		},
+		parsePs(&IllumosRctl{Entities: []string{"task"}, Zones: []string{"cube-ws"}}, samplePsOutput),
+	)
+}
+
+func TestParseRctls(t *testing.T) {
+	t.Parallel()
+
+	usage := uint64(0)
+	limit := uint64(2147483648)
+
+	result := parseRctls(
+		&IllumosRctl{Entities: []string{"zone", "project"}},
+		samplePrctlOutput,
+		map[int]string{8055: "cube-ws"},
+	)
+
+	require.Equal(
+		t,
+		map[rctlKey]*rctlStat{
+			{entityType: "project", entityID: "3", zone: "cube-ws", name: "project.max-shm-memory"}: {
+				entityName: "default",
+				usage:      &usage,
+				limit:      &limit,
+				action:     "deny",
+			},
+		},
+		result,
+	)
+}
+
+func TestZoneRctls(t *testing.T) {
+	t.Parallel()
+
+	token, err := helpers.FixtureKStatSource()()
+	require.NoError(t, err)
+
+	usage := uint64(1)
+	limit := uint64(4096)
+	procs := uint64(1)
+
+	result := zoneRctls(
+		&IllumosRctl{Rctls: []string{"zone.max-lwps", "zone.max-processes"}, Zones: []string{"cube-ws"}},
+		token,
+	)
+
+	require.Equal(
+		t,
+		map[rctlKey]*rctlStat{
+			{entityType: "zone", entityID: "5", zone: "cube-ws", name: "zone.max-lwps"}: {
+				entityName: "cube-ws",
+				usage:      &usage,
+				limit:      &limit,
+				action:     "deny",
+			},
+			{entityType: "zone", entityID: "5", zone: "cube-ws", name: "zone.max-processes"}: {
+				entityName: "cube-ws",
+				usage:      &procs,
+			},
+		},
+		result,
+	)
+
+	require.Len(t, zoneRctls(&IllumosRctl{}, token), 9)
+}
+
+func TestInit(t *testing.T) {
+	t.Parallel()
+
+	require.NoError(t, (&IllumosRctl{}).Init())
+	require.NoError(t, (&IllumosRctl{Entities: []string{"zone", "task"}}).Init())
+	require.Error(t, (&IllumosRctl{Entities: []string{"process"}}).Init())
+}
+
+// Not parallel, because it replaces runPs, runPrctl and newKStatSource.
+//
+//nolint:paralleltest
+func TestPlugin(t *testing.T) {
+	s := &IllumosRctl{
+		Entities: []string{"zone", "project", "task"},
+		Rctls:    []string{"zone.max-swap", "project.max-shm-memory", "task.max-lwps"},
+		Zones:    []string{"cube-ws"},
+	}
+
+	newKStatSource = helpers.FixtureKStatSource()
+
+	runPs = func() string {
+		return samplePsOutput
+	}
+
+	var asked []int
+
+	runPrctl = func(pids []int) (string, error) {
+		asked = pids
+
+		return samplePrctlOutput, nil
+	}
+
+	acc := testutil.Accumulator{}
+	require.NoError(t, s.Gather(&acc))
+	require.Equal(t, []int{8055, 8102}, asked)
+
+	testutil.RequireMetricsEqual(
+		t,
+		testMetrics,
+		acc.GetTelegrafMetrics(),
+		testutil.SortMetrics(),
+		testutil.IgnoreTime())
+}
+
+var testMetrics = []telegraf.Metric{
+	testutil.MustMetric(
+		"rctl",
+		map[string]string{
+			"rctl":   "zone.max-swap",
+			"entity": "zone",
+			"id":     "5",
+			"name":   "cube-ws",
+			"zone":   "cube-ws",
+			"action": "deny",
+		},
+		map[string]interface{}{
+			"usage": float64(2083221504),
+			"limit": float64(4294967296),
+			"ratio": float64(2083221504) / float64(4294967296),
+		},
+		time.Now(),
+	),
+	testutil.MustMetric(
+		"rctl",
+		map[string]string{
+			"rctl":   "project.max-shm-memory",
+			"entity": "project",
+			"id":     "3",
+			"name":   "default",
+			"zone":   "cube-ws",
+			"action": "deny",
+		},
+		map[string]interface{}{
+			"usage": float64(0),
+			"limit": float64(2147483648),
+			"ratio": float64(0),
+		},
+		time.Now(),
+	),
+	testutil.MustMetric(
+		"rctl",
+		map[string]string{
+			"rctl":   "task.max-lwps",
+			"entity": "task",
+			"id":     "769",
+			"name":   "769",
+			"zone":   "cube-ws",
+		},
+		map[string]interface{}{
+			"usage": float64(3),
+		},
+		time.Now(),
+	),
+}
+
+// Not parallel, because it replaces runPs, runPrctl and newKStatSource.
+//
+//nolint:paralleltest
+func TestPluginZonesOnly(t *testing.T) {
+	s := &IllumosRctl{Entities: []string{"zone"}, Rctls: []string{"zone.max-swap"}}
+
+	newKStatSource = helpers.FixtureKStatSource()
+
+	runPs = func() string {
+		t.Fatal("ran ps for zone controls")
+
+		return ""
+	}
+
+	acc := testutil.Accumulator{}
+	require.NoError(t, s.Gather(&acc))
+	require.Len(t, acc.GetTelegrafMetrics(), 2)
+}
+
+var samplePsOutput = `    1     1     0 global
+ 8101   769     3 cube-ws
+ 8055   769     3 cube-ws
+ 8102   770     3 cube-ws
+ junk   771     3 cube-ws
+`
+
+var samplePrctlOutput = `process: 8055: /usr/sbin/cron
+process.max-file-descriptor basic 256 - deny 8055
+process.max-file-descriptor privileged 65536 - deny -
+process.max-file-descriptor system 2147483647 max deny -
+task: 769
+task.max-lwps usage 3
+task.max-lwps system 2147483647 max deny -
+project: 3: default
+project.max-shm-memory usage 0
+project.max-shm-memory privileged 2147483648 - deny -
+project.max-shm-memory system 18446744073709551615 max deny -
+zone: 5: cube-ws
+zone.max-swap usage 2083221504
+zone.max-swap privileged 8589934592 - deny -
+zone.max-swap privileged 4294967296 - deny -
+zone.max-swap system 18446744073709551615 max deny -
+zone.max-lwps usage 1
+zone.max-lwps privileged 4096 - deny -
+zone.max-lwps system 2147483647 max deny -
+zone.cpu-shares usage 20
+zone.cpu-shares privileged 20 - none -
+`
@@ -3,7 +3,6 @@ package smbserver
 import (
 	"log"
 
-	"github.com/illumos/go-kstat"
 	"github.com/influxdata/telegraf"
 	"github.com/influxdata/telegraf/plugins/inputs"
 	"github.com/snltd/illumos-telegraf-plugins/helpers"
@@ -26,28 +25,19 @@ type IllumosSmbServer struct {
 	Fields []string
 }
 
-var getKStats = func() ([]*kstat.KStat, error) {
-	token, err := kstat.Open()
-	if err != nil {
-		log.Print("cannot get kstat token")
-
-		return []*kstat.KStat{}, err
-	}
-
-	token.Close()
-
-	return helpers.KStatsInModule(token, "smbsrv"), nil
-}
+var newKStatSource = helpers.NewKStatSource
 
 func (s *IllumosSmbServer) Gather(acc telegraf.Accumulator) error {
-	kstats, err := getKStats()
-
+	token, err := newKStatSource()
 	if err != nil {
-		log.Println("failed to get smbsrv kstats")
+		log.Print("cannot get kstat token")
+
 		return err
 	}
 
-	for _, stat := range kstats {
+	defer token.Close()
+
+	for _, stat := range helpers.KStatsInModule(token, "smbsrv") {
 		stats, err := stat.AllNamed()
 
 		if err == nil {
@@ -62,7 +52,7 @@ func (s *IllumosSmbServer) Gather(acc telegraf.Accumulator) error {
 	return nil
 }
 
-func parseNamedStats(s *IllumosSmbServer, stats []*kstat.Named) map[string]interface{} {
+func parseNamedStats(s *IllumosSmbServer, stats []*helpers.Named) map[string]interface{} {
 	fields := make(map[string]interface{})
 
 	for _, stat := range stats {
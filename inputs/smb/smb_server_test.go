@@ -16,6 +16,8 @@ func TestPlugin(t *testing.T) {
 		Fields: []string{"open_files", "connections"},
 	}
 
+	newKStatSource = helpers.FixtureKStatSource()
+
 	acc := testutil.Accumulator{}
 	require.NoError(t, s.Gather(&acc))
 	metric := acc.GetTelegrafMetrics()
@@ -2,8 +2,8 @@ package zfsarc
 
 import (
 	"log"
+	"strings"
 
-	"github.com/illumos/go-kstat"
 	"github.com/influxdata/telegraf"
 	"github.com/influxdata/telegraf/plugins/inputs"
 	"github.com/snltd/illumos-telegraf-plugins/helpers"
@@ -14,6 +14,9 @@ var sampleConfig = `
 	# "prefetch_data_misses", "prefetch_metadata_hits", "prefetch_metadata_misses",
 	# "demand_data_hits", "demand_data_misses", "demand_metadata_hits", "demand_metadata_misses",
 	# "l2_size", "l2_read_bytes", "l2_write_bytes", "l2_cksum_bad", "c", "size"]
+	## Emit per-second rates rather than raw counters. Sizes and other gauges are always sent
+	## as they are. Nothing is reported for a counter until it has been seen twice.
+	# rates = false
 `
 
 func (s *IllumosZfsArc) Description() string {
@@ -26,10 +29,43 @@ func (s *IllumosZfsArc) SampleConfig() string {
 
 type IllumosZfsArc struct {
 	Fields []string
+	Rates  bool
+	rates  *helpers.Rates
 }
 
+// arcstats which are not counters, and so are never turned into rates. Anything with a name
+// ending _size or containing _evictable_ is also a gauge.
+var arcGauges = map[string]bool{
+	"p":                     true,
+	"c":                     true,
+	"c_min":                 true,
+	"c_max":                 true,
+	"size":                  true,
+	"hash_elements":         true,
+	"hash_elements_max":     true,
+	"hash_chains":           true,
+	"hash_chain_max":        true,
+	"l2_asize":              true,
+	"l2_log_blk_asize":      true,
+	"l2_log_blk_avg_asize":  true,
+	"l2_log_blk_count":      true,
+	"l2_data_to_meta_ratio": true,
+	"arc_meta_used":         true,
+	"arc_meta_limit":        true,
+	"arc_meta_max":          true,
+	"arc_meta_min":          true,
+}
+
+func isGauge(field string) bool {
+	return arcGauges[field] ||
+		strings.HasSuffix(field, "_size") ||
+		strings.Contains(field, "_evictable_")
+}
+
+var newKStatSource = helpers.NewKStatSource
+
 func (s *IllumosZfsArc) Gather(acc telegraf.Accumulator) error {
-	token, err := kstat.Open()
+	token, err := newKStatSource()
 	if err != nil {
 		log.Print("cannot get kstat token")
 
@@ -38,20 +74,28 @@ func (s *IllumosZfsArc) Gather(acc telegraf.Accumulator) error {
 
 	defer token.Close()
 
+	if s.Rates && s.rates == nil {
+		s.rates = helpers.NewRates()
+	}
+
+	defer s.rates.Sweep()
+
 	stats := helpers.KStatsInModule(token, "zfs")
 
 	for _, statGroup := range stats {
 		if statGroup.Name == "arcstats" {
 			namedStats, err := statGroup.AllNamed()
 
-			if err == nil {
-				acc.AddFields(
-					"zfs.arcstats",
-					parseNamedStats(s, namedStats),
-					map[string]string{},
-				)
-			} else {
+			if err != nil {
 				log.Printf("failed to get named ZFS arcstats for %s\n", statGroup.Name)
+
+				continue
+			}
+
+			fields := parseNamedStats(s, namedStats)
+
+			if len(fields) > 0 {
+				acc.AddFields("zfs.arcstats", fields, map[string]string{})
 			}
 		}
 	}
@@ -59,12 +103,18 @@ func (s *IllumosZfsArc) Gather(acc telegraf.Accumulator) error {
 	return nil
 }
 
-func parseNamedStats(s *IllumosZfsArc, stats []*kstat.Named) map[string]interface{} {
+func parseNamedStats(s *IllumosZfsArc, stats []*helpers.Named) map[string]interface{} {
 	fields := make(map[string]interface{})
 
 	for _, stat := range stats {
-		if helpers.WeWant(stat.Name, s.Fields) {
+		if !helpers.WeWant(stat.Name, s.Fields) {
+			continue
+		}
+
+		if isGauge(stat.Name) {
 			fields[stat.Name] = helpers.NamedValue(stat).(float64)
+		} else if value, ok := s.rates.CounterValue(stat); ok {
+			fields[stat.Name] = value
 		}
 	}
 
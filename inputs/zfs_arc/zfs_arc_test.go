@@ -15,6 +15,8 @@ func TestPlugin(t *testing.T) {
 		Fields: []string{"hits", "l2_hits", "prefetch_data_hits", "prefetch_metadata_hits"},
 	}
 
+	newKStatSource = helpers.FixtureKStatSource()
+
 	acc := testutil.Accumulator{}
 	require.NoError(t, s.Gather(&acc))
 
@@ -49,3 +51,32 @@ func TestParseNamedStats(t *testing.T) {
 		fields,
 	)
 }
+
+// Hit counters are replayed through newKStatSource, alongside c, which is a gauge.
+func TestPluginRates(t *testing.T) { //nolint:paralleltest
+	s := &IllumosZfsArc{
+		Fields: []string{"c", "prefetch_data_hits"},
+		Rates:  true,
+	}
+
+	helpers.UseFixtureReplay(t, &newKStatSource)
+
+	acc := testutil.Accumulator{}
+	require.NoError(t, s.Gather(&acc))
+	require.Equal(
+		t,
+		map[string]interface{}{"c": float64(6753403688)},
+		acc.GetTelegrafMetrics()[0].Fields(),
+	)
+
+	acc.ClearMetrics()
+	require.NoError(t, s.Gather(&acc))
+	require.Equal(
+		t,
+		map[string]interface{}{
+			"c":                  float64(13506807376),
+			"prefetch_data_hits": float64(120917.4),
+		},
+		acc.GetTelegrafMetrics()[0].Fields(),
+	)
+}
@@ -7,7 +7,6 @@ import (
 	"path"
 	"time"
 
-	"github.com/illumos/go-kstat"
 	"github.com/influxdata/telegraf"
 	"github.com/influxdata/telegraf/plugins/inputs"
 	"github.com/snltd/illumos-telegraf-plugins/helpers"
@@ -18,9 +17,10 @@ func (z *IllumosZones) Description() string {
 }
 
 var (
-	sampleConfig = ""
-	makeZoneMap  = helpers.NewZoneMap
-	zoneDir      = "/etc/zones"
+	sampleConfig   = ""
+	makeZoneMap    = helpers.NewZoneMap
+	newKStatSource = helpers.NewKStatSource
+	zoneDir        = "/etc/zones"
 )
 
 type IllumosZones struct{}
@@ -32,7 +32,7 @@ func (z *IllumosZones) Gather(acc telegraf.Accumulator) error {
 }
 
 var zoneBootTime = func(zoneName helpers.ZoneName, zoneID int) (interface{}, error) {
-	token, err := kstat.Open()
+	token, err := newKStatSource()
 	if err != nil {
 		log.Print("cannot get kstat token")
 